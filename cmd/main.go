@@ -23,6 +23,7 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/health/grpc_health_v1"
 	json "k8s.io/component-base/logs/json"
+	"k8s.io/component-base/metrics/legacyregistry"
 	"k8s.io/klog/v2"
 	k8spb "sigs.k8s.io/secrets-store-csi-driver/provider/v1alpha1"
 )
@@ -37,6 +38,8 @@ var (
 	healthzPort    = flag.Int("healthz-port", 8989, "port for health check")
 	healthzPath    = flag.String("healthz-path", "/healthz", "path for health check")
 	healthzTimeout = flag.Duration("healthz-timeout", 5*time.Second, "RPC timeout for health check")
+
+	metricsAddr = flag.String("metrics-addr", "", "address to serve the prometheus metrics on, metrics are not served if empty")
 )
 
 func main() {
@@ -68,9 +71,21 @@ func main() {
 		}()
 	}
 
+	if *metricsAddr != "" {
+		klog.Infof("Serving metrics on address %s", *metricsAddr)
+		go func() {
+			serveMux := http.NewServeMux()
+			serveMux.Handle("/metrics", legacyregistry.Handler())
+			klog.ErrorS(http.ListenAndServe(*metricsAddr, serveMux), "unable to start metrics server")
+		}()
+	}
+
 	if *provider.ConstructPEMChain {
 		klog.Infof("construct pem chain feature enabled")
 	}
+	if *provider.FetchAIAIssuers {
+		klog.Infof("fetch aia issuers feature enabled")
+	}
 	if *provider.DriverWriteSecrets {
 		klog.Infof("secrets will be written to filesystem by the CSI driver")
 	}
@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+const (
+	namespace = "keyvault_provider"
+)
+
+var (
+	revokedCertificates = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      namespace,
+			Name:           "revoked_certificates_total",
+			Help:           "Number of revoked certificates fetched from Key Vault",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"object_name", "pod_namespace"},
+	)
+	generatedSecrets = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      namespace,
+			Name:           "generated_secrets_total",
+			Help:           "Number of secret versions created in Key Vault with a generate policy",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"object_name", "pod_namespace"},
+	)
+)
+
+func init() {
+	legacyregistry.MustRegister(revokedCertificates, generatedSecrets)
+}
+
+// RecordRevokedCertificate records a revoked certificate fetched for the pod
+func RecordRevokedCertificate(objectName, podNamespace string) {
+	revokedCertificates.WithLabelValues(objectName, podNamespace).Inc()
+}
+
+// RecordGeneratedSecret records a secret version created in Key Vault with a generate policy
+func RecordGeneratedSecret(objectName, podNamespace string) {
+	generatedSecrets.WithLabelValues(objectName, podNamespace).Inc()
+}
@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/component-base/metrics/testutil"
+)
+
+func TestRecordRevokedCertificate(t *testing.T) {
+	RecordRevokedCertificate("cert1", "default")
+	RecordRevokedCertificate("cert1", "default")
+
+	expected := `
+		# HELP keyvault_provider_revoked_certificates_total [ALPHA] Number of revoked certificates fetched from Key Vault
+		# TYPE keyvault_provider_revoked_certificates_total counter
+		keyvault_provider_revoked_certificates_total{object_name="cert1",pod_namespace="default"} 2
+	`
+	if err := testutil.GatherAndCompare(legacyregistry.DefaultGatherer, strings.NewReader(expected), "keyvault_provider_revoked_certificates_total"); err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+}
+
+func TestRecordGeneratedSecret(t *testing.T) {
+	RecordGeneratedSecret("db-password", "default")
+
+	expected := `
+		# HELP keyvault_provider_generated_secrets_total [ALPHA] Number of secret versions created in Key Vault with a generate policy
+		# TYPE keyvault_provider_generated_secrets_total counter
+		keyvault_provider_generated_secrets_total{object_name="db-password",pod_namespace="default"} 1
+	`
+	if err := testutil.GatherAndCompare(legacyregistry.DefaultGatherer, strings.NewReader(expected), "keyvault_provider_generated_secrets_total"); err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+}
@@ -0,0 +1,202 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// acrRefreshTokenUsername is the username the registries accept with the refresh tokens
+	acrRefreshTokenUsername = "00000000-0000-0000-0000-000000000000"
+	// acrMaxResponseSize is the maximum size in bytes of the responses of the exchange endpoint
+	acrMaxResponseSize = 1024 * 1024
+
+	// objectFormatPlain writes the username and password of objectType acrToken to separate files
+	objectFormatPlain = "plain"
+	// acrUsernameSuffix and acrPasswordSuffix are appended to the file name of the object
+	// for the files of the username and password with objectFormat plain
+	acrUsernameSuffix = "-username"
+	acrPasswordSuffix = "-password"
+)
+
+// validateACRToken checks if the registry token options are valid for the given object. The
+// registry must be in the cloud of the provider, as the access token of the identity of the
+// mount is sent to it.
+func (p *Provider) validateACRToken(kvObject KeyVaultObject) error {
+	if kvObject.ObjectType != VaultObjectTypeACRToken {
+		return nil
+	}
+	if len(kvObject.Registry) == 0 {
+		return fmt.Errorf("objectType: acrToken requires registry to be set")
+	}
+	// the token is exchanged with the identity of the mount, not read from Key Vault
+	if len(kvObject.ObjectVersion) > 0 {
+		return fmt.Errorf("objectVersion not supported for objectType: acrToken")
+	}
+	if u, err := url.Parse("https://" + kvObject.Registry); err != nil || u.Host != kvObject.Registry || len(u.Port()) > 0 {
+		return fmt.Errorf("invalid registry: %v, should be the login server of the registry such as myregistry.azurecr.io", kvObject.Registry)
+	}
+	if suffix := p.AzureCloudEnvironment.ContainerRegistryDNSSuffix; !strings.HasSuffix(strings.ToLower(kvObject.Registry), "."+suffix) {
+		return fmt.Errorf("invalid registry: %v, should be a registry in the %s cloud such as myregistry.%s", kvObject.Registry, p.AzureCloudEnvironment.Name, suffix)
+	}
+	return nil
+}
+
+// getACRTokenContent exchanges the AAD token of the identity of the mount for a refresh token
+// of the registry, and returns the docker config JSON with the refresh token as the password.
+// The refresh token written by the previous mount is kept until it's close to expiry. The
+// version is the expiry of the refresh token.
+func (p *Provider) getACRTokenContent(ctx context.Context, kvObject KeyVaultObject) (string, string, error) {
+	registry := kvObject.Registry
+	now := time.Now()
+	if refreshToken, expiresOn, ok := p.getPreviousACRRefreshToken(kvObject, now); ok {
+		klog.V(2).InfoS("registry refresh token not due for refresh", "objectName", kvObject.ObjectName, "registry", registry, "expiry", expiresOn, "pod", klog.ObjectRef{Namespace: p.PodNamespace, Name: p.PodName})
+		content, err := renderDockerConfigJSON(registry, acrRefreshTokenUsername, refreshToken)
+		if err != nil {
+			return "", "", err
+		}
+		return string(content), strconv.FormatInt(expiresOn.Unix(), 10), nil
+	}
+
+	// the registries accept the AAD tokens for the resource manager
+	resource := p.AzureCloudEnvironment.ResourceManagerEndpoint
+	spt, err := p.GetServicePrincipalToken(resource)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get service principal token for resource %s, error: %w", resource, err)
+	}
+	if err := spt.EnsureFreshWithContext(ctx); err != nil {
+		return "", "", fmt.Errorf("failed to acquire access token for resource %s, error: %w", resource, err)
+	}
+	client := &http.Client{Timeout: *ACRExchangeTimeout}
+	refreshToken, err := exchangeACRRefreshToken(ctx, client, "https://"+registry, registry, p.TenantID, spt.Token().AccessToken)
+	if err != nil {
+		return "", "", err
+	}
+	expiresOn, err := getACRRefreshTokenExpiry(refreshToken)
+	if err != nil {
+		return "", "", err
+	}
+	klog.InfoS("exchanged access token for registry refresh token", "objectName", kvObject.ObjectName, "registry", registry, "expiry", expiresOn, "pod", klog.ObjectRef{Namespace: p.PodNamespace, Name: p.PodName})
+	content, err := renderDockerConfigJSON(registry, acrRefreshTokenUsername, refreshToken)
+	if err != nil {
+		return "", "", err
+	}
+	return string(content), strconv.FormatInt(expiresOn.Unix(), 10), nil
+}
+
+// getPreviousACRRefreshToken returns the refresh token written by the previous mount if it
+// doesn't expire within tokenRefreshWindow
+func (p *Provider) getPreviousACRRefreshToken(kvObject KeyVaultObject, now time.Time) (string, time.Time, bool) {
+	fileName := getObjectFileName(kvObject)
+	var refreshToken string
+	if strings.EqualFold(kvObject.ObjectFormat, objectFormatPlain) {
+		previous, err := os.ReadFile(filepath.Join(p.TargetPath, fileName+acrPasswordSuffix))
+		if err != nil {
+			return "", time.Time{}, false
+		}
+		refreshToken = string(previous)
+	} else {
+		previous, err := os.ReadFile(filepath.Join(p.TargetPath, fileName))
+		if err != nil {
+			return "", time.Time{}, false
+		}
+		var config dockerConfigJSON
+		if err := json.Unmarshal(previous, &config); err != nil {
+			return "", time.Time{}, false
+		}
+		entry, ok := config.Auths[kvObject.Registry]
+		if !ok || entry.Username != acrRefreshTokenUsername {
+			return "", time.Time{}, false
+		}
+		refreshToken = entry.Password
+	}
+	expiresOn, err := getACRRefreshTokenExpiry(refreshToken)
+	if err != nil || !now.Add(tokenRefreshWindow).Before(expiresOn) {
+		return "", time.Time{}, false
+	}
+	return refreshToken, expiresOn, true
+}
+
+// exchangeACRRefreshToken exchanges the AAD access token for a refresh token of the registry
+// at the OAuth2 exchange endpoint of the registry
+func exchangeACRRefreshToken(ctx context.Context, client *http.Client, registryURL, service, tenantID, accessToken string) (string, error) {
+	form := url.Values{
+		"grant_type":   {"access_token"},
+		"service":      {service},
+		"tenant":       {tenantID},
+		"access_token": {accessToken},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(registryURL, "/")+"/oauth2/exchange", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to exchange access token at registry %s, error: %w", service, err)
+	}
+	defer resp.Body.Close()
+	// read one byte more than the limit to detect responses that are too large
+	body, err := io.ReadAll(io.LimitReader(resp.Body, acrMaxResponseSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(body) > acrMaxResponseSize {
+		return "", fmt.Errorf("response of registry %s exceeds the maximum size of %d bytes", service, acrMaxResponseSize)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to exchange access token at registry %s, status code: %d, response body: %s", service, resp.StatusCode, string(body))
+	}
+	var exchange struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.Unmarshal(body, &exchange); err != nil {
+		return "", fmt.Errorf("failed to parse response of registry %s, error: %w", service, err)
+	}
+	if len(exchange.RefreshToken) == 0 {
+		return "", fmt.Errorf("registry %s did not return a refresh token", service)
+	}
+	return exchange.RefreshToken, nil
+}
+
+// getACRRefreshTokenExpiry returns the expiry of the refresh token from its exp claim
+func getACRRefreshTokenExpiry(refreshToken string) (time.Time, error) {
+	var claims struct {
+		Expiry int64 `json:"exp"`
+	}
+	if err := getUnverifiedJWTClaims(refreshToken, &claims); err != nil {
+		return time.Time{}, fmt.Errorf("failed to read expiry of refresh token, error: %w", err)
+	}
+	if claims.Expiry == 0 {
+		return time.Time{}, fmt.Errorf("failed to read expiry of refresh token, error: exp claim not found")
+	}
+	return time.Unix(claims.Expiry, 0).UTC(), nil
+}
+
+// getACRCredentialFiles returns the files of the username and password of the registry in
+// the docker config JSON for objectFormat plain
+func getACRCredentialFiles(fileName, registry string, content []byte) (map[string][]byte, error) {
+	var config dockerConfigJSON
+	if err := json.Unmarshal(content, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse docker config JSON, error: %w", err)
+	}
+	entry, ok := config.Auths[registry]
+	if !ok {
+		return nil, fmt.Errorf("credentials of registry %s not found", registry)
+	}
+	return map[string][]byte{
+		fileName + acrUsernameSuffix: []byte(entry.Username),
+		fileName + acrPasswordSuffix: []byte(entry.Password),
+	}, nil
+}
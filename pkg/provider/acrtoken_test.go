@@ -0,0 +1,275 @@
+package provider
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+	"golang.org/x/net/context"
+)
+
+func newTestACRRefreshToken(expiresOn time.Time) string {
+	claims := fmt.Sprintf(`{"jti":"5f1f4d3e","sub":"00000000-0000-0000-0000-000000000000","grant_type":"refresh_token","aud":"myregistry.azurecr.io","exp":%d}`, expiresOn.Unix())
+	return "eyJhbGciOiJSUzI1NiIsInR5cCI6IkpXVCJ9." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".c2ln"
+}
+
+func TestValidateACRToken(t *testing.T) {
+	cases := []struct {
+		desc        string
+		object      KeyVaultObject
+		expectedErr error
+	}{
+		{
+			desc:        "object type secret",
+			object:      KeyVaultObject{ObjectName: "acr-password", ObjectType: "secret"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "registry not set",
+			object:      KeyVaultObject{ObjectName: "pull-secret", ObjectType: "acrToken"},
+			expectedErr: fmt.Errorf("objectType: acrToken requires registry to be set"),
+		},
+		{
+			desc:        "object version set",
+			object:      KeyVaultObject{ObjectName: "pull-secret", ObjectType: "acrToken", Registry: "myregistry.azurecr.io", ObjectVersion: "v1"},
+			expectedErr: fmt.Errorf("objectVersion not supported for objectType: acrToken"),
+		},
+		{
+			desc:        "registry with scheme",
+			object:      KeyVaultObject{ObjectName: "pull-secret", ObjectType: "acrToken", Registry: "https://myregistry.azurecr.io"},
+			expectedErr: fmt.Errorf("invalid registry: https://myregistry.azurecr.io, should be the login server of the registry such as myregistry.azurecr.io"),
+		},
+		{
+			desc:        "registry with repository",
+			object:      KeyVaultObject{ObjectName: "pull-secret", ObjectType: "acrToken", Registry: "myregistry.azurecr.io/builds"},
+			expectedErr: fmt.Errorf("invalid registry: myregistry.azurecr.io/builds, should be the login server of the registry such as myregistry.azurecr.io"),
+		},
+		{
+			desc:        "registry outside the cloud",
+			object:      KeyVaultObject{ObjectName: "pull-secret", ObjectType: "acrToken", Registry: "attacker.example.com"},
+			expectedErr: fmt.Errorf("invalid registry: attacker.example.com, should be a registry in the AzurePublicCloud cloud such as myregistry.azurecr.io"),
+		},
+		{
+			desc:        "registry of another cloud",
+			object:      KeyVaultObject{ObjectName: "pull-secret", ObjectType: "acrToken", Registry: "myregistry.azurecr.cn"},
+			expectedErr: fmt.Errorf("invalid registry: myregistry.azurecr.cn, should be a registry in the AzurePublicCloud cloud such as myregistry.azurecr.io"),
+		},
+		{
+			desc:        "valid registry",
+			object:      KeyVaultObject{ObjectName: "pull-secret", ObjectType: "acrToken", Registry: "myregistry.azurecr.io"},
+			expectedErr: nil,
+		},
+	}
+
+	p := &Provider{AzureCloudEnvironment: &azure.PublicCloud}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := p.validateACRToken(tc.object)
+			if tc.expectedErr != nil && (err == nil || err.Error() != tc.expectedErr.Error()) || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestExchangeACRRefreshToken(t *testing.T) {
+	refreshToken := newTestACRRefreshToken(time.Now().Add(3 * time.Hour))
+	cases := []struct {
+		desc          string
+		handler       http.HandlerFunc
+		expectedToken string
+		expectedErr   string
+	}{
+		{
+			desc: "refresh token",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, `{"refresh_token":%q}`, refreshToken)
+			},
+			expectedToken: refreshToken,
+		},
+		{
+			desc: "unauthorized",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusUnauthorized)
+				fmt.Fprint(w, `{"errors":[{"code":"UNAUTHORIZED","message":"authentication required"}]}`)
+			},
+			expectedErr: `failed to exchange access token at registry %s, status code: 401, response body: {"errors":[{"code":"UNAUTHORIZED","message":"authentication required"}]}`,
+		},
+		{
+			desc: "no refresh token",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{}`)
+			},
+			expectedErr: "registry %s did not return a refresh token",
+		},
+		{
+			desc: "response too large",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, strings.Repeat(" ", acrMaxResponseSize+1))
+			},
+			expectedErr: "response of registry %s exceeds the maximum size of 1048576 bytes",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			var requests int
+			server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if r.Method != http.MethodPost || r.URL.Path != "/oauth2/exchange" {
+					t.Errorf("expected POST /oauth2/exchange, got: %s %s", r.Method, r.URL.Path)
+				}
+				if err := r.ParseForm(); err != nil {
+					t.Errorf("expected nil err, got: %v", err)
+				}
+				expectedForm := map[string]string{
+					"grant_type":   "access_token",
+					"service":      r.Host,
+					"tenant":       "tenant-id",
+					"access_token": "aad-token",
+				}
+				for key, value := range expectedForm {
+					if r.PostForm.Get(key) != value {
+						t.Errorf("expected %s: %s, got: %s", key, value, r.PostForm.Get(key))
+					}
+				}
+				tc.handler(w, r)
+			}))
+			defer server.Close()
+			service := strings.TrimPrefix(server.URL, "https://")
+
+			token, err := exchangeACRRefreshToken(context.TODO(), server.Client(), server.URL, service, "tenant-id", "aad-token")
+			if len(tc.expectedErr) > 0 {
+				if expectedErr := fmt.Sprintf(tc.expectedErr, service); err == nil || err.Error() != expectedErr {
+					t.Fatalf("expected err: %s, got: %v", expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected nil err, got: %v", err)
+			}
+			if token != tc.expectedToken {
+				t.Fatalf("expected token: %s, got: %s", tc.expectedToken, token)
+			}
+			if requests != 1 {
+				t.Fatalf("expected 1 request, got: %d", requests)
+			}
+		})
+	}
+}
+
+func TestGetPreviousACRRefreshToken(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	refreshToken := newTestACRRefreshToken(now.Add(3 * time.Hour))
+	expiringToken := newTestACRRefreshToken(now.Add(5 * time.Minute))
+	dockerConfig := func(registry, username, password string) string {
+		content, err := renderDockerConfigJSON(registry, username, password)
+		if err != nil {
+			t.Fatalf("expected nil err, got: %v", err)
+		}
+		return string(content)
+	}
+
+	cases := []struct {
+		desc          string
+		objectFormat  string
+		files         map[string]string
+		expectedToken string
+	}{
+		{
+			desc:          "docker config JSON",
+			files:         map[string]string{"pull-secret": dockerConfig("myregistry.azurecr.io", acrRefreshTokenUsername, refreshToken)},
+			expectedToken: refreshToken,
+		},
+		{
+			desc:  "docker config JSON close to expiry",
+			files: map[string]string{"pull-secret": dockerConfig("myregistry.azurecr.io", acrRefreshTokenUsername, expiringToken)},
+		},
+		{
+			desc:  "docker config JSON of another registry",
+			files: map[string]string{"pull-secret": dockerConfig("other.azurecr.io", acrRefreshTokenUsername, refreshToken)},
+		},
+		{
+			desc:  "docker config JSON with admin credentials",
+			files: map[string]string{"pull-secret": dockerConfig("myregistry.azurecr.io", "myregistry", "admin-password")},
+		},
+		{
+			desc:          "plain password",
+			objectFormat:  "plain",
+			files:         map[string]string{"pull-secret-username": acrRefreshTokenUsername, "pull-secret-password": refreshToken},
+			expectedToken: refreshToken,
+		},
+		{
+			desc:         "plain password written before objectFormat plain was set",
+			objectFormat: "plain",
+			files:        map[string]string{"pull-secret": dockerConfig("myregistry.azurecr.io", acrRefreshTokenUsername, refreshToken)},
+		},
+		{
+			desc: "no previous mount",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tc.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+					t.Fatalf("expected nil err, got: %v", err)
+				}
+			}
+			p := &Provider{TargetPath: dir}
+			kvObject := KeyVaultObject{ObjectName: "pull-secret", ObjectType: "acrToken", Registry: "myregistry.azurecr.io", ObjectFormat: tc.objectFormat}
+
+			token, expiresOn, ok := p.getPreviousACRRefreshToken(kvObject, now)
+			if ok != (len(tc.expectedToken) > 0) || token != tc.expectedToken {
+				t.Fatalf("expected token: %q, got: %q", tc.expectedToken, token)
+			}
+			if ok && !expiresOn.Equal(now.Add(3*time.Hour)) {
+				t.Fatalf("expected expiry: %v, got: %v", now.Add(3*time.Hour), expiresOn)
+			}
+		})
+	}
+}
+
+func TestGetACRRefreshTokenExpiry(t *testing.T) {
+	expiresOn := time.Unix(1700010800, 0).UTC()
+	if actual, err := getACRRefreshTokenExpiry(newTestACRRefreshToken(expiresOn)); err != nil || !actual.Equal(expiresOn) {
+		t.Fatalf("expected expiry: %v, got: %v, err: %v", expiresOn, actual, err)
+	}
+	if _, err := getACRRefreshTokenExpiry("opaque-token"); err == nil || err.Error() != "failed to read expiry of refresh token, error: token is not a JWT" {
+		t.Fatalf("expected not a JWT error, got: %v", err)
+	}
+	noExpiry := "eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"user"}`)) + ".c2ln"
+	if _, err := getACRRefreshTokenExpiry(noExpiry); err == nil || err.Error() != "failed to read expiry of refresh token, error: exp claim not found" {
+		t.Fatalf("expected exp claim not found error, got: %v", err)
+	}
+}
+
+func TestGetACRCredentialFiles(t *testing.T) {
+	content, err := renderDockerConfigJSON("myregistry.azurecr.io", acrRefreshTokenUsername, "refresh-token")
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	files, err := getACRCredentialFiles("acr", "myregistry.azurecr.io", content)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	expected := map[string][]byte{
+		"acr-username": []byte(acrRefreshTokenUsername),
+		"acr-password": []byte("refresh-token"),
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Fatalf("expected files: %v, got: %v", expected, files)
+	}
+	if _, err := getACRCredentialFiles("acr", "other.azurecr.io", content); err == nil || err.Error() != "credentials of registry other.azurecr.io not found" {
+		t.Fatalf("expected registry not found error, got: %v", err)
+	}
+}
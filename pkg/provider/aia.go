@@ -0,0 +1,182 @@
+package provider
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// maxAIADepth is the maximum number of intermediates fetched to complete a chain
+	maxAIADepth = 5
+	// aiaIssuerCacheSize is the maximum number of issuers cached
+	aiaIssuerCacheSize = 256
+	// aiaIssuerCacheTTL is the duration the issuers are cached for
+	aiaIssuerCacheTTL = 24 * time.Hour
+)
+
+// issuerCache caches the issuers downloaded from the AIA CA Issuers URLs. The URLs are
+// read from the mounted certificates, so the number of issuers and the duration they're
+// cached for are limited.
+type issuerCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	issuers map[string]*cachedIssuer
+}
+
+type cachedIssuer struct {
+	cert      *x509.Certificate
+	expiresAt time.Time
+}
+
+var aiaIssuers = newIssuerCache(aiaIssuerCacheSize, aiaIssuerCacheTTL)
+
+func newIssuerCache(size int, ttl time.Duration) *issuerCache {
+	return &issuerCache{size: size, ttl: ttl, issuers: make(map[string]*cachedIssuer)}
+}
+
+func (c *issuerCache) get(issuerURL string) (*x509.Certificate, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	issuer, ok := c.issuers[issuerURL]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(issuer.expiresAt) {
+		delete(c.issuers, issuerURL)
+		return nil, false
+	}
+	return issuer.cert, true
+}
+
+func (c *issuerCache) add(issuerURL string, cert *x509.Certificate) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if _, ok := c.issuers[issuerURL]; !ok && len(c.issuers) >= c.size {
+		// remove the expired issuers, or the issuer that expires first if none expired
+		var oldest string
+		for u, issuer := range c.issuers {
+			if now.After(issuer.expiresAt) {
+				delete(c.issuers, u)
+				continue
+			}
+			if len(oldest) == 0 || issuer.expiresAt.Before(c.issuers[oldest].expiresAt) {
+				oldest = u
+			}
+		}
+		if len(c.issuers) >= c.size {
+			delete(c.issuers, oldest)
+		}
+	}
+	c.issuers[issuerURL] = &cachedIssuer{cert: cert, expiresAt: now.Add(c.ttl)}
+}
+
+// completeCertChain appends the intermediates missing from the PEM certificate data.
+// The intermediates are downloaded from the Authority Information Access CA Issuers
+// URLs of the certificates whose issuer isn't part of the data. Self-signed roots are
+// not appended.
+func completeCertChain(data []byte) ([]byte, error) {
+	certs, err := parseCertificates(data)
+	if err != nil {
+		return nil, err
+	}
+
+	for depth := 0; depth < maxAIADepth; depth++ {
+		cert := findCertWithoutIssuer(certs)
+		if cert == nil {
+			break
+		}
+		issuer, err := fetchIssuer(cert)
+		if err != nil {
+			return nil, err
+		}
+		// reached the root, the chain is complete
+		if issuer == nil || bytes.Equal(issuer.RawIssuer, issuer.RawSubject) {
+			break
+		}
+		certs = append(certs, issuer)
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: certificateType, Bytes: issuer.Raw})...)
+	}
+	return data, nil
+}
+
+// findCertWithoutIssuer returns the first certificate that isn't self-signed and
+// isn't signed by any of the other certificates
+func findCertWithoutIssuer(certs []*x509.Certificate) *x509.Certificate {
+	for _, cert := range certs {
+		if bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+			continue
+		}
+		found := false
+		for _, issuer := range certs {
+			if cert != issuer && cert.CheckSignatureFrom(issuer) == nil {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return cert
+		}
+	}
+	return nil
+}
+
+// fetchIssuer downloads the issuer of the certificate from the first usable AIA CA
+// Issuers URL. Returns nil if the certificate has no http(s) CA Issuers URL.
+func fetchIssuer(cert *x509.Certificate) (*x509.Certificate, error) {
+	for _, issuerURL := range cert.IssuingCertificateURL {
+		u, err := url.Parse(issuerURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			klog.V(5).InfoS("skipping unsupported AIA CA Issuers URL", "url", issuerURL)
+			continue
+		}
+		issuer, ok := aiaIssuers.get(issuerURL)
+		if !ok {
+			if issuer, err = downloadIssuer(issuerURL); err != nil {
+				return nil, fmt.Errorf("failed to fetch issuer of %q from %s, error: %w", cert.Subject.String(), issuerURL, err)
+			}
+			aiaIssuers.add(issuerURL, issuer)
+			klog.InfoS("fetched issuer from AIA CA Issuers URL", "url", issuerURL, "issuer", issuer.Subject.String())
+		}
+		if err := cert.CheckSignatureFrom(issuer); err != nil {
+			return nil, fmt.Errorf("issuer fetched from %s did not sign %q, error: %w", issuerURL, cert.Subject.String(), err)
+		}
+		return issuer, nil
+	}
+	return nil, nil
+}
+
+// downloadIssuer downloads the DER or PEM encoded certificate from the URL
+func downloadIssuer(issuerURL string) (*x509.Certificate, error) {
+	client := &http.Client{Timeout: *AIAFetchTimeout}
+	resp, err := client.Get(issuerURL) // #nosec G107 URL is read from the certificate
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	// read one byte more than the limit to detect responses that are too large
+	body, err := io.ReadAll(io.LimitReader(resp.Body, *AIAMaxIssuerSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > *AIAMaxIssuerSize {
+		return nil, fmt.Errorf("issuer exceeds the maximum size of %d bytes", *AIAMaxIssuerSize)
+	}
+	if block, _ := pem.Decode(body); block != nil && block.Type == certificateType {
+		body = block.Bytes
+	}
+	return x509.ParseCertificate(body)
+}
@@ -0,0 +1,164 @@
+package provider
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCompleteCertChain(t *testing.T) {
+	var requests int32
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	root := newTestCA(t, "Test Root CA", nil)
+	intermediate := newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test Intermediate CA"},
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IssuingCertificateURL: []string{server.URL + "/root.cer"},
+	}, root)
+	leaf := newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "example.com"},
+		IssuingCertificateURL: []string{"ldap://example.com/ca", server.URL + "/intermediate.cer"},
+	}, intermediate)
+	unreachable := newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "unreachable.example.com"},
+		IssuingCertificateURL: []string{server.URL + "/missing.cer"},
+	}, intermediate)
+	large := newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "large.example.com"},
+		IssuingCertificateURL: []string{server.URL + "/large.cer"},
+	}, intermediate)
+	slow := newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "slow.example.com"},
+		IssuingCertificateURL: []string{server.URL + "/slow.cer"},
+	}, intermediate)
+	wrongIssuer := newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "wrong.example.com"},
+		IssuingCertificateURL: []string{server.URL + "/root.cer"},
+	}, intermediate)
+	noAIA := newTestLeaf(t, "noaia.example.com", intermediate)
+
+	mux.HandleFunc("/intermediate.cer", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		_, _ = w.Write(intermediate.cert.Raw)
+	})
+	mux.HandleFunc("/root.cer", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(root.pem()))
+	})
+	mux.HandleFunc("/large.cer", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(make([]byte, 4096))
+	})
+	mux.HandleFunc("/slow.cer", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+		_, _ = w.Write(intermediate.cert.Raw)
+	})
+
+	timeout, maxSize := *AIAFetchTimeout, *AIAMaxIssuerSize
+	*AIAFetchTimeout, *AIAMaxIssuerSize = 100*time.Millisecond, 1024
+	defer func() {
+		*AIAFetchTimeout, *AIAMaxIssuerSize = timeout, maxSize
+	}()
+
+	cases := []struct {
+		desc          string
+		data          string
+		expectedChain string
+		expectedErr   bool
+	}{
+		{
+			desc:          "missing intermediate is appended without the root",
+			data:          leaf.pem(),
+			expectedChain: leaf.pem() + intermediate.pem(),
+		},
+		{
+			desc:          "complete chain is unchanged",
+			data:          leaf.pem() + intermediate.pem() + root.pem(),
+			expectedChain: leaf.pem() + intermediate.pem() + root.pem(),
+		},
+		{
+			desc:          "certificate without AIA URL is unchanged",
+			data:          noAIA.pem(),
+			expectedChain: noAIA.pem(),
+		},
+		{
+			desc:        "issuer not found",
+			data:        unreachable.pem(),
+			expectedErr: true,
+		},
+		{
+			desc:        "issuer exceeds maximum size",
+			data:        large.pem(),
+			expectedErr: true,
+		},
+		{
+			desc:        "issuer download times out",
+			data:        slow.pem(),
+			expectedErr: true,
+		},
+		{
+			desc:        "downloaded certificate is not the issuer",
+			data:        wrongIssuer.pem(),
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			chain, err := completeCertChain([]byte(tc.data))
+			if tc.expectedErr && err == nil || !tc.expectedErr && err != nil {
+				t.Fatalf("expected error: %v, got error: %v", tc.expectedErr, err)
+			}
+			if string(chain) != tc.expectedChain {
+				t.Fatalf("expected chain: %s, got: %s", tc.expectedChain, string(chain))
+			}
+		})
+	}
+
+	// the intermediate is served from the cache for the same URL
+	if _, err := completeCertChain([]byte(leaf.pem())); err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	if actual := atomic.LoadInt32(&requests); actual != 1 {
+		t.Fatalf("expected the issuer to be downloaded once, got: %d", actual)
+	}
+	if _, ok := aiaIssuers.get(server.URL + "/intermediate.cer"); !ok {
+		t.Fatalf("expected issuer to be cached")
+	}
+}
+
+func TestIssuerCache(t *testing.T) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "Test Intermediate CA"}}
+	cache := newIssuerCache(2, time.Hour)
+	cache.add("http://example.com/first.cer", cert)
+	cache.add("http://example.com/second.cer", cert)
+	cache.issuers["http://example.com/first.cer"].expiresAt = time.Now().Add(time.Minute)
+
+	// the issuer that expires first is removed when the cache is full
+	cache.add("http://example.com/third.cer", cert)
+	if len(cache.issuers) != 2 {
+		t.Fatalf("expected 2 cached issuers, got: %d", len(cache.issuers))
+	}
+	if _, ok := cache.get("http://example.com/first.cer"); ok {
+		t.Fatalf("expected first issuer to be removed")
+	}
+	if _, ok := cache.get("http://example.com/third.cer"); !ok {
+		t.Fatalf("expected third issuer to be cached")
+	}
+
+	// the expired issuers are removed
+	cache.issuers["http://example.com/second.cer"].expiresAt = time.Now().Add(-time.Minute)
+	if _, ok := cache.get("http://example.com/second.cer"); ok {
+		t.Fatalf("expected expired issuer not to be returned")
+	}
+	if len(cache.issuers) != 1 {
+		t.Fatalf("expected 1 cached issuer, got: %d", len(cache.issuers))
+	}
+}
@@ -0,0 +1,178 @@
+package provider
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fetchedObject is the content written for an object in the objects array and
+// its version. Objects built from other objects, like bundles, read the content
+// of the objects they reference.
+type fetchedObject struct {
+	content []byte
+	version string
+}
+
+// getObjectFileName returns the name of the file the object is written to
+func getObjectFileName(kvObject KeyVaultObject) string {
+	if kvObject.ObjectAlias != "" {
+		return kvObject.ObjectAlias
+	}
+	return kvObject.ObjectName
+}
+
+// validateObjectRefs checks that the objects referenced by the object are part of the
+// objects array and have one of the object types. The objects are referenced by their
+// file name, which is the objectAlias or the objectName if no alias is set.
+func validateObjectRefs(kvObject KeyVaultObject, objects []KeyVaultObject, objectTypes ...string) error {
+	refs := splitList(kvObject.ObjectRefs)
+	if len(refs) == 0 {
+		return fmt.Errorf("objectRefs is not set")
+	}
+	return validateRefs("objectRefs", refs, objects, objectTypes...)
+}
+
+// validateRefs checks that the objects referenced in the field are part of the objects
+// array and have one of the object types
+func validateRefs(field string, refs []string, objects []KeyVaultObject, objectTypes ...string) error {
+	for _, ref := range refs {
+		var found *KeyVaultObject
+		for i := range objects {
+			if getObjectFileName(objects[i]) == ref {
+				found = &objects[i]
+				break
+			}
+		}
+		if found == nil {
+			return fmt.Errorf("object %s referenced in %s not found in objects", ref, field)
+		}
+		supported := false
+		for _, objectType := range objectTypes {
+			if found.ObjectType == objectType {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			return fmt.Errorf("object %s referenced in %s has unsupported objectType: %s", ref, field, found.ObjectType)
+		}
+	}
+	return nil
+}
+
+// validateBundle checks if the bundle options are valid for the given object
+func validateBundle(kvObject KeyVaultObject, objects []KeyVaultObject) error {
+	if kvObject.ObjectType != VaultObjectTypeBundle {
+		if len(kvObject.ExcludeExpired) > 0 {
+			return fmt.Errorf("excludeExpired only supported for objectType: bundle")
+		}
+		return nil
+	}
+	if len(kvObject.ObjectVersion) > 0 {
+		return fmt.Errorf("objectVersion not supported for objectType: bundle")
+	}
+	if len(kvObject.ExcludeExpired) > 0 {
+		if _, err := strconv.ParseBool(kvObject.ExcludeExpired); err != nil {
+			return fmt.Errorf("failed to parse excludeExpired, error: %w", err)
+		}
+	}
+	if err := validateObjectRefs(kvObject, objects, VaultObjectTypeCertificate, VaultObjectTypeSecret); err != nil {
+		return err
+	}
+	// the bundle is built from the content written for the referenced objects, so their
+	// content has to stay PEM
+	for _, ref := range splitList(kvObject.ObjectRefs) {
+		for _, object := range objects {
+			if getObjectFileName(object) != ref {
+				continue
+			}
+			if len(object.ObjectFormat) > 0 && !strings.EqualFold(object.ObjectFormat, objectFormatPEM) {
+				return fmt.Errorf("object %s referenced in objectRefs has unsupported objectFormat: %s, should be pem", ref, object.ObjectFormat)
+			}
+			stages, err := getEncodingStages(object.ObjectEncoding)
+			if err != nil {
+				return err
+			}
+			for _, stage := range stages {
+				if !isTextEncodingStage(stage) {
+					return fmt.Errorf("object %s referenced in objectRefs has unsupported objectEncoding stage: %s, should be utf-8, trim or ensure-trailing-newline", ref, stage)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// getBundleContent returns the PEM bundle with the certificates of the referenced objects
+// and the version derived from the versions of the referenced objects
+func getBundleContent(kvObject KeyVaultObject, fetchedObjects map[string]fetchedObject) ([]byte, string, error) {
+	var members [][]byte
+	var versions []string
+	for _, ref := range splitList(kvObject.ObjectRefs) {
+		fetched, ok := fetchedObjects[ref]
+		if !ok {
+			return nil, "", fmt.Errorf("object %s referenced in objectRefs not found", ref)
+		}
+		members = append(members, fetched.content)
+		versions = append(versions, fetched.version)
+	}
+	excludeExpired, _ := strconv.ParseBool(kvObject.ExcludeExpired)
+	content, err := buildBundle(members, splitList(kvObject.ObjectRefs), excludeExpired, time.Now())
+	if err != nil {
+		return nil, "", err
+	}
+	return content, getCompositeVersion(versions), nil
+}
+
+// buildBundle returns the PEM bundle with the certificates of the members. The certificates
+// are deduplicated by their SHA-256 fingerprint and sorted by subject and fingerprint, so the
+// bundle is the same regardless of the order of the members. Expired certificates are dropped
+// if excludeExpired is set.
+func buildBundle(members [][]byte, names []string, excludeExpired bool, now time.Time) ([]byte, error) {
+	type bundleCert struct {
+		cert        *x509.Certificate
+		fingerprint [sha256.Size]byte
+	}
+	var certs []bundleCert
+	seen := make(map[[sha256.Size]byte]bool)
+	for i, member := range members {
+		memberCerts, err := parseCertificates(member)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificates of object %s, error: %w", names[i], err)
+		}
+		for _, cert := range memberCerts {
+			fingerprint := sha256.Sum256(cert.Raw)
+			if seen[fingerprint] {
+				continue
+			}
+			seen[fingerprint] = true
+			if excludeExpired && now.After(cert.NotAfter) {
+				continue
+			}
+			certs = append(certs, bundleCert{cert: cert, fingerprint: fingerprint})
+		}
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates left in the bundle after dropping the expired certificates")
+	}
+
+	sort.Slice(certs, func(i, j int) bool {
+		si, sj := certs[i].cert.Subject.String(), certs[j].cert.Subject.String()
+		if si != sj {
+			return si < sj
+		}
+		return bytes.Compare(certs[i].fingerprint[:], certs[j].fingerprint[:]) < 0
+	})
+	var data []byte
+	for _, c := range certs {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: certificateType, Bytes: c.cert.Raw})...)
+	}
+	return data, nil
+}
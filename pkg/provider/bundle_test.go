@@ -0,0 +1,197 @@
+package provider
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestValidateBundle(t *testing.T) {
+	objects := []KeyVaultObject{
+		{ObjectName: "ca1", ObjectType: "cert"},
+		{ObjectName: "ca2", ObjectAlias: "ca2.crt", ObjectType: "secret"},
+		{ObjectName: "key1", ObjectType: "key"},
+		{ObjectName: "ca3", ObjectAlias: "ca3.pfx", ObjectType: "secret", ObjectFormat: "pfx"},
+		{ObjectName: "ca4", ObjectAlias: "ca4.der", ObjectType: "secret", ObjectEncoding: "base64"},
+		{ObjectName: "ca5", ObjectAlias: "ca5.crt", ObjectType: "cert", ObjectFormat: "PEM", ObjectEncoding: "trim,ensure-trailing-newline"},
+	}
+
+	cases := []struct {
+		desc        string
+		object      KeyVaultObject
+		expectedErr error
+	}{
+		{
+			desc:        "not a bundle",
+			object:      KeyVaultObject{ObjectName: "ca1", ObjectType: "cert"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "exclude expired without bundle",
+			object:      KeyVaultObject{ObjectName: "ca1", ObjectType: "cert", ExcludeExpired: "true"},
+			expectedErr: fmt.Errorf("excludeExpired only supported for objectType: bundle"),
+		},
+		{
+			desc:        "object refs not set",
+			object:      KeyVaultObject{ObjectName: "bundle", ObjectType: "bundle"},
+			expectedErr: fmt.Errorf("objectRefs is not set"),
+		},
+		{
+			desc:        "referenced object not found",
+			object:      KeyVaultObject{ObjectName: "bundle", ObjectType: "bundle", ObjectRefs: "ca1,ca2"},
+			expectedErr: fmt.Errorf("object ca2 referenced in objectRefs not found in objects"),
+		},
+		{
+			desc:        "referenced object is a key",
+			object:      KeyVaultObject{ObjectName: "bundle", ObjectType: "bundle", ObjectRefs: "ca1,key1"},
+			expectedErr: fmt.Errorf("object key1 referenced in objectRefs has unsupported objectType: key"),
+		},
+		{
+			desc:        "invalid exclude expired",
+			object:      KeyVaultObject{ObjectName: "bundle", ObjectType: "bundle", ObjectRefs: "ca1", ExcludeExpired: "yes"},
+			expectedErr: fmt.Errorf("failed to parse excludeExpired, error: strconv.ParseBool: parsing \"yes\": invalid syntax"),
+		},
+		{
+			desc:        "object version set",
+			object:      KeyVaultObject{ObjectName: "bundle", ObjectType: "bundle", ObjectRefs: "ca1", ObjectVersion: "v1"},
+			expectedErr: fmt.Errorf("objectVersion not supported for objectType: bundle"),
+		},
+		{
+			desc:        "referenced object with object format pfx",
+			object:      KeyVaultObject{ObjectName: "bundle", ObjectType: "bundle", ObjectRefs: "ca1,ca3.pfx"},
+			expectedErr: fmt.Errorf("object ca3.pfx referenced in objectRefs has unsupported objectFormat: pfx, should be pem"),
+		},
+		{
+			desc:        "referenced object with object encoding base64",
+			object:      KeyVaultObject{ObjectName: "bundle", ObjectType: "bundle", ObjectRefs: "ca1,ca4.der"},
+			expectedErr: fmt.Errorf("object ca4.der referenced in objectRefs has unsupported objectEncoding stage: base64, should be utf-8, trim or ensure-trailing-newline"),
+		},
+		{
+			desc:        "valid bundle",
+			object:      KeyVaultObject{ObjectName: "bundle", ObjectType: "bundle", ObjectRefs: "ca1, ca2.crt, ca5.crt", ExcludeExpired: "true"},
+			expectedErr: nil,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := validateBundle(tc.object, objects)
+			if tc.expectedErr != nil && (err == nil || err.Error() != tc.expectedErr.Error()) || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestBuildBundle(t *testing.T) {
+	ca1 := newTestCA(t, "Test CA 1", nil)
+	ca2 := newTestCA(t, "Test CA 2", nil)
+	expired := newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test CA 0"},
+		NotBefore:             time.Now().Add(-2 * time.Hour),
+		NotAfter:              time.Now().Add(-time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, nil)
+
+	cases := []struct {
+		desc           string
+		members        []string
+		excludeExpired bool
+		expectedCerts  []*x509.Certificate
+		expectedErr    bool
+	}{
+		{
+			desc:          "certificates are sorted by subject",
+			members:       []string{ca2.pem(), ca1.pem() + expired.pem()},
+			expectedCerts: []*x509.Certificate{expired.cert, ca1.cert, ca2.cert},
+		},
+		{
+			desc:          "duplicate certificates are dropped",
+			members:       []string{ca1.pem() + ca2.pem(), ca2.pem(), ca1.pem()},
+			expectedCerts: []*x509.Certificate{ca1.cert, ca2.cert},
+		},
+		{
+			desc:           "expired certificates are dropped",
+			members:        []string{expired.pem(), ca1.pem()},
+			excludeExpired: true,
+			expectedCerts:  []*x509.Certificate{ca1.cert},
+		},
+		{
+			desc:           "all certificates expired",
+			members:        []string{expired.pem()},
+			excludeExpired: true,
+			expectedErr:    true,
+		},
+		{
+			desc:        "member without certificates",
+			members:     []string{ca1.pem(), "password"},
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			var members [][]byte
+			var names []string
+			for i, member := range tc.members {
+				members = append(members, []byte(member))
+				names = append(names, fmt.Sprintf("member%d", i))
+			}
+			data, err := buildBundle(members, names, tc.excludeExpired, time.Now())
+			if tc.expectedErr && err == nil || !tc.expectedErr && err != nil {
+				t.Fatalf("expected error: %v, got error: %v", tc.expectedErr, err)
+			}
+			if tc.expectedErr {
+				return
+			}
+			certs, err := parseCertificates(data)
+			if err != nil {
+				t.Fatalf("expected nil err, got: %v", err)
+			}
+			if len(certs) != len(tc.expectedCerts) {
+				t.Fatalf("expected %d certificates, got: %d", len(tc.expectedCerts), len(certs))
+			}
+			for i := range certs {
+				if !certs[i].Equal(tc.expectedCerts[i]) {
+					t.Fatalf("expected certificate %s at index %d, got: %s", tc.expectedCerts[i].Subject, i, certs[i].Subject)
+				}
+			}
+		})
+	}
+}
+
+func TestGetBundleContent(t *testing.T) {
+	ca1 := newTestCA(t, "Test CA 1", nil)
+	ca2 := newTestCA(t, "Test CA 2", nil)
+	bundle := KeyVaultObject{ObjectName: "bundle", ObjectType: "bundle", ObjectRefs: "ca1,ca2"}
+	fetchedObjects := map[string]fetchedObject{
+		"ca1": {content: []byte(ca1.pem()), version: "v1"},
+		"ca2": {content: []byte(ca2.pem()), version: "v1"},
+	}
+
+	content, version, err := getBundleContent(bundle, fetchedObjects)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	if string(content) != ca1.pem()+ca2.pem() {
+		t.Fatalf("expected bundle with both certificates, got: %s", content)
+	}
+
+	// rotating any of the members changes the version of the bundle
+	fetchedObjects["ca2"] = fetchedObject{content: []byte(ca2.pem()), version: "v2"}
+	_, rotatedVersion, err := getBundleContent(bundle, fetchedObjects)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	if version == rotatedVersion {
+		t.Fatalf("expected version to change after rotation, got: %s", version)
+	}
+
+	delete(fetchedObjects, "ca1")
+	if _, _, err := getBundleContent(bundle, fetchedObjects); err == nil {
+		t.Fatalf("expected error for missing object")
+	}
+}
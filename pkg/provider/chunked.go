@@ -0,0 +1,159 @@
+package provider
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	kv "github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+	"golang.org/x/net/context"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// chunkManifestTag is the tag marking a secret as the manifest of a chunked object
+	chunkManifestTag = "chunk-manifest"
+	// maxChunkParts is the maximum number of parts in a chunked object manifest
+	maxChunkParts = 100
+	// maxConcurrentChunkFetches is the maximum number of parts fetched at the same time
+	maxConcurrentChunkFetches = 4
+)
+
+// chunkManifest lists the secrets the content of a chunked object is split across
+type chunkManifest struct {
+	Parts []chunkPart `json:"parts"`
+}
+
+// chunkPart is a secret containing a part of the content of a chunked object
+type chunkPart struct {
+	// the name of the secret containing the part
+	Name string `json:"name"`
+	// the version of the secret, the latest version is used if not set
+	Version string `json:"version,omitempty"`
+	// the hex encoded SHA-256 of the part to verify it against
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// getPartFunc returns the value and version of the secret containing a part
+type getPartFunc func(ctx context.Context, name, version string) (value, partVersion string, err error)
+
+// isChunkManifest returns true if the secret is tagged as the manifest of a chunked object
+func isChunkManifest(tags map[string]*string) bool {
+	value, ok := tags[chunkManifestTag]
+	if !ok || value == nil {
+		return false
+	}
+	isManifest, err := strconv.ParseBool(strings.TrimSpace(*value))
+	return err == nil && isManifest
+}
+
+// getChunkedContent fetches the parts listed in the manifest with the client of the
+// manifest and returns the reassembled content of the chunked object
+func (p *Provider) getChunkedContent(ctx context.Context, kvClient *kv.BaseClient, vaultURL string, kvObject KeyVaultObject, manifestContent string) (string, error) {
+	manifest, err := parseChunkManifest(manifestContent)
+	if err != nil {
+		return "", err
+	}
+	getPart := func(ctx context.Context, name, version string) (string, string, error) {
+		return getSecretValueWithClient(ctx, kvClient, vaultURL, name, version)
+	}
+	content, err := fetchChunkParts(ctx, manifest.Parts, getPart)
+	if err != nil {
+		return "", err
+	}
+	klog.InfoS("reassembled chunked object from its parts", "objectName", kvObject.ObjectName, "parts", len(manifest.Parts), "size", len(content), "pod", klog.ObjectRef{Namespace: p.PodNamespace, Name: p.PodName})
+	return content, nil
+}
+
+// parseChunkManifest parses and validates the JSON manifest of a chunked object
+func parseChunkManifest(content string) (*chunkManifest, error) {
+	manifest := &chunkManifest{}
+	decoder := json.NewDecoder(strings.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse chunk manifest, error: %w", err)
+	}
+	if len(manifest.Parts) == 0 {
+		return nil, fmt.Errorf("chunk manifest doesn't list any parts")
+	}
+	if len(manifest.Parts) > maxChunkParts {
+		return nil, fmt.Errorf("chunk manifest lists %d parts, should be at most %d", len(manifest.Parts), maxChunkParts)
+	}
+	for i, part := range manifest.Parts {
+		if len(part.Name) == 0 {
+			return nil, fmt.Errorf("name of part %d in chunk manifest is not set", i)
+		}
+		if len(part.SHA256) == 0 {
+			continue
+		}
+		if sum, err := hex.DecodeString(part.SHA256); err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("invalid sha256 %s of part %s in chunk manifest, should be %d hex encoded bytes", part.SHA256, part.Name, sha256.Size)
+		}
+	}
+	return manifest, nil
+}
+
+// fetchChunkParts fetches the parts in parallel, at most maxConcurrentChunkFetches at a
+// time, verifies them against their SHA-256 and returns the parts concatenated in the
+// order of the manifest
+func fetchChunkParts(ctx context.Context, parts []chunkPart, getPart getPartFunc) (string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	values := make([]string, len(parts))
+	var mu sync.Mutex
+	var firstErr error
+	// the first failure is reported and cancels the fetches of the remaining parts
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+	sem := make(chan struct{}, maxConcurrentChunkFetches)
+	var wg sync.WaitGroup
+	for i := range parts {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		// the parts not started yet are skipped once a part failed or the mount is canceled
+		if err := ctx.Err(); err != nil {
+			fail(err)
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			part := parts[i]
+			value, _, err := getPart(ctx, part.Name, part.Version)
+			if err != nil {
+				fail(fmt.Errorf("failed to get part %s, error: %w", part.Name, err))
+				return
+			}
+			if len(part.SHA256) > 0 {
+				sum := sha256.Sum256([]byte(value))
+				if !strings.EqualFold(hex.EncodeToString(sum[:]), part.SHA256) {
+					fail(fmt.Errorf("sha256 of part %s doesn't match the chunk manifest", part.Name))
+					return
+				}
+			}
+			values[i] = value
+		}(i)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return "", firstErr
+	}
+	return strings.Join(values, ""), nil
+}
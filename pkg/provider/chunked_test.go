@@ -0,0 +1,198 @@
+package provider
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"golang.org/x/net/context"
+)
+
+func sha256Hex(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestIsChunkManifest(t *testing.T) {
+	cases := []struct {
+		desc     string
+		tags     map[string]*string
+		expected bool
+	}{
+		{desc: "no tags", tags: nil, expected: false},
+		{desc: "other tags", tags: map[string]*string{"env": to.StringPtr("prod")}, expected: false},
+		{desc: "manifest tag", tags: map[string]*string{"chunk-manifest": to.StringPtr("true")}, expected: true},
+		{desc: "manifest tag set to false", tags: map[string]*string{"chunk-manifest": to.StringPtr("false")}, expected: false},
+		{desc: "manifest tag with invalid value", tags: map[string]*string{"chunk-manifest": to.StringPtr("yes")}, expected: false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if actual := isChunkManifest(tc.tags); actual != tc.expected {
+				t.Fatalf("expected: %v, got: %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestParseChunkManifest(t *testing.T) {
+	cases := []struct {
+		desc        string
+		manifest    string
+		expectedErr error
+	}{
+		{
+			desc:     "valid manifest",
+			manifest: fmt.Sprintf(`{"parts": [{"name": "part-1", "version": "v1", "sha256": "%s"}, {"name": "part-2"}]}`, strings.ToUpper(sha256Hex("a"))),
+		},
+		{
+			desc:        "invalid json",
+			manifest:    "part-1,part-2",
+			expectedErr: fmt.Errorf("failed to parse chunk manifest, error: invalid character 'p' looking for beginning of value"),
+		},
+		{
+			desc:        "unknown field",
+			manifest:    `{"chunks": [{"name": "part-1"}]}`,
+			expectedErr: fmt.Errorf(`failed to parse chunk manifest, error: json: unknown field "chunks"`),
+		},
+		{
+			desc:        "no parts",
+			manifest:    `{"parts": []}`,
+			expectedErr: fmt.Errorf("chunk manifest doesn't list any parts"),
+		},
+		{
+			desc:        "too many parts",
+			manifest:    `{"parts": [` + strings.Repeat(`{"name": "part"},`, 100) + `{"name": "part"}]}`,
+			expectedErr: fmt.Errorf("chunk manifest lists 101 parts, should be at most 100"),
+		},
+		{
+			desc:        "part without name",
+			manifest:    `{"parts": [{"name": "part-1"}, {"version": "v1"}]}`,
+			expectedErr: fmt.Errorf("name of part 1 in chunk manifest is not set"),
+		},
+		{
+			desc:        "invalid sha256",
+			manifest:    `{"parts": [{"name": "part-1", "sha256": "abcd"}]}`,
+			expectedErr: fmt.Errorf("invalid sha256 abcd of part part-1 in chunk manifest, should be 32 hex encoded bytes"),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := parseChunkManifest(tc.manifest)
+			if tc.expectedErr != nil && (err == nil || err.Error() != tc.expectedErr.Error()) || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestFetchChunkParts(t *testing.T) {
+	secrets := map[string]string{
+		"part-1/":   "-----BEGIN CERTIFICATE-----\nMIIB",
+		"part-2/v1": "old",
+		"part-2/v2": "Zm9v\n",
+		"part-3/":   "-----END CERTIFICATE-----\n",
+	}
+	// the first part is the slowest so the parts complete out of order
+	getPart := func(ctx context.Context, name, version string) (string, string, error) {
+		if name == "part-1" {
+			select {
+			case <-time.After(50 * time.Millisecond):
+			case <-ctx.Done():
+				return "", "", ctx.Err()
+			}
+		}
+		value, ok := secrets[name+"/"+version]
+		if !ok {
+			return "", "", fmt.Errorf("secret %s not found", name)
+		}
+		return value, "latest", nil
+	}
+
+	cases := []struct {
+		desc            string
+		parts           []chunkPart
+		expectedContent string
+		expectedErr     error
+	}{
+		{
+			desc: "parts concatenated in order",
+			parts: []chunkPart{
+				{Name: "part-1", SHA256: sha256Hex("-----BEGIN CERTIFICATE-----\nMIIB")},
+				{Name: "part-2", Version: "v2"},
+				{Name: "part-3", SHA256: sha256Hex("-----END CERTIFICATE-----\n")},
+			},
+			expectedContent: "-----BEGIN CERTIFICATE-----\nMIIBZm9v\n-----END CERTIFICATE-----\n",
+		},
+		{
+			desc: "sha256 mismatch",
+			parts: []chunkPart{
+				{Name: "part-1"},
+				{Name: "part-2", Version: "v1", SHA256: sha256Hex("Zm9v\n")},
+				{Name: "part-3"},
+			},
+			expectedErr: fmt.Errorf("sha256 of part part-2 doesn't match the chunk manifest"),
+		},
+		{
+			desc: "missing part cancels the other parts",
+			parts: []chunkPart{
+				{Name: "part-1"},
+				{Name: "part-4"},
+			},
+			expectedErr: fmt.Errorf("failed to get part part-4, error: secret part-4 not found"),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			content, err := fetchChunkParts(context.TODO(), tc.parts, getPart)
+			if tc.expectedErr != nil && (err == nil || err.Error() != tc.expectedErr.Error()) || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+			if tc.expectedErr == nil && content != tc.expectedContent {
+				t.Fatalf("expected content: %q, got: %q", tc.expectedContent, content)
+			}
+		})
+	}
+}
+
+func TestFetchChunkPartsConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	var inFlight, maxInFlight int
+	getPart := func(ctx context.Context, name, version string) (string, string, error) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		return name, "latest", nil
+	}
+
+	parts := make([]chunkPart, maxChunkParts)
+	var expectedContent strings.Builder
+	for i := range parts {
+		parts[i].Name = fmt.Sprintf("part-%d;", i)
+		expectedContent.WriteString(parts[i].Name)
+	}
+	content, err := fetchChunkParts(context.TODO(), parts, getPart)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	if content != expectedContent.String() {
+		t.Fatalf("expected content: %q, got: %q", expectedContent.String(), content)
+	}
+	if maxInFlight > maxConcurrentChunkFetches {
+		t.Fatalf("expected at most %d parts fetched at the same time, got: %d", maxConcurrentChunkFetches, maxInFlight)
+	}
+}
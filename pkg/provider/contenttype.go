@@ -0,0 +1,95 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	contentTypeJSON        = "application/json"
+	contentTypeOctetStream = "application/octet-stream"
+)
+
+// parseContentType returns the lower cased media type of the content type of the secret
+// and whether the base64 parameter is set, for example application/octet-stream;base64
+func parseContentType(contentType string) (mediaType string, base64Param bool) {
+	parts := strings.Split(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(parts[0]))
+	for _, param := range parts[1:] {
+		if strings.EqualFold(strings.TrimSpace(param), objectEncodingBase64) {
+			base64Param = true
+		}
+	}
+	return mediaType, base64Param
+}
+
+// getContentTypeContent converts the content of a secret that isn't backed by a certificate
+// according to its content type when no objectFormat or objectEncoding is set:
+//   - application/x-pkcs12 is converted to PEM
+//   - application/octet-stream;base64 is decoded to the binary content
+//   - application/json is pretty printed
+//
+// Other content types are written as is. The content type is only a hint set when the
+// secret is uploaded, so the content is also written as is if it can't be converted.
+func (p *Provider) getContentTypeContent(kvObject KeyVaultObject, contentType *string, content string) string {
+	if contentType == nil || len(*contentType) == 0 {
+		return content
+	}
+	mediaType, base64Param := parseContentType(*contentType)
+	var action string
+	switch {
+	case mediaType == certTypePfx:
+		action = "convert PKCS#12 to PEM"
+	case mediaType == contentTypeOctetStream && base64Param:
+		action = "decode base64"
+	case mediaType == contentTypeJSON:
+		action = "pretty print JSON"
+	default:
+		return content
+	}
+	// the pkcs12 content is still converted before it's written in the jks, p7b or der format
+	overridden := len(kvObject.ObjectEncoding) > 0 || len(kvObject.ObjectFormat) > 0
+	if mediaType == certTypePfx {
+		overridden = len(kvObject.ObjectEncoding) > 0 || strings.EqualFold(kvObject.ObjectFormat, objectFormatPFX)
+	}
+	if overridden {
+		klog.InfoS("ignoring content type of secret as objectFormat or objectEncoding is set", "contentType", *contentType, "objectFormat", kvObject.ObjectFormat, "objectEncoding", kvObject.ObjectEncoding, "objectName", kvObject.ObjectName, "pod", klog.ObjectRef{Namespace: p.PodNamespace, Name: p.PodName})
+		return content
+	}
+
+	converted, err := convertContentType(mediaType, content)
+	if err != nil {
+		klog.ErrorS(err, "failed to convert secret with its content type, writing the content as is", "contentType", *contentType, "action", action, "objectName", kvObject.ObjectName, "pod", klog.ObjectRef{Namespace: p.PodNamespace, Name: p.PodName})
+		return content
+	}
+	klog.InfoS("using content type of secret as the default format", "contentType", *contentType, "action", action, "objectName", kvObject.ObjectName, "pod", klog.ObjectRef{Namespace: p.PodNamespace, Name: p.PodName})
+	return converted
+}
+
+// convertContentType converts the content of the secret with the media type
+func convertContentType(mediaType, content string) (string, error) {
+	switch mediaType {
+	case certTypePfx:
+		return decodePKCS12(content, "")
+	case contentTypeOctetStream:
+		data, err := base64.StdEncoding.DecodeString(content)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	case contentTypeJSON:
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, []byte(content), "", "  "); err != nil {
+			return "", err
+		}
+		buf.WriteString("\n")
+		return buf.String(), nil
+	default:
+		return "", fmt.Errorf("unsupported content type %s", mediaType)
+	}
+}
@@ -0,0 +1,142 @@
+package provider
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func TestParseContentType(t *testing.T) {
+	cases := []struct {
+		contentType         string
+		expectedMediaType   string
+		expectedBase64Param bool
+	}{
+		{contentType: "application/json", expectedMediaType: "application/json"},
+		{contentType: "application/octet-stream;base64", expectedMediaType: "application/octet-stream", expectedBase64Param: true},
+		{contentType: "Application/Octet-Stream; Base64", expectedMediaType: "application/octet-stream", expectedBase64Param: true},
+		{contentType: "application/json; charset=utf-8", expectedMediaType: "application/json"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.contentType, func(t *testing.T) {
+			mediaType, base64Param := parseContentType(tc.contentType)
+			if mediaType != tc.expectedMediaType || base64Param != tc.expectedBase64Param {
+				t.Fatalf("expected: %s %v, got: %s %v", tc.expectedMediaType, tc.expectedBase64Param, mediaType, base64Param)
+			}
+		})
+	}
+}
+
+func TestGetContentTypeContent(t *testing.T) {
+	root := newTestCA(t, "Test Root CA", nil)
+	leaf := newTestLeaf(t, "example.com", root)
+	pfxData, err := pkcs12.Modern2023.Encode(leaf.key, leaf.cert, []*x509.Certificate{root.cert}, "")
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	pfxContent := base64.StdEncoding.EncodeToString(pfxData)
+	pemContent, err := decodePKCS12(pfxContent, "")
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	binaryContent := string([]byte{0x00, 0xff, 0x10, 0x80})
+
+	cases := []struct {
+		desc            string
+		object          KeyVaultObject
+		contentType     *string
+		content         string
+		expectedContent string
+	}{
+		{
+			desc:            "no content type",
+			object:          KeyVaultObject{ObjectName: "secret1", ObjectType: "secret"},
+			content:         `{"a":1}`,
+			expectedContent: `{"a":1}`,
+		},
+		{
+			desc:            "unknown content type",
+			object:          KeyVaultObject{ObjectName: "secret1", ObjectType: "secret"},
+			contentType:     to.StringPtr("text/plain"),
+			content:         `{"a":1}`,
+			expectedContent: `{"a":1}`,
+		},
+		{
+			desc:            "pkcs12 converted to pem",
+			object:          KeyVaultObject{ObjectName: "secret1", ObjectType: "secret"},
+			contentType:     to.StringPtr("application/x-pkcs12"),
+			content:         pfxContent,
+			expectedContent: pemContent,
+		},
+		{
+			desc:            "pkcs12 converted to pem for objectFormat p7b",
+			object:          KeyVaultObject{ObjectName: "secret1", ObjectType: "secret", ObjectFormat: "p7b"},
+			contentType:     to.StringPtr("application/x-pkcs12"),
+			content:         pfxContent,
+			expectedContent: pemContent,
+		},
+		{
+			desc:            "pkcs12 kept for objectFormat pfx",
+			object:          KeyVaultObject{ObjectName: "secret1", ObjectType: "secret", ObjectFormat: "PFX"},
+			contentType:     to.StringPtr("application/x-pkcs12"),
+			content:         pfxContent,
+			expectedContent: pfxContent,
+		},
+		{
+			desc:            "base64 octet stream decoded",
+			object:          KeyVaultObject{ObjectName: "secret1", ObjectType: "secret"},
+			contentType:     to.StringPtr("application/octet-stream;base64"),
+			content:         base64.StdEncoding.EncodeToString([]byte(binaryContent)),
+			expectedContent: binaryContent,
+		},
+		{
+			desc:            "octet stream without base64 parameter",
+			object:          KeyVaultObject{ObjectName: "secret1", ObjectType: "secret"},
+			contentType:     to.StringPtr("application/octet-stream"),
+			content:         "AP8QgA==",
+			expectedContent: "AP8QgA==",
+		},
+		{
+			desc:            "base64 octet stream with objectEncoding set",
+			object:          KeyVaultObject{ObjectName: "secret1", ObjectType: "secret", ObjectEncoding: "hex"},
+			contentType:     to.StringPtr("application/octet-stream;base64"),
+			content:         "00ff1080",
+			expectedContent: "00ff1080",
+		},
+		{
+			desc:            "json pretty printed",
+			object:          KeyVaultObject{ObjectName: "secret1", ObjectType: "secret"},
+			contentType:     to.StringPtr("application/json"),
+			content:         `{"username":"admin","port":5432,"hosts":["db1"]}`,
+			expectedContent: "{\n  \"username\": \"admin\",\n  \"port\": 5432,\n  \"hosts\": [\n    \"db1\"\n  ]\n}\n",
+		},
+		{
+			desc:            "json with objectFormat set",
+			object:          KeyVaultObject{ObjectName: "secret1", ObjectType: "secret", ObjectFormat: "pem"},
+			contentType:     to.StringPtr("application/json"),
+			content:         `{"a":1}`,
+			expectedContent: `{"a":1}`,
+		},
+		{
+			desc:            "invalid json written as is",
+			object:          KeyVaultObject{ObjectName: "secret1", ObjectType: "secret"},
+			contentType:     to.StringPtr("application/json"),
+			content:         "username=admin",
+			expectedContent: "username=admin",
+		},
+	}
+
+	p := &Provider{}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			content := p.getContentTypeContent(tc.object, tc.contentType, tc.content)
+			if content != tc.expectedContent {
+				t.Fatalf("expected content: %q, got: %q", tc.expectedContent, content)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package provider
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	kv "github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+	"github.com/Azure/go-autorest/autorest/to"
+	"golang.org/x/net/context"
+)
+
+// jweHeader is the protected header of a JWE in compact serialization
+type jweHeader struct {
+	Alg  string   `json:"alg"`
+	Enc  string   `json:"enc"`
+	Kid  string   `json:"kid"`
+	Zip  string   `json:"zip"`
+	Crit []string `json:"crit"`
+}
+
+// wrappedKeyEnvelope is the JSON envelope of a payload encrypted with AES-GCM using a
+// data encryption key wrapped with a Key Vault key
+type wrappedKeyEnvelope struct {
+	// the identifier of the Key Vault key version the data encryption key is wrapped with
+	Kid string `json:"kid"`
+	// the algorithm the data encryption key is wrapped with
+	Alg string `json:"alg"`
+	// the wrapped data encryption key
+	EncryptedKey []byte `json:"encryptedKey"`
+	// the AES-GCM nonce
+	IV []byte `json:"iv"`
+	// the ciphertext followed by the AES-GCM authentication tag
+	Ciphertext []byte `json:"ciphertext"`
+	// the additional authenticated data
+	AAD []byte `json:"aad"`
+}
+
+// encryptedPayload is the parsed JWE or wrapped key envelope
+type encryptedPayload struct {
+	kid          string
+	alg          string
+	encryptedKey []byte
+	// the length of the content encryption key required by the JWE enc, 0 for any AES key length
+	keyLength  int
+	iv         []byte
+	ciphertext []byte
+	aad        []byte
+}
+
+// unwrapKeyFunc unwraps the content encryption key with the version of the Key Vault key
+// and returns it with the identifier of the key version used
+type unwrapKeyFunc func(ctx context.Context, keyVersion, alg string, encryptedKey []byte) (key []byte, kid string, err error)
+
+// jweKeyLengths are the lengths of the content encryption keys of the supported JWE enc values
+var jweKeyLengths = map[string]int{
+	"A128GCM": 16,
+	"A192GCM": 24,
+	"A256GCM": 32,
+}
+
+// validateDecryptWithKey checks if the decryptWithKey option is supported for the given object
+func validateDecryptWithKey(kvObject KeyVaultObject) error {
+	if len(kvObject.DecryptWithKey) == 0 {
+		return nil
+	}
+	// the encrypted payload is read from the Key Vault secret, the provider doesn't
+	// read content from storage blobs or files
+	if kvObject.ObjectType != VaultObjectTypeSecret {
+		return fmt.Errorf("decryptWithKey only supported for objectType: secret")
+	}
+	// the pkcs12 is decoded when the secret is fetched, before it's decrypted
+	if len(kvObject.PfxPasswordSecretName) > 0 || len(kvObject.PfxOutputPasswordSecretName) > 0 {
+		return fmt.Errorf("decryptWithKey not supported with pfxPasswordSecretName or pfxOutputPasswordSecretName")
+	}
+	return nil
+}
+
+// decryptWithKey decrypts the JWE or wrapped key envelope in the content with the Key Vault
+// key set in decryptWithKey and returns the plaintext with the version of the key
+func (p *Provider) decryptWithKey(ctx context.Context, kvObject KeyVaultObject, content string) (string, string, error) {
+	vaultURL, err := p.getVaultURL(ctx)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get vault, error: %w", err)
+	}
+	kvClient, err := p.initializeKvClient()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get keyvault client, error: %w", err)
+	}
+	unwrapKey := func(ctx context.Context, keyVersion, alg string, encryptedKey []byte) ([]byte, string, error) {
+		result, err := kvClient.UnwrapKey(ctx, *vaultURL, kvObject.DecryptWithKey, keyVersion, kv.KeyOperationsParameters{
+			Algorithm: kv.JSONWebKeyEncryptionAlgorithm(alg),
+			Value:     to.StringPtr(base64.RawURLEncoding.EncodeToString(encryptedKey)),
+		})
+		if err != nil {
+			return nil, "", wrapObjectTypeError(err, VaultObjectTypeKey, kvObject.DecryptWithKey, keyVersion)
+		}
+		if result.Result == nil || result.Kid == nil {
+			return nil, "", fmt.Errorf("unwrapped key is nil")
+		}
+		key, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(*result.Result, "="))
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to decode unwrapped key, error: %w", err)
+		}
+		return key, *result.Kid, nil
+	}
+	plaintext, keyVersion, err := decryptPayload(ctx, content, kvObject.DecryptWithKey, unwrapKey)
+	if err != nil {
+		return "", "", err
+	}
+	return string(plaintext), keyVersion, nil
+}
+
+// decryptPayload unwraps the content encryption key of the JWE or wrapped key envelope
+// and decrypts the payload with AES-GCM. The version of the key the content encryption
+// key was wrapped with is taken from the kid if it identifies a version of the key.
+func decryptPayload(ctx context.Context, content, keyName string, unwrapKey unwrapKeyFunc) ([]byte, string, error) {
+	payload, err := parseEncryptedPayload(content)
+	if err != nil {
+		return nil, "", err
+	}
+	switch kv.JSONWebKeyEncryptionAlgorithm(payload.alg) {
+	case kv.RSA15, kv.RSAOAEP, kv.RSAOAEP256:
+	default:
+		return nil, "", fmt.Errorf("unsupported key wrap algorithm %s, should be RSA-OAEP, RSA-OAEP-256 or RSA1_5", payload.alg)
+	}
+
+	key, kid, err := unwrapKey(ctx, getKeyVersionFromKid(payload.kid, keyName), payload.alg, payload.encryptedKey)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to unwrap content encryption key with key %s, error: %w", keyName, err)
+	}
+	if payload.keyLength > 0 && len(key) != payload.keyLength {
+		return nil, "", fmt.Errorf("content encryption key is %d bytes, should be %d bytes", len(key), payload.keyLength)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid content encryption key, error: %w", err)
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(payload.iv))
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid iv, error: %w", err)
+	}
+	plaintext, err := gcm.Open(nil, payload.iv, payload.ciphertext, payload.aad)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decrypt payload, error: %w", err)
+	}
+	return plaintext, getObjectVersion(kid), nil
+}
+
+// parseEncryptedPayload parses a JWE in compact serialization or a JSON wrapped key envelope
+func parseEncryptedPayload(content string) (*encryptedPayload, error) {
+	content = strings.TrimSpace(content)
+	if strings.HasPrefix(content, "{") {
+		var envelope wrappedKeyEnvelope
+		if err := json.Unmarshal([]byte(content), &envelope); err != nil {
+			return nil, fmt.Errorf("failed to parse wrapped key envelope, error: %w", err)
+		}
+		if len(envelope.EncryptedKey) == 0 || len(envelope.IV) == 0 || len(envelope.Ciphertext) == 0 {
+			return nil, fmt.Errorf("wrapped key envelope requires encryptedKey, iv and ciphertext to be set")
+		}
+		return &encryptedPayload{
+			kid:          envelope.Kid,
+			alg:          envelope.Alg,
+			encryptedKey: envelope.EncryptedKey,
+			iv:           envelope.IV,
+			ciphertext:   envelope.Ciphertext,
+			aad:          envelope.AAD,
+		}, nil
+	}
+
+	parts := strings.Split(content, ".")
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("content is neither a JWE in compact serialization nor a wrapped key envelope")
+	}
+	decoded := make([][]byte, len(parts))
+	for i, part := range parts {
+		var err error
+		if decoded[i], err = base64.RawURLEncoding.DecodeString(part); err != nil {
+			return nil, fmt.Errorf("failed to decode part %d of JWE, error: %w", i, err)
+		}
+	}
+	var header jweHeader
+	if err := json.Unmarshal(decoded[0], &header); err != nil {
+		return nil, fmt.Errorf("failed to parse JWE header, error: %w", err)
+	}
+	keyLength, ok := jweKeyLengths[header.Enc]
+	if !ok {
+		return nil, fmt.Errorf("unsupported JWE enc %s, should be A128GCM, A192GCM or A256GCM", header.Enc)
+	}
+	if len(header.Zip) > 0 || len(header.Crit) > 0 {
+		return nil, fmt.Errorf("JWE zip and crit headers are not supported")
+	}
+	return &encryptedPayload{
+		kid:          header.Kid,
+		alg:          header.Alg,
+		encryptedKey: decoded[1],
+		keyLength:    keyLength,
+		iv:           decoded[2],
+		// the authentication tag is appended to the ciphertext as expected by AES-GCM
+		ciphertext: append(decoded[3], decoded[4]...),
+		// the additional authenticated data is the encoded protected header
+		aad: []byte(parts[0]),
+	}, nil
+}
+
+// getKeyVersionFromKid returns the version in the Key Vault key identifier, for example
+// https://myvault.vault.azure.net/keys/mykey/<version>, if it identifies a version of the key
+func getKeyVersionFromKid(kid, keyName string) string {
+	u, err := url.Parse(kid)
+	if err != nil {
+		return ""
+	}
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(segments) != 3 || segments[0] != "keys" || !strings.EqualFold(segments[1], keyName) {
+		return ""
+	}
+	return segments[2]
+}
@@ -0,0 +1,106 @@
+package provider
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	objectEncodingBase64URL             = "base64url"
+	objectEncodingGzip                  = "gzip"
+	objectEncodingZstd                  = "zstd"
+	objectEncodingTrim                  = "trim"
+	objectEncodingEnsureTrailingNewline = "ensure-trailing-newline"
+)
+
+// textEncodingStages only change the whitespace of the content and are supported for
+// all the object types, the other stages decode the content of secrets
+var textEncodingStages = []string{objectEncodingUtf8, objectEncodingTrim, objectEncodingEnsureTrailingNewline}
+
+// getEncodingStages returns the stages of the comma separated objectEncoding in the
+// order they're applied, for example base64,gzip decodes the base64 content and then
+// decompresses it
+func getEncodingStages(objectEncoding string) ([]string, error) {
+	stages := splitList(objectEncoding)
+	for i, stage := range stages {
+		switch normalized := strings.ToLower(stage); normalized {
+		case objectEncodingUtf8, objectEncodingHex, objectEncodingBase64, objectEncodingBase64URL,
+			objectEncodingGzip, objectEncodingZstd, objectEncodingTrim, objectEncodingEnsureTrailingNewline:
+			stages[i] = normalized
+		default:
+			return nil, fmt.Errorf("invalid objectEncoding: %v, should be utf-8, hex, base64, base64url, gzip, zstd, trim or ensure-trailing-newline", stage)
+		}
+	}
+	return stages, nil
+}
+
+// isTextEncodingStage returns true if the stage only changes the whitespace of the content
+func isTextEncodingStage(stage string) bool {
+	for _, textStage := range textEncodingStages {
+		if stage == textStage {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeStage applies the stage to the content. The content produced by the stage
+// can't exceed maxSize bytes, which protects against decompression bombs.
+func decodeStage(content []byte, stage string, maxSize int64) ([]byte, error) {
+	var decoded []byte
+	var err error
+	switch stage {
+	case objectEncodingUtf8:
+		decoded = content
+	case objectEncodingHex:
+		decoded, err = hex.DecodeString(string(content))
+	case objectEncodingBase64:
+		decoded, err = base64.StdEncoding.DecodeString(string(content))
+	case objectEncodingBase64URL:
+		// the padding is optional in base64url
+		decoded, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(string(content), "="))
+	case objectEncodingGzip:
+		var reader *gzip.Reader
+		if reader, err = gzip.NewReader(bytes.NewReader(content)); err != nil {
+			break
+		}
+		defer reader.Close()
+		decoded, err = readLimited(reader, maxSize)
+	case objectEncodingZstd:
+		var decoder *zstd.Decoder
+		if decoder, err = zstd.NewReader(bytes.NewReader(content), zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(uint64(maxSize))); err != nil {
+			break
+		}
+		defer decoder.Close()
+		decoded, err = readLimited(decoder, maxSize)
+	case objectEncodingTrim:
+		decoded = bytes.TrimSpace(content)
+	case objectEncodingEnsureTrailingNewline:
+		decoded = content
+		if !bytes.HasSuffix(content, []byte("\n")) {
+			decoded = append(append(make([]byte, 0, len(content)+1), content...), '\n')
+		}
+	default:
+		return nil, fmt.Errorf("invalid objectEncoding: %v, should be utf-8, hex, base64, base64url, gzip, zstd, trim or ensure-trailing-newline", stage)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s content, error: %w", stage, err)
+	}
+	if int64(len(decoded)) > maxSize {
+		return nil, fmt.Errorf("%s content exceeds the maximum size of %d bytes", stage, maxSize)
+	}
+	return decoded, nil
+}
+
+// readLimited reads up to maxSize+1 bytes so content over the limit is detected
+// without reading it all
+func readLimited(r io.Reader, maxSize int64) ([]byte, error) {
+	return io.ReadAll(io.LimitReader(r, maxSize+1))
+}
@@ -0,0 +1,123 @@
+package provider
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func gzipContent(t *testing.T, content []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(content); err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func zstdContent(t *testing.T, content []byte) []byte {
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	defer encoder.Close()
+	return encoder.EncodeAll(content, nil)
+}
+
+func TestGetContentBytesPipeline(t *testing.T) {
+	config := []byte("{\"username\": \"admin\"}\n")
+	// a decompression bomb of zeros, far larger than the maximum size
+	bomb := make([]byte, 64*1024)
+
+	cases := []struct {
+		desc           string
+		objectContent  string
+		objectEncoding string
+		expectedValue  string
+		expectedErr    error
+	}{
+		{
+			desc:           "base64 then gzip",
+			objectContent:  base64.StdEncoding.EncodeToString(gzipContent(t, config)),
+			objectEncoding: "base64,gzip",
+			expectedValue:  string(config),
+		},
+		{
+			desc:           "base64url without padding then zstd",
+			objectContent:  base64.RawURLEncoding.EncodeToString(zstdContent(t, config)),
+			objectEncoding: "Base64URL, ZSTD",
+			expectedValue:  string(config),
+		},
+		{
+			desc:           "padded base64url",
+			objectContent:  base64.URLEncoding.EncodeToString([]byte("a?b>")),
+			objectEncoding: "base64url",
+			expectedValue:  "a?b>",
+		},
+		{
+			desc:           "trim",
+			objectContent:  "  password\r\n",
+			objectEncoding: "trim",
+			expectedValue:  "password",
+		},
+		{
+			desc:           "trim then ensure trailing newline",
+			objectContent:  "password\n\n",
+			objectEncoding: "trim,ensure-trailing-newline",
+			expectedValue:  "password\n",
+		},
+		{
+			desc:           "ensure trailing newline keeps existing newline",
+			objectContent:  "password\n",
+			objectEncoding: "ensure-trailing-newline",
+			expectedValue:  "password\n",
+		},
+		{
+			desc:           "hex then gzip",
+			objectContent:  fmt.Sprintf("%x", gzipContent(t, config)),
+			objectEncoding: "hex,gzip",
+			expectedValue:  string(config),
+		},
+		{
+			desc:           "gzip bomb",
+			objectContent:  base64.StdEncoding.EncodeToString(gzipContent(t, bomb)),
+			objectEncoding: "base64,gzip",
+			expectedErr:    fmt.Errorf("gzip content exceeds the maximum size of 1024 bytes"),
+		},
+		{
+			desc:           "zstd bomb",
+			objectContent:  base64.StdEncoding.EncodeToString(zstdContent(t, bomb)),
+			objectEncoding: "base64,zstd",
+			expectedErr:    fmt.Errorf("failed to decode zstd content, error: decompressed size exceeds configured limit"),
+		},
+		{
+			desc:           "content not compressed",
+			objectContent:  base64.StdEncoding.EncodeToString(config),
+			objectEncoding: "base64,gzip",
+			expectedErr:    fmt.Errorf("failed to decode gzip content, error: gzip: invalid header"),
+		},
+	}
+
+	defaultMaxSize := *ObjectEncodingMaxSize
+	*ObjectEncodingMaxSize = 1024
+	defer func() { *ObjectEncodingMaxSize = defaultMaxSize }()
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			actualValue, err := getContentBytes(tc.objectContent, tc.objectEncoding)
+			if tc.expectedErr != nil && (err == nil || err.Error() != tc.expectedErr.Error()) || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+			if tc.expectedErr == nil && string(actualValue) != tc.expectedValue {
+				t.Fatalf("expected value: %q, got: %q", tc.expectedValue, string(actualValue))
+			}
+		})
+	}
+}
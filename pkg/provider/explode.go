@@ -0,0 +1,175 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"k8s.io/client-go/util/jsonpath"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	explodeJSON = "json"
+	explodeYAML = "yaml"
+)
+
+// topLevelFieldPath matches the JSONPath of a top level field, which is
+// written to a file named after the field if no alias is set
+var topLevelFieldPath = regexp.MustCompile(`^\$?\.?([A-Za-z0-9_\-]+)$`)
+
+// ExplodeField is a field of a JSON or YAML secret written to its own file
+type ExplodeField struct {
+	// the JSONPath of the field, for example $.username
+	Path string `json:"path" yaml:"path"`
+	// the filename the field will be written to
+	Alias string `json:"alias" yaml:"alias"`
+}
+
+// validateExplode checks if the explode options are valid for the given object
+func validateExplode(kvObject KeyVaultObject) error {
+	if len(kvObject.Explode) == 0 {
+		if len(kvObject.ExplodeFields) > 0 {
+			return fmt.Errorf("explodeFields requires explode to be set")
+		}
+		return nil
+	}
+	if !strings.EqualFold(kvObject.Explode, explodeJSON) && !strings.EqualFold(kvObject.Explode, explodeYAML) {
+		return fmt.Errorf("invalid explode: %v, should be json or yaml", kvObject.Explode)
+	}
+	if kvObject.ObjectType != VaultObjectTypeSecret && kvObject.ObjectType != VaultObjectTypeSops {
+		return fmt.Errorf("explode only supported for objectType: secret, sops")
+	}
+	// the objectFormat of the SOPS documents is the format of the decrypted document
+	if len(kvObject.ObjectFormat) > 0 && (kvObject.ObjectType != VaultObjectTypeSops || strings.EqualFold(kvObject.ObjectFormat, objectFormatDotenv)) {
+		return fmt.Errorf("explode not supported with objectFormat: %s", kvObject.ObjectFormat)
+	}
+	fileNames := make(map[string]bool)
+	for _, field := range kvObject.ExplodeFields {
+		if _, err := parseFieldPath(field.Path); err != nil {
+			return err
+		}
+		fileName, err := getFieldFileName(field)
+		if err != nil {
+			return err
+		}
+		if err := validateFileName(fileName); err != nil {
+			return fmt.Errorf("invalid file name %s for path %s, error: %w", fileName, field.Path, err)
+		}
+		if fileNames[fileName] {
+			return fmt.Errorf("file name %s is used by more than one field", fileName)
+		}
+		fileNames[fileName] = true
+	}
+	return nil
+}
+
+// parseFieldPath parses the JSONPath of the field. The path can be written with or
+// without the surrounding braces, for example $.db.password or {.db.password}.
+func parseFieldPath(path string) (*jsonpath.JSONPath, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("path of explode field is not set")
+	}
+	if !strings.HasPrefix(path, "{") {
+		path = "{" + path + "}"
+	}
+	jp := jsonpath.New("explode")
+	if err := jp.Parse(path); err != nil {
+		return nil, fmt.Errorf("invalid path %s, error: %w", path, err)
+	}
+	return jp, nil
+}
+
+// getFieldFileName returns the file name of the field, which defaults to the
+// name of the field for top level fields
+func getFieldFileName(field ExplodeField) (string, error) {
+	if len(field.Alias) > 0 {
+		return field.Alias, nil
+	}
+	if match := topLevelFieldPath.FindStringSubmatch(field.Path); match != nil {
+		return match[1], nil
+	}
+	return "", fmt.Errorf("alias is required for path %s", field.Path)
+}
+
+// explodeContent parses the JSON or YAML content and returns the files with the values
+// of the fields by file name. Without fields, every top level field is written to a
+// file named after the field. String values are written as is, other values as JSON.
+func explodeContent(content []byte, explode string, fields []ExplodeField) (map[string][]byte, error) {
+	if strings.EqualFold(explode, explodeYAML) {
+		var err error
+		if content, err = yaml.YAMLToJSON(content); err != nil {
+			return nil, fmt.Errorf("failed to parse secret as yaml, error: %w", err)
+		}
+	}
+	// numbers are kept as written instead of being converted to float64
+	var data interface{}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	if err := decoder.Decode(&data); err != nil {
+		return nil, fmt.Errorf("failed to parse secret as %s, error: %w", strings.ToLower(explode), err)
+	}
+
+	files := make(map[string][]byte)
+	if len(fields) == 0 {
+		object, ok := data.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("secret should contain an object to write all the fields")
+		}
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if err := validateFileName(key); err != nil {
+				return nil, fmt.Errorf("invalid file name %s for field %s, error: %w", key, key, err)
+			}
+			value, err := getFieldValue(object[key])
+			if err != nil {
+				return nil, err
+			}
+			files[key] = value
+		}
+		return files, nil
+	}
+
+	for _, field := range fields {
+		jp, err := parseFieldPath(field.Path)
+		if err != nil {
+			return nil, err
+		}
+		results, err := jp.FindResults(data)
+		if err != nil {
+			return nil, fmt.Errorf("path %s not found in secret, error: %w", field.Path, err)
+		}
+		if len(results) != 1 || len(results[0]) != 1 {
+			var count int
+			for _, result := range results {
+				count += len(result)
+			}
+			return nil, fmt.Errorf("path %s matched %d values, should match exactly one", field.Path, count)
+		}
+		fileName, err := getFieldFileName(field)
+		if err != nil {
+			return nil, err
+		}
+		value, err := getFieldValue(results[0][0].Interface())
+		if err != nil {
+			return nil, err
+		}
+		files[fileName] = value
+	}
+	return files, nil
+}
+
+// getFieldValue returns the content written for the value of a field
+func getFieldValue(value interface{}) ([]byte, error) {
+	if s, ok := value.(string); ok {
+		return []byte(s), nil
+	}
+	return json.Marshal(value)
+}
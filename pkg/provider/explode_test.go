@@ -0,0 +1,192 @@
+package provider
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestValidateExplode(t *testing.T) {
+	cases := []struct {
+		desc        string
+		object      string
+		expectedErr error
+	}{
+		{
+			desc:        "explode not configured",
+			object:      "objectName: secret1\nobjectType: secret",
+			expectedErr: nil,
+		},
+		{
+			desc:        "explode fields without explode",
+			object:      "objectName: secret1\nobjectType: secret\nexplodeFields:\n- path: $.username",
+			expectedErr: fmt.Errorf("explodeFields requires explode to be set"),
+		},
+		{
+			desc:        "invalid explode",
+			object:      "objectName: secret1\nobjectType: secret\nexplode: xml",
+			expectedErr: fmt.Errorf("invalid explode: xml, should be json or yaml"),
+		},
+		{
+			desc:        "object type key",
+			object:      "objectName: key1\nobjectType: key\nexplode: json",
+			expectedErr: fmt.Errorf("explode only supported for objectType: secret, sops"),
+		},
+		{
+			desc:        "sops document with object format",
+			object:      "objectName: secret1\nobjectType: sops\nobjectFormat: json\nexplode: json",
+			expectedErr: nil,
+		},
+		{
+			desc:        "sops dotenv document",
+			object:      "objectName: secret1\nobjectType: sops\nobjectFormat: dotenv\nexplode: yaml",
+			expectedErr: fmt.Errorf("explode not supported with objectFormat: dotenv"),
+		},
+		{
+			desc:        "invalid path",
+			object:      "objectName: secret1\nobjectType: secret\nexplode: json\nexplodeFields:\n- path: $.users[\n  alias: users",
+			expectedErr: fmt.Errorf("invalid path {$.users[}, error: unterminated array"),
+		},
+		{
+			desc:        "nested path without alias",
+			object:      "objectName: secret1\nobjectType: secret\nexplode: json\nexplodeFields:\n- path: $.db.password",
+			expectedErr: fmt.Errorf("alias is required for path $.db.password"),
+		},
+		{
+			desc:        "invalid alias",
+			object:      "objectName: secret1\nobjectType: secret\nexplode: json\nexplodeFields:\n- path: $.db.password\n  alias: ../password",
+			expectedErr: fmt.Errorf("invalid file name ../password for path $.db.password, error: file name must not contain '..'"),
+		},
+		{
+			desc:        "duplicate file name",
+			object:      "objectName: secret1\nobjectType: secret\nexplode: json\nexplodeFields:\n- path: $.password\n- path: $.db.password\n  alias: password",
+			expectedErr: fmt.Errorf("file name password is used by more than one field"),
+		},
+		{
+			desc:        "valid explode fields",
+			object:      "objectName: secret1\nobjectType: secret\nexplode: YAML\nexplodeFields:\n- path: $.username\n- path: \"{.db.password}\"\n  alias: db-password",
+			expectedErr: nil,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			var object KeyVaultObject
+			if err := yaml.Unmarshal([]byte(tc.object), &object); err != nil {
+				t.Fatalf("expected nil err, got: %v", err)
+			}
+			err := validateExplode(object)
+			if tc.expectedErr != nil && (err == nil || err.Error() != tc.expectedErr.Error()) || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestExplodeContent(t *testing.T) {
+	jsonContent := `{"username": "admin", "password": "p@ss", "port": 5432, "id": 12345678901234567890, "db": {"hosts": ["db1", "db2"], "tls": true}}`
+	yamlContent := "username: admin\npassword: p@ss\ndb:\n  hosts:\n  - db1\n  - db2\n"
+
+	cases := []struct {
+		desc          string
+		content       string
+		explode       string
+		fields        []ExplodeField
+		expectedFiles map[string]string
+		expectedErr   error
+	}{
+		{
+			desc:    "all top level fields",
+			content: jsonContent,
+			explode: "json",
+			expectedFiles: map[string]string{
+				"username": "admin",
+				"password": "p@ss",
+				"port":     "5432",
+				"id":       "12345678901234567890",
+				"db":       `{"hosts":["db1","db2"],"tls":true}`,
+			},
+		},
+		{
+			desc:    "selected fields with aliases",
+			content: jsonContent,
+			explode: "json",
+			fields: []ExplodeField{
+				{Path: "$.username"},
+				{Path: "$.db.hosts[1]", Alias: "secondary-host"},
+				{Path: "{.db.tls}", Alias: "tls"},
+			},
+			expectedFiles: map[string]string{
+				"username":       "admin",
+				"secondary-host": "db2",
+				"tls":            "true",
+			},
+		},
+		{
+			desc:    "yaml fields",
+			content: yamlContent,
+			explode: "yaml",
+			fields: []ExplodeField{
+				{Path: "$.password"},
+				{Path: "$.db.hosts", Alias: "hosts.json"},
+			},
+			expectedFiles: map[string]string{
+				"password":   "p@ss",
+				"hosts.json": `["db1","db2"]`,
+			},
+		},
+		{
+			desc:        "missing path",
+			content:     jsonContent,
+			explode:     "json",
+			fields:      []ExplodeField{{Path: "$.db.user", Alias: "user"}},
+			expectedErr: fmt.Errorf("path $.db.user not found in secret, error: user is not found"),
+		},
+		{
+			desc:        "path matching more than one value",
+			content:     jsonContent,
+			explode:     "json",
+			fields:      []ExplodeField{{Path: "$.db.hosts[*]", Alias: "hosts"}},
+			expectedErr: fmt.Errorf("path $.db.hosts[*] matched 2 values, should match exactly one"),
+		},
+		{
+			desc:        "invalid json",
+			content:     "username=admin",
+			explode:     "json",
+			expectedErr: fmt.Errorf("failed to parse secret as json, error: invalid character 'u' looking for beginning of value"),
+		},
+		{
+			desc:        "all fields of an array",
+			content:     `["admin"]`,
+			explode:     "json",
+			expectedErr: fmt.Errorf("secret should contain an object to write all the fields"),
+		},
+		{
+			desc:        "top level field with invalid file name",
+			content:     `{"..": "admin"}`,
+			explode:     "json",
+			expectedErr: fmt.Errorf("invalid file name .. for field .., error: file name must not contain '..'"),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			files, err := explodeContent([]byte(tc.content), tc.explode, tc.fields)
+			if tc.expectedErr != nil && (err == nil || err.Error() != tc.expectedErr.Error()) || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+			if tc.expectedErr != nil {
+				return
+			}
+			actualFiles := make(map[string]string)
+			for name, content := range files {
+				actualFiles[name] = string(content)
+			}
+			if !reflect.DeepEqual(actualFiles, tc.expectedFiles) {
+				t.Fatalf("expected files: %v, got: %v", tc.expectedFiles, actualFiles)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package provider
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"github.com/pavlo-v-chernykh/keystore-go/v4"
+	"golang.org/x/net/context"
+)
+
+var (
+	// oidData is the PKCS#7 data content type
+	oidData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	// oidSignedData is the PKCS#7 signed data content type
+	oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+)
+
+// pkcs7ContentInfo is the PKCS#7 ContentInfo without content
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+}
+
+// pkcs7SignedData is the PKCS#7 SignedData structure. A degenerate SignedData
+// has no signers and only carries certificates.
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms []asn1.RawValue `asn1:"set"`
+	ContentInfo      pkcs7ContentInfo
+	Certificates     asn1.RawValue
+	SignerInfos      []asn1.RawValue `asn1:"set"`
+}
+
+// pkcs7Envelope is the outer PKCS#7 ContentInfo wrapping the SignedData
+type pkcs7Envelope struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+// isCertificateFormat returns true if the object format is one of the binary
+// certificate formats the PEM content is converted to before it's written
+func isCertificateFormat(objectFormat string) bool {
+	return strings.EqualFold(objectFormat, objectFormatJKS) || strings.EqualFold(objectFormat, objectFormatP7B) || strings.EqualFold(objectFormat, objectFormatDER)
+}
+
+// isPfxTrustStore returns true if the certificate of objectType cert is converted to a
+// password protected pkcs12 truststore. Key Vault returns the key of a certificate only in
+// its secret, so the pfx of type cert has no private key.
+func isPfxTrustStore(kvObject KeyVaultObject) bool {
+	return kvObject.ObjectType == VaultObjectTypeCertificate && strings.EqualFold(kvObject.ObjectFormat, objectFormatPFX)
+}
+
+// validateCertificateFormat checks if the options of the jks, p7b and der object
+// formats, and of the pfx truststores, are valid for the given object
+func validateCertificateFormat(kvObject KeyVaultObject) error {
+	if !strings.EqualFold(kvObject.ObjectFormat, objectFormatJKS) && len(kvObject.JksPasswordSecretName) > 0 {
+		return fmt.Errorf("jksPasswordSecretName only supported with objectFormat: jks")
+	}
+	if !isCertificateFormat(kvObject.ObjectFormat) && !isPfxTrustStore(kvObject) {
+		return nil
+	}
+	// the content is written as binary, so it can't be decoded again
+	if len(kvObject.ObjectEncoding) > 0 {
+		return fmt.Errorf("objectEncoding not supported with objectFormat: %s", strings.ToLower(kvObject.ObjectFormat))
+	}
+	if strings.EqualFold(kvObject.ObjectFormat, objectFormatJKS) && len(kvObject.JksPasswordSecretName) == 0 {
+		return fmt.Errorf("objectFormat: jks requires jksPasswordSecretName to be set")
+	}
+	if isPfxTrustStore(kvObject) && len(kvObject.PfxOutputPasswordSecretName) == 0 {
+		return fmt.Errorf("objectFormat: pfx requires pfxOutputPasswordSecretName to be set for objectType: cert")
+	}
+	return nil
+}
+
+// getCertificateFormatContent converts the PEM content of the object to the
+// jks, p7b, der or pfx object format
+func (p *Provider) getCertificateFormatContent(ctx context.Context, kvObject KeyVaultObject, content string) ([]byte, error) {
+	switch strings.ToLower(kvObject.ObjectFormat) {
+	case objectFormatJKS:
+		password, _, err := p.getSecretValue(ctx, kvObject.JksPasswordSecretName, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get jks password from secret %s, error: %w", kvObject.JksPasswordSecretName, err)
+		}
+		return encodeJKS(content, kvObject.ObjectName, password)
+	case objectFormatP7B:
+		return encodePKCS7([]byte(content))
+	case objectFormatDER:
+		return encodeDER([]byte(content))
+	case objectFormatPFX:
+		pfx, err := p.getPfxContent(ctx, kvObject, content)
+		if err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.DecodeString(pfx)
+	default:
+		return nil, fmt.Errorf("invalid objectFormat: %v, should be JKS, P7B, DER or PFX", kvObject.ObjectFormat)
+	}
+}
+
+// encodeJKS converts the PEM private key and certificates to a Java KeyStore protected
+// with the password. With a private key, the keystore has a single private key entry
+// with the certificate chain starting at the certificate matching the key. Without a
+// private key, every certificate is added as a trusted certificate entry.
+func encodeJKS(content, alias, password string) ([]byte, error) {
+	if len(password) == 0 {
+		return nil, fmt.Errorf("jks password can't be empty")
+	}
+	var privateKey crypto.Signer
+	var keyDER []byte
+	var certs []*x509.Certificate
+	data := []byte(content)
+	for {
+		block, rest := pem.Decode(data)
+		if block == nil {
+			break
+		}
+		data = rest
+		if block.Type == certificateType {
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			certs = append(certs, cert)
+			continue
+		}
+		if privateKey != nil {
+			return nil, fmt.Errorf("more than one private key found")
+		}
+		key, err := parsePrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		// the keystore stores the private key in PKCS#8
+		if keyDER, err = x509.MarshalPKCS8PrivateKey(key); err != nil {
+			return nil, err
+		}
+		privateKey = signer
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates found")
+	}
+
+	// aliases are written in order so the keystore is the same for the same content
+	ks := keystore.New(keystore.WithOrderedAliases())
+	if privateKey == nil {
+		for i, cert := range certs {
+			entryAlias := alias
+			if len(certs) > 1 {
+				entryAlias = fmt.Sprintf("%s-%d", alias, i)
+			}
+			entry := keystore.TrustedCertificateEntry{
+				CreationTime: cert.NotBefore,
+				Certificate:  keystore.Certificate{Type: "X509", Content: cert.Raw},
+			}
+			if err := ks.SetTrustedCertificateEntry(entryAlias, entry); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		leaf, caCerts, err := getKeyCertificate(privateKey, certs)
+		if err != nil {
+			return nil, err
+		}
+		chain := []keystore.Certificate{{Type: "X509", Content: leaf.Raw}}
+		for _, cert := range caCerts {
+			chain = append(chain, keystore.Certificate{Type: "X509", Content: cert.Raw})
+		}
+		entry := keystore.PrivateKeyEntry{
+			CreationTime:     leaf.NotBefore,
+			PrivateKey:       keyDER,
+			CertificateChain: chain,
+		}
+		// the key is protected with the store password as expected by most java clients
+		if err := ks.SetPrivateKeyEntry(alias, entry, []byte(password)); err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := ks.Store(&buf, []byte(password)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodePKCS7 converts the PEM certificates to a DER encoded degenerate PKCS#7
+// SignedData with the certificates in the order they appear in the data.
+// Private keys in the data are not included.
+func encodePKCS7(data []byte) ([]byte, error) {
+	certs, err := parseCertificates(data)
+	if err != nil {
+		return nil, err
+	}
+	var raw []byte
+	for _, cert := range certs {
+		raw = append(raw, cert.Raw...)
+	}
+	signedData, err := asn1.Marshal(pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: []asn1.RawValue{},
+		ContentInfo:      pkcs7ContentInfo{ContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: raw},
+		SignerInfos:      []asn1.RawValue{},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pkcs7Envelope{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedData},
+	})
+}
+
+// encodeDER returns the DER encoded leaf certificate of the PEM data
+func encodeDER(data []byte) ([]byte, error) {
+	certs, err := parseCertificates(data)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := getLeafCertificate(certs)
+	if err != nil {
+		return nil, err
+	}
+	return leaf.Raw, nil
+}
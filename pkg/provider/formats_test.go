@@ -0,0 +1,287 @@
+package provider
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"testing"
+
+	"github.com/pavlo-v-chernykh/keystore-go/v4"
+)
+
+func TestValidateCertificateFormat(t *testing.T) {
+	cases := []struct {
+		desc        string
+		object      KeyVaultObject
+		expectedErr error
+	}{
+		{
+			desc:        "pem object format",
+			object:      KeyVaultObject{ObjectType: "secret", ObjectFormat: "pem", ObjectEncoding: "base64"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "jks password without jks object format",
+			object:      KeyVaultObject{ObjectType: "secret", ObjectFormat: "pfx", JksPasswordSecretName: "password"},
+			expectedErr: fmt.Errorf("jksPasswordSecretName only supported with objectFormat: jks"),
+		},
+		{
+			desc:        "jks object format without password",
+			object:      KeyVaultObject{ObjectType: "cert", ObjectFormat: "jks"},
+			expectedErr: fmt.Errorf("objectFormat: jks requires jksPasswordSecretName to be set"),
+		},
+		{
+			desc:        "object encoding with binary object format",
+			object:      KeyVaultObject{ObjectType: "secret", ObjectFormat: "DER", ObjectEncoding: "base64"},
+			expectedErr: fmt.Errorf("objectEncoding not supported with objectFormat: der"),
+		},
+		{
+			desc:        "jks object format with password",
+			object:      KeyVaultObject{ObjectType: "secret", ObjectFormat: "JKS", JksPasswordSecretName: "password"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "pfx truststore without password",
+			object:      KeyVaultObject{ObjectType: "cert", ObjectFormat: "PFX"},
+			expectedErr: fmt.Errorf("objectFormat: pfx requires pfxOutputPasswordSecretName to be set for objectType: cert"),
+		},
+		{
+			desc:        "pfx truststore with object encoding",
+			object:      KeyVaultObject{ObjectType: "cert", ObjectFormat: "pfx", ObjectEncoding: "base64", PfxOutputPasswordSecretName: "password"},
+			expectedErr: fmt.Errorf("objectEncoding not supported with objectFormat: pfx"),
+		},
+		{
+			desc:        "pfx truststore with password",
+			object:      KeyVaultObject{ObjectType: "cert", ObjectFormat: "pfx", PfxOutputPasswordSecretName: "password"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "pfx of object type secret with object encoding",
+			object:      KeyVaultObject{ObjectType: "secret", ObjectFormat: "pfx", ObjectEncoding: "base64"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "p7b object format",
+			object:      KeyVaultObject{ObjectType: "cert", ObjectFormat: "p7b"},
+			expectedErr: nil,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := validateCertificateFormat(tc.object)
+			if tc.expectedErr != nil && (err == nil || err.Error() != tc.expectedErr.Error()) || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestEncodeJKS(t *testing.T) {
+	root := newTestCA(t, "Test Root CA", nil)
+	intermediate := newTestCA(t, "Test Intermediate CA", root)
+	leaf := newTestLeaf(t, "example.com", intermediate)
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(leaf.key)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	keyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes}))
+
+	cases := []struct {
+		desc            string
+		content         string
+		password        string
+		expectedChain   []*x509.Certificate
+		expectedTrusted map[string]*x509.Certificate
+		expectedErr     bool
+	}{
+		{
+			desc:          "private key and chain",
+			content:       intermediate.pem() + leaf.pem() + keyPEM,
+			password:      "changeit",
+			expectedChain: []*x509.Certificate{leaf.cert, intermediate.cert},
+		},
+		{
+			desc:            "single certificate",
+			content:         root.pem(),
+			password:        "changeit",
+			expectedTrusted: map[string]*x509.Certificate{"cert1": root.cert},
+		},
+		{
+			desc:            "certificates without private key",
+			content:         intermediate.pem() + root.pem(),
+			password:        "changeit",
+			expectedTrusted: map[string]*x509.Certificate{"cert1-0": intermediate.cert, "cert1-1": root.cert},
+		},
+		{
+			desc:        "empty password",
+			content:     leaf.pem() + keyPEM,
+			expectedErr: true,
+		},
+		{
+			desc:        "no certificates",
+			content:     keyPEM,
+			password:    "changeit",
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			data, err := encodeJKS(tc.content, "cert1", tc.password)
+			if tc.expectedErr && err == nil || !tc.expectedErr && err != nil {
+				t.Fatalf("expected error: %v, got error: %v", tc.expectedErr, err)
+			}
+			if tc.expectedErr {
+				return
+			}
+			ks := keystore.New()
+			if err := ks.Load(bytes.NewReader(data), []byte(tc.password)); err != nil {
+				t.Fatalf("expected nil err, got: %v", err)
+			}
+			if tc.expectedChain != nil {
+				entry, err := ks.GetPrivateKeyEntry("cert1", []byte(tc.password))
+				if err != nil {
+					t.Fatalf("expected nil err, got: %v", err)
+				}
+				if !bytes.Equal(entry.PrivateKey, keyBytes) {
+					t.Fatalf("expected private key to match")
+				}
+				if len(entry.CertificateChain) != len(tc.expectedChain) {
+					t.Fatalf("expected %d certificates in chain, got: %d", len(tc.expectedChain), len(entry.CertificateChain))
+				}
+				for i, cert := range entry.CertificateChain {
+					if !bytes.Equal(cert.Content, tc.expectedChain[i].Raw) {
+						t.Fatalf("expected certificate %s at index %d", tc.expectedChain[i].Subject, i)
+					}
+				}
+			}
+			if len(ks.Aliases()) != len(tc.expectedTrusted) && tc.expectedChain == nil {
+				t.Fatalf("expected %d entries, got: %d", len(tc.expectedTrusted), len(ks.Aliases()))
+			}
+			for alias, cert := range tc.expectedTrusted {
+				entry, err := ks.GetTrustedCertificateEntry(alias)
+				if err != nil {
+					t.Fatalf("expected nil err, got: %v", err)
+				}
+				if !bytes.Equal(entry.Certificate.Content, cert.Raw) {
+					t.Fatalf("expected certificate %s for alias %s", cert.Subject, alias)
+				}
+			}
+		})
+	}
+}
+
+func TestEncodePKCS7(t *testing.T) {
+	root := newTestCA(t, "Test Root CA", nil)
+	intermediate := newTestCA(t, "Test Intermediate CA", root)
+	leaf := newTestLeaf(t, "example.com", intermediate)
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(leaf.key)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	keyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes}))
+
+	cases := []struct {
+		desc          string
+		content       string
+		expectedCerts []*x509.Certificate
+		expectedErr   bool
+	}{
+		{
+			desc:          "certificate chain",
+			content:       leaf.pem() + intermediate.pem() + root.pem(),
+			expectedCerts: []*x509.Certificate{leaf.cert, intermediate.cert, root.cert},
+		},
+		{
+			desc:          "private key is not included",
+			content:       keyPEM + leaf.pem(),
+			expectedCerts: []*x509.Certificate{leaf.cert},
+		},
+		{
+			desc:        "no certificates",
+			content:     keyPEM,
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			data, err := encodePKCS7([]byte(tc.content))
+			if tc.expectedErr && err == nil || !tc.expectedErr && err != nil {
+				t.Fatalf("expected error: %v, got error: %v", tc.expectedErr, err)
+			}
+			if tc.expectedErr {
+				return
+			}
+			var envelope pkcs7Envelope
+			if _, err := asn1.Unmarshal(data, &envelope); err != nil {
+				t.Fatalf("expected nil err, got: %v", err)
+			}
+			if !envelope.ContentType.Equal(oidSignedData) {
+				t.Fatalf("expected content type %v, got: %v", oidSignedData, envelope.ContentType)
+			}
+			var signedData pkcs7SignedData
+			if _, err := asn1.Unmarshal(envelope.Content.Bytes, &signedData); err != nil {
+				t.Fatalf("expected nil err, got: %v", err)
+			}
+			if len(signedData.SignerInfos) != 0 {
+				t.Fatalf("expected no signers, got: %d", len(signedData.SignerInfos))
+			}
+			certs, err := x509.ParseCertificates(signedData.Certificates.Bytes)
+			if err != nil {
+				t.Fatalf("expected nil err, got: %v", err)
+			}
+			if len(certs) != len(tc.expectedCerts) {
+				t.Fatalf("expected %d certificates, got: %d", len(tc.expectedCerts), len(certs))
+			}
+			for i := range certs {
+				if !certs[i].Equal(tc.expectedCerts[i]) {
+					t.Fatalf("expected certificate %s at index %d, got: %s", tc.expectedCerts[i].Subject, i, certs[i].Subject)
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeDER(t *testing.T) {
+	root := newTestCA(t, "Test Root CA", nil)
+	leaf := newTestLeaf(t, "example.com", root)
+
+	cases := []struct {
+		desc         string
+		content      string
+		expectedCert *x509.Certificate
+		expectedErr  bool
+	}{
+		{
+			desc:         "single certificate",
+			content:      root.pem(),
+			expectedCert: root.cert,
+		},
+		{
+			desc:         "leaf is used from the chain",
+			content:      root.pem() + leaf.pem(),
+			expectedCert: leaf.cert,
+		},
+		{
+			desc:        "no certificates",
+			content:     "",
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			data, err := encodeDER([]byte(tc.content))
+			if tc.expectedErr && err == nil || !tc.expectedErr && err != nil {
+				t.Fatalf("expected error: %v, got error: %v", tc.expectedErr, err)
+			}
+			if !tc.expectedErr && !bytes.Equal(data, tc.expectedCert.Raw) {
+				t.Fatalf("expected certificate %s", tc.expectedCert.Subject)
+			}
+		})
+	}
+}
@@ -0,0 +1,403 @@
+package provider
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	kv "github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+	"golang.org/x/net/context"
+	"k8s.io/klog/v2"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/metrics"
+)
+
+const (
+	generateTypePassword = "password"
+	generateTypeBytes    = "bytes"
+	generateTypeRSA      = "rsa"
+	generateTypeEC       = "ec"
+
+	// defaultGenerateLength is the number of characters of the passwords and the number of
+	// random bytes if length isn't set
+	defaultGenerateLength = 32
+	// maxGenerateLength is the maximum number of characters or random bytes
+	maxGenerateLength = 4096
+	// defaultGenerateCharset is the characters the passwords are generated from if charset isn't set
+	defaultGenerateCharset = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+	// defaultGenerateKeySize is the size of the RSA keys if keySize isn't set
+	defaultGenerateKeySize = 2048
+
+	// the tags set on the secret versions created by the provider. generatedBy marks the
+	// versions the racing pods pick the value from, generatedForPod records the pod the version
+	// was created for, and copiedFrom the version whose value was copied by the pods that lost.
+	// generatedWinner is added to the winning version once it's confirmed, so the following
+	// mounts read it without listing the versions.
+	generatedByTag     = "generatedBy"
+	generatedByValue   = "secrets-store-csi-driver-provider-azure"
+	generatedForPodTag = "generatedForPod"
+	copiedFromTag      = "copiedFrom"
+	generatedWinnerTag = "generatedWinner"
+)
+
+// generateClient is the part of the Key Vault client used to generate the secrets
+type generateClient interface {
+	GetSecret(ctx context.Context, vaultBaseURL, secretName, secretVersion string) (kv.SecretBundle, error)
+	SetSecret(ctx context.Context, vaultBaseURL, secretName string, parameters kv.SecretSetParameters) (kv.SecretBundle, error)
+	UpdateSecret(ctx context.Context, vaultBaseURL, secretName, secretVersion string, parameters kv.SecretUpdateParameters) (kv.SecretBundle, error)
+	GetSecretVersionsComplete(ctx context.Context, vaultBaseURL, secretName string, maxresults *int32) (kv.SecretListResultIterator, error)
+}
+
+// GeneratePolicy is the policy the secret is generated with when it doesn't exist in Key Vault
+type GeneratePolicy struct {
+	// the type of the generated value
+	// supported types are password, bytes, rsa, ec
+	Type string `json:"type" yaml:"type"`
+	// the number of characters of the password or the number of random bytes
+	Length string `json:"length" yaml:"length"`
+	// the characters the password is generated from
+	Charset string `json:"charset" yaml:"charset"`
+	// the size in bits of the RSA key
+	KeySize string `json:"keySize" yaml:"keySize"`
+	// the curve of the EC key
+	Curve string `json:"curve" yaml:"curve"`
+}
+
+// validateGenerate checks if the generate policy is valid for the given object
+func validateGenerate(kvObject KeyVaultObject) error {
+	policy := kvObject.Generate
+	if policy == (GeneratePolicy{}) {
+		return nil
+	}
+	if kvObject.ObjectType != VaultObjectTypeSecret {
+		return fmt.Errorf("generate only supported for objectType: secret")
+	}
+	// the generated secret is read with its latest version
+	if len(kvObject.ObjectVersion) > 0 {
+		return fmt.Errorf("generate not supported with objectVersion")
+	}
+	switch strings.ToLower(strings.TrimSpace(policy.Type)) {
+	case generateTypePassword, generateTypeBytes, generateTypeRSA, generateTypeEC:
+	default:
+		return fmt.Errorf("invalid generate.type: %v, should be password, bytes, rsa or ec", policy.Type)
+	}
+	if _, err := getGenerateLength(policy); err != nil {
+		return err
+	}
+	if len(policy.Charset) > 0 && !strings.EqualFold(policy.Type, generateTypePassword) {
+		return fmt.Errorf("generate.charset only supported for generate.type: password")
+	}
+	if _, err := getGenerateKeySize(policy); err != nil {
+		return err
+	}
+	if _, err := getGenerateCurve(policy); err != nil {
+		return err
+	}
+	return nil
+}
+
+// getGenerateLength returns the number of characters of the password or the number of random bytes
+func getGenerateLength(policy GeneratePolicy) (int, error) {
+	length := strings.TrimSpace(policy.Length)
+	if len(length) == 0 {
+		return defaultGenerateLength, nil
+	}
+	if !strings.EqualFold(policy.Type, generateTypePassword) && !strings.EqualFold(policy.Type, generateTypeBytes) {
+		return 0, fmt.Errorf("generate.length only supported for generate.type: password, bytes")
+	}
+	n, err := strconv.Atoi(length)
+	if err != nil || n < 1 || n > maxGenerateLength {
+		return 0, fmt.Errorf("invalid generate.length: %v, should be a number between 1 and %d", policy.Length, maxGenerateLength)
+	}
+	return n, nil
+}
+
+// getGenerateKeySize returns the size in bits of the RSA key
+func getGenerateKeySize(policy GeneratePolicy) (int, error) {
+	keySize := strings.TrimSpace(policy.KeySize)
+	if len(keySize) == 0 {
+		return defaultGenerateKeySize, nil
+	}
+	if !strings.EqualFold(policy.Type, generateTypeRSA) {
+		return 0, fmt.Errorf("generate.keySize only supported for generate.type: rsa")
+	}
+	switch keySize {
+	case "2048", "3072", "4096":
+		return strconv.Atoi(keySize)
+	default:
+		return 0, fmt.Errorf("invalid generate.keySize: %v, should be 2048, 3072 or 4096", policy.KeySize)
+	}
+}
+
+// getGenerateCurve returns the curve of the EC key, P-256 if not set
+func getGenerateCurve(policy GeneratePolicy) (elliptic.Curve, error) {
+	curve := strings.TrimSpace(policy.Curve)
+	if len(curve) == 0 {
+		return elliptic.P256(), nil
+	}
+	if !strings.EqualFold(policy.Type, generateTypeEC) {
+		return nil, fmt.Errorf("generate.curve only supported for generate.type: ec")
+	}
+	switch strings.ToUpper(curve) {
+	case string(kv.P256), string(kv.P384), string(kv.P521):
+		return getCurve(kv.JSONWebKeyCurveName(strings.ToUpper(curve)))
+	default:
+		return nil, fmt.Errorf("invalid generate.curve: %v, should be P-256, P-384 or P-521", policy.Curve)
+	}
+}
+
+// generateSecretValue generates the value of the secret and returns it with its content type.
+// The random bytes are base64 encoded and the keys are PKCS#8 PEM encoded, with the content
+// types the secrets are converted with when they're mounted.
+func generateSecretValue(policy GeneratePolicy) (string, string, error) {
+	switch strings.ToLower(strings.TrimSpace(policy.Type)) {
+	case generateTypePassword:
+		length, err := getGenerateLength(policy)
+		if err != nil {
+			return "", "", err
+		}
+		charset := []rune(policy.Charset)
+		if len(charset) == 0 {
+			charset = []rune(defaultGenerateCharset)
+		}
+		password := make([]rune, length)
+		for i := range password {
+			n, err := rand.Int(rand.Reader, big.NewInt(int64(len(charset))))
+			if err != nil {
+				return "", "", err
+			}
+			password[i] = charset[n.Int64()]
+		}
+		return string(password), "", nil
+	case generateTypeBytes:
+		length, err := getGenerateLength(policy)
+		if err != nil {
+			return "", "", err
+		}
+		data := make([]byte, length)
+		if _, err := rand.Read(data); err != nil {
+			return "", "", err
+		}
+		return base64.StdEncoding.EncodeToString(data), contentTypeOctetStream + ";base64", nil
+	case generateTypeRSA, generateTypeEC:
+		var key crypto.Signer
+		if strings.EqualFold(policy.Type, generateTypeRSA) {
+			keySize, err := getGenerateKeySize(policy)
+			if err != nil {
+				return "", "", err
+			}
+			if key, err = rsa.GenerateKey(rand.Reader, keySize); err != nil {
+				return "", "", err
+			}
+		} else {
+			curve, err := getGenerateCurve(policy)
+			if err != nil {
+				return "", "", err
+			}
+			if key, err = ecdsa.GenerateKey(curve, rand.Reader); err != nil {
+				return "", "", err
+			}
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return "", "", err
+		}
+		return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), certTypePem, nil
+	default:
+		return "", "", fmt.Errorf("invalid generate.type: %v, should be password, bytes, rsa or ec", policy.Type)
+	}
+}
+
+// generateSecret creates the secret objectName with the generate policy if it doesn't exist
+// and returns the version the mount reads, or an empty version to read the latest version.
+//
+// Key Vault has no conditional create for secrets, so when several pods race to create the
+// secret each pod creates a version and then lists the versions. The oldest enabled version
+// created by the provider wins. The pods that lost copy the value of the winning version to
+// a new version, so the latest version has the winning value, and disable their own version.
+// All the pods mount the winning value. The pod that won lists the versions again after
+// GenerateListDelay, as the versions created concurrently may not be listed right away, and
+// then tags its version with generatedWinner so the following mounts read the latest version
+// without listing the versions. A version that isn't listed within the delay isn't detected.
+func (p *Provider) generateSecret(ctx context.Context, kvObject KeyVaultObject) (string, error) {
+	vaultURL, err := p.getVaultURL(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get vault, error: %w", err)
+	}
+	kvClient, err := p.initializeKvClient()
+	if err != nil {
+		return "", fmt.Errorf("failed to get keyvault client, error: %w", err)
+	}
+	return p.generateSecretWithClient(ctx, kvClient, *vaultURL, kvObject)
+}
+
+// generateSecretWithClient generates the secret with the given client, see generateSecret
+func (p *Provider) generateSecretWithClient(ctx context.Context, kvClient generateClient, vaultURL string, kvObject KeyVaultObject) (string, error) {
+	latest, err := kvClient.GetSecret(ctx, vaultURL, kvObject.ObjectName, "")
+	if err == nil {
+		// a generated version that isn't a copy or confirmed may be the version of a pod that
+		// lost the race and hasn't copied the winning value yet, the winning version is read then
+		if to.String(latest.Tags[generatedByTag]) != generatedByValue || latest.Tags[copiedFromTag] != nil || latest.Tags[generatedWinnerTag] != nil {
+			return "", nil
+		}
+		return getGeneratedSecretVersionWithClient(ctx, kvClient, vaultURL, kvObject.ObjectName)
+	}
+	if !isNotFoundError(err) {
+		// the secret is read normally, the errors other than not found are returned then
+		return "", nil
+	}
+
+	klog.InfoS("secret not found, generating secret with the generate policy, requires the set and list permissions on secrets", "objectName", kvObject.ObjectName, "type", kvObject.Generate.Type, "keyvault", p.KeyvaultName, "pod", klog.ObjectRef{Namespace: p.PodNamespace, Name: p.PodName})
+	value, contentType, err := generateSecretValue(kvObject.Generate)
+	if err != nil {
+		return "", err
+	}
+	tags := map[string]*string{
+		generatedByTag:     to.StringPtr(generatedByValue),
+		generatedForPodTag: to.StringPtr(p.PodNamespace + "/" + p.PodName),
+	}
+	version, err := p.setSecret(ctx, kvClient, vaultURL, kvObject.ObjectName, value, contentType, tags)
+	if err != nil {
+		return "", err
+	}
+	klog.InfoS("created secret version", "objectName", kvObject.ObjectName, "objectVersion", version, "keyvault", p.KeyvaultName, "pod", klog.ObjectRef{Namespace: p.PodNamespace, Name: p.PodName})
+	metrics.RecordGeneratedSecret(kvObject.ObjectName, p.PodNamespace)
+
+	winner, err := getGeneratedSecretVersionWithClient(ctx, kvClient, vaultURL, kvObject.ObjectName)
+	if err != nil {
+		return "", err
+	}
+	if len(winner) == 0 || winner == version {
+		// the versions are listed again before the version is confirmed, see generateSecret
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(*GenerateListDelay):
+		}
+		if winner, err = getGeneratedSecretVersionWithClient(ctx, kvClient, vaultURL, kvObject.ObjectName); err != nil {
+			return "", err
+		}
+	}
+	if len(winner) == 0 || winner == version {
+		tags[generatedWinnerTag] = to.StringPtr("true")
+		if _, err := kvClient.UpdateSecret(ctx, vaultURL, kvObject.ObjectName, version, kv.SecretUpdateParameters{Tags: tags}); err != nil {
+			// the versions are listed on the following mounts until the winner is confirmed
+			klog.ErrorS(err, "failed to confirm generated secret version", "objectName", kvObject.ObjectName, "objectVersion", version, "pod", klog.ObjectRef{Namespace: p.PodNamespace, Name: p.PodName})
+		}
+		return version, nil
+	}
+
+	// another pod created the secret first, its value is copied to the latest version
+	klog.InfoS("secret was generated concurrently, using the oldest generated version", "objectName", kvObject.ObjectName, "objectVersion", winner, "discardedVersion", version, "pod", klog.ObjectRef{Namespace: p.PodNamespace, Name: p.PodName})
+	secret, err := kvClient.GetSecret(ctx, vaultURL, kvObject.ObjectName, winner)
+	if err != nil {
+		return "", wrapObjectTypeError(err, VaultObjectTypeSecret, kvObject.ObjectName, winner)
+	}
+	if secret.Value == nil {
+		return "", fmt.Errorf("secret value is nil")
+	}
+	tags[copiedFromTag] = to.StringPtr(winner)
+	copyVersion, err := p.setSecret(ctx, kvClient, vaultURL, kvObject.ObjectName, *secret.Value, to.String(secret.ContentType), tags)
+	if err != nil {
+		return "", err
+	}
+	klog.InfoS("created secret version", "objectName", kvObject.ObjectName, "objectVersion", copyVersion, "copiedFrom", winner, "keyvault", p.KeyvaultName, "pod", klog.ObjectRef{Namespace: p.PodNamespace, Name: p.PodName})
+	if _, err := kvClient.UpdateSecret(ctx, vaultURL, kvObject.ObjectName, version, kv.SecretUpdateParameters{
+		SecretAttributes: &kv.SecretAttributes{Enabled: to.BoolPtr(false)},
+	}); err != nil {
+		// the discarded version is older than the copy, so it's never read as the latest version
+		klog.ErrorS(err, "failed to disable discarded secret version", "objectName", kvObject.ObjectName, "objectVersion", version, "pod", klog.ObjectRef{Namespace: p.PodNamespace, Name: p.PodName})
+	}
+	return winner, nil
+}
+
+// setSecret creates a new version of the secret and returns the version
+func (p *Provider) setSecret(ctx context.Context, kvClient generateClient, vaultURL, secretName, value, contentType string, tags map[string]*string) (string, error) {
+	params := kv.SecretSetParameters{Value: to.StringPtr(value), Tags: tags}
+	if len(contentType) > 0 {
+		params.ContentType = to.StringPtr(contentType)
+	}
+	secret, err := kvClient.SetSecret(ctx, vaultURL, secretName, params)
+	if err != nil {
+		return "", fmt.Errorf("failed to create secret %s, generate requires the set permission on secrets, error: %w", secretName, err)
+	}
+	if secret.ID == nil {
+		return "", fmt.Errorf("secret id is nil")
+	}
+	return getObjectVersion(*secret.ID), nil
+}
+
+// getGeneratedSecretVersionWithClient lists the versions of the secret and returns the
+// version picked by the racing pods, see getGeneratedSecretVersion
+func getGeneratedSecretVersionWithClient(ctx context.Context, kvClient generateClient, vaultURL, secretName string) (string, error) {
+	var items []kv.SecretItem
+	it, err := kvClient.GetSecretVersionsComplete(ctx, vaultURL, secretName, nil)
+	if err != nil {
+		return "", wrapObjectTypeError(err, VaultObjectTypeSecret, secretName, "")
+	}
+	for it.NotDone() {
+		items = append(items, it.Value())
+		if err := it.NextWithContext(ctx); err != nil {
+			return "", wrapObjectTypeError(err, VaultObjectTypeSecret, secretName, "")
+		}
+	}
+	return getGeneratedSecretVersion(items), nil
+}
+
+// getGeneratedSecretVersion returns the oldest enabled version created by the provider that
+// isn't a copy of another version. The ties are broken by the version, so all the pods pick
+// the same version.
+func getGeneratedSecretVersion(items []kv.SecretItem) string {
+	type secretVersion struct {
+		version string
+		created time.Time
+	}
+	var generated []secretVersion
+	for _, item := range items {
+		if item.ID == nil || item.Attributes == nil || item.Attributes.Enabled == nil || !*item.Attributes.Enabled {
+			continue
+		}
+		if to.String(item.Tags[generatedByTag]) != generatedByValue || item.Tags[copiedFromTag] != nil {
+			continue
+		}
+		var created time.Time
+		if item.Attributes.Created != nil {
+			created = time.Time(*item.Attributes.Created)
+		}
+		generated = append(generated, secretVersion{version: getObjectVersion(*item.ID), created: created})
+	}
+	if len(generated) == 0 {
+		return ""
+	}
+	sort.SliceStable(generated, func(i, j int) bool {
+		if generated[i].created.Equal(generated[j].created) {
+			return generated[i].version < generated[j].version
+		}
+		return generated[i].created.Before(generated[j].created)
+	})
+	return generated[0].version
+}
+
+// isNotFoundError returns true if the Key Vault request failed with status code 404
+func isNotFoundError(err error) bool {
+	var detailedErr autorest.DetailedError
+	if !errors.As(err, &detailedErr) {
+		return false
+	}
+	return detailedErr.StatusCode == http.StatusNotFound
+}
@@ -0,0 +1,481 @@
+package provider
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	kv "github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/date"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+func TestValidateGenerate(t *testing.T) {
+	cases := []struct {
+		desc        string
+		object      KeyVaultObject
+		expectedErr error
+	}{
+		{
+			desc:        "no generate policy",
+			object:      KeyVaultObject{ObjectName: "db-password", ObjectType: "secret"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "generate for object type key",
+			object:      KeyVaultObject{ObjectName: "db-password", ObjectType: "key", Generate: GeneratePolicy{Type: "password"}},
+			expectedErr: fmt.Errorf("generate only supported for objectType: secret"),
+		},
+		{
+			desc:        "generate with object version",
+			object:      KeyVaultObject{ObjectName: "db-password", ObjectType: "secret", ObjectVersion: "v1", Generate: GeneratePolicy{Type: "password"}},
+			expectedErr: fmt.Errorf("generate not supported with objectVersion"),
+		},
+		{
+			desc:        "type not set",
+			object:      KeyVaultObject{ObjectName: "db-password", ObjectType: "secret", Generate: GeneratePolicy{Length: "16"}},
+			expectedErr: fmt.Errorf("invalid generate.type: , should be password, bytes, rsa or ec"),
+		},
+		{
+			desc:        "password with length and charset",
+			object:      KeyVaultObject{ObjectName: "db-password", ObjectType: "secret", Generate: GeneratePolicy{Type: "Password", Length: "64", Charset: "abc123!#"}},
+			expectedErr: nil,
+		},
+		{
+			desc:        "invalid length",
+			object:      KeyVaultObject{ObjectName: "db-password", ObjectType: "secret", Generate: GeneratePolicy{Type: "password", Length: "0"}},
+			expectedErr: fmt.Errorf("invalid generate.length: 0, should be a number between 1 and 4096"),
+		},
+		{
+			desc:        "length for rsa",
+			object:      KeyVaultObject{ObjectName: "session-key", ObjectType: "secret", Generate: GeneratePolicy{Type: "rsa", Length: "32"}},
+			expectedErr: fmt.Errorf("generate.length only supported for generate.type: password, bytes"),
+		},
+		{
+			desc:        "charset for bytes",
+			object:      KeyVaultObject{ObjectName: "session-key", ObjectType: "secret", Generate: GeneratePolicy{Type: "bytes", Charset: "abc"}},
+			expectedErr: fmt.Errorf("generate.charset only supported for generate.type: password"),
+		},
+		{
+			desc:        "invalid key size",
+			object:      KeyVaultObject{ObjectName: "session-key", ObjectType: "secret", Generate: GeneratePolicy{Type: "rsa", KeySize: "1024"}},
+			expectedErr: fmt.Errorf("invalid generate.keySize: 1024, should be 2048, 3072 or 4096"),
+		},
+		{
+			desc:        "curve for rsa",
+			object:      KeyVaultObject{ObjectName: "session-key", ObjectType: "secret", Generate: GeneratePolicy{Type: "rsa", Curve: "P-256"}},
+			expectedErr: fmt.Errorf("generate.curve only supported for generate.type: ec"),
+		},
+		{
+			desc:        "invalid curve",
+			object:      KeyVaultObject{ObjectName: "session-key", ObjectType: "secret", Generate: GeneratePolicy{Type: "ec", Curve: "P-256K"}},
+			expectedErr: fmt.Errorf("invalid generate.curve: P-256K, should be P-256, P-384 or P-521"),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := validateGenerate(tc.object)
+			if tc.expectedErr != nil && (err == nil || err.Error() != tc.expectedErr.Error()) || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestGenerateSecretValue(t *testing.T) {
+	cases := []struct {
+		desc                string
+		policy              GeneratePolicy
+		expectedContentType string
+		check               func(t *testing.T, value string)
+	}{
+		{
+			desc:                "default password",
+			policy:              GeneratePolicy{Type: "password"},
+			expectedContentType: "",
+			check: func(t *testing.T, value string) {
+				if len(value) != 32 || strings.Trim(value, defaultGenerateCharset) != "" {
+					t.Fatalf("expected 32 alphanumeric characters, got: %s", value)
+				}
+			},
+		},
+		{
+			desc:                "password with charset",
+			policy:              GeneratePolicy{Type: "password", Length: "12", Charset: "äö!"},
+			expectedContentType: "",
+			check: func(t *testing.T, value string) {
+				if len([]rune(value)) != 12 || strings.Trim(value, "äö!") != "" {
+					t.Fatalf("expected 12 characters of the charset, got: %s", value)
+				}
+			},
+		},
+		{
+			desc:                "random bytes",
+			policy:              GeneratePolicy{Type: "bytes", Length: "64"},
+			expectedContentType: "application/octet-stream;base64",
+			check: func(t *testing.T, value string) {
+				data, err := base64.StdEncoding.DecodeString(value)
+				if err != nil || len(data) != 64 {
+					t.Fatalf("expected 64 base64 encoded bytes, got: %s", value)
+				}
+			},
+		},
+		{
+			desc:                "RSA key",
+			policy:              GeneratePolicy{Type: "rsa", KeySize: "3072"},
+			expectedContentType: "application/x-pem-file",
+			check: func(t *testing.T, value string) {
+				key := parseTestPKCS8Key(t, value)
+				if rsaKey, ok := key.(*rsa.PrivateKey); !ok || rsaKey.N.BitLen() != 3072 {
+					t.Fatalf("expected 3072 bit RSA key, got: %T", key)
+				}
+			},
+		},
+		{
+			desc:                "EC key",
+			policy:              GeneratePolicy{Type: "EC", Curve: "p-384"},
+			expectedContentType: "application/x-pem-file",
+			check: func(t *testing.T, value string) {
+				key := parseTestPKCS8Key(t, value)
+				if ecKey, ok := key.(*ecdsa.PrivateKey); !ok || ecKey.Curve.Params().Name != "P-384" {
+					t.Fatalf("expected P-384 EC key, got: %T", key)
+				}
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			value, contentType, err := generateSecretValue(tc.policy)
+			if err != nil {
+				t.Fatalf("expected nil err, got: %v", err)
+			}
+			if contentType != tc.expectedContentType {
+				t.Fatalf("expected content type: %s, got: %s", tc.expectedContentType, contentType)
+			}
+			tc.check(t, value)
+		})
+	}
+}
+
+func parseTestPKCS8Key(t *testing.T, value string) interface{} {
+	block, _ := pem.Decode([]byte(value))
+	if block == nil || block.Type != "PRIVATE KEY" {
+		t.Fatalf("expected PKCS#8 PEM block, got: %s", value)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	return key
+}
+
+func TestGetGeneratedSecretVersion(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	item := func(version string, created time.Time, enabled bool, tags map[string]*string) kv.SecretItem {
+		createdTime := date.UnixTime(created)
+		return kv.SecretItem{
+			ID:         to.StringPtr("https://myvault.vault.azure.net/secrets/db-password/" + version),
+			Attributes: &kv.SecretAttributes{Enabled: to.BoolPtr(enabled), Created: &createdTime},
+			Tags:       tags,
+		}
+	}
+	generated := map[string]*string{generatedByTag: to.StringPtr(generatedByValue)}
+	copied := map[string]*string{generatedByTag: to.StringPtr(generatedByValue), copiedFromTag: to.StringPtr("v1")}
+
+	cases := []struct {
+		desc            string
+		items           []kv.SecretItem
+		expectedVersion string
+	}{
+		{
+			desc:            "no versions",
+			items:           nil,
+			expectedVersion: "",
+		},
+		{
+			desc:            "single generated version",
+			items:           []kv.SecretItem{item("v1", now, true, generated)},
+			expectedVersion: "v1",
+		},
+		{
+			desc: "oldest generated version",
+			items: []kv.SecretItem{
+				item("v2", now.Add(time.Second), true, generated),
+				item("v1", now, true, generated),
+			},
+			expectedVersion: "v1",
+		},
+		{
+			desc: "same creation time",
+			items: []kv.SecretItem{
+				item("b", now, true, generated),
+				item("a", now, true, generated),
+			},
+			expectedVersion: "a",
+		},
+		{
+			desc: "disabled, copied and untagged versions",
+			items: []kv.SecretItem{
+				item("v0", now.Add(-time.Hour), true, nil),
+				item("v1", now, false, generated),
+				item("v2", now.Add(time.Second), true, copied),
+				item("v3", now.Add(2*time.Second), true, generated),
+			},
+			expectedVersion: "v3",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if version := getGeneratedSecretVersion(tc.items); version != tc.expectedVersion {
+				t.Fatalf("expected version: %s, got: %s", tc.expectedVersion, version)
+			}
+		})
+	}
+}
+
+// fakeGenerateClient keeps the versions of a secret in memory
+type fakeGenerateClient struct {
+	versions []kv.SecretBundle
+	created  time.Time
+	// beforeSet and afterSet are called once before and after the next version is created
+	beforeSet func()
+	afterSet  func()
+	// lagging are the versions left out of the next list of the versions
+	lagging []string
+	lists   int
+}
+
+func (c *fakeGenerateClient) GetSecret(ctx context.Context, vaultBaseURL, secretName, secretVersion string) (kv.SecretBundle, error) {
+	for i := len(c.versions) - 1; i >= 0; i-- {
+		if len(secretVersion) == 0 || getObjectVersion(*c.versions[i].ID) == secretVersion {
+			return c.versions[i], nil
+		}
+	}
+	return kv.SecretBundle{}, autorest.DetailedError{StatusCode: http.StatusNotFound}
+}
+
+func (c *fakeGenerateClient) SetSecret(ctx context.Context, vaultBaseURL, secretName string, parameters kv.SecretSetParameters) (kv.SecretBundle, error) {
+	if beforeSet := c.beforeSet; beforeSet != nil {
+		c.beforeSet = nil
+		beforeSet()
+	}
+	tags := make(map[string]*string)
+	for k, v := range parameters.Tags {
+		tags[k] = v
+	}
+	created := date.UnixTime(c.created.Add(time.Duration(len(c.versions)) * time.Second))
+	secret := kv.SecretBundle{
+		ID:          to.StringPtr(fmt.Sprintf("%s/secrets/%s/v%d", vaultBaseURL, secretName, len(c.versions)+1)),
+		Value:       parameters.Value,
+		ContentType: parameters.ContentType,
+		Attributes:  &kv.SecretAttributes{Enabled: to.BoolPtr(true), Created: &created},
+		Tags:        tags,
+	}
+	c.versions = append(c.versions, secret)
+	if afterSet := c.afterSet; afterSet != nil {
+		c.afterSet = nil
+		afterSet()
+	}
+	return secret, nil
+}
+
+func (c *fakeGenerateClient) UpdateSecret(ctx context.Context, vaultBaseURL, secretName, secretVersion string, parameters kv.SecretUpdateParameters) (kv.SecretBundle, error) {
+	for i := range c.versions {
+		if getObjectVersion(*c.versions[i].ID) == secretVersion {
+			if parameters.SecretAttributes != nil {
+				c.versions[i].Attributes.Enabled = parameters.SecretAttributes.Enabled
+			}
+			if parameters.Tags != nil {
+				c.versions[i].Tags = make(map[string]*string)
+				for k, v := range parameters.Tags {
+					c.versions[i].Tags[k] = v
+				}
+			}
+			return c.versions[i], nil
+		}
+	}
+	return kv.SecretBundle{}, autorest.DetailedError{StatusCode: http.StatusNotFound}
+}
+
+func (c *fakeGenerateClient) GetSecretVersionsComplete(ctx context.Context, vaultBaseURL, secretName string, maxresults *int32) (kv.SecretListResultIterator, error) {
+	lagging := make(map[string]bool)
+	for _, version := range c.lagging {
+		lagging[version] = true
+	}
+	c.lagging = nil
+	c.lists++
+	var items []kv.SecretItem
+	for _, secret := range c.versions {
+		if !lagging[getObjectVersion(*secret.ID)] {
+			items = append(items, kv.SecretItem{ID: secret.ID, Attributes: secret.Attributes, Tags: secret.Tags})
+		}
+	}
+	page := kv.NewSecretListResultPage(kv.SecretListResult{Value: &items}, func(context.Context, kv.SecretListResult) (kv.SecretListResult, error) {
+		return kv.SecretListResult{}, nil
+	})
+	return kv.NewSecretListResultIterator(page), nil
+}
+
+func TestGenerateSecretRace(t *testing.T) {
+	const vaultURL = "https://myvault.vault.azure.net"
+	object := KeyVaultObject{ObjectName: "db-password", ObjectType: "secret", Generate: GeneratePolicy{Type: "password"}}
+	client := &fakeGenerateClient{created: time.Unix(1700000000, 0)}
+	defaultListDelay := *GenerateListDelay
+	*GenerateListDelay = 0
+	defer func() { *GenerateListDelay = defaultListDelay }()
+	// mount returns the value the mount of the pod reads at that time
+	mount := func(podName string) string {
+		p := &Provider{KeyvaultName: "myvault", PodName: podName, PodNamespace: "default"}
+		version, err := p.generateSecretWithClient(context.TODO(), client, vaultURL, object)
+		if err != nil {
+			t.Fatalf("expected nil err, got: %v", err)
+		}
+		secret, err := client.GetSecret(context.TODO(), vaultURL, object.ObjectName, version)
+		if err != nil {
+			t.Fatalf("expected nil err, got: %v", err)
+		}
+		return *secret.Value
+	}
+
+	// the loser finds the secret missing, but the winner creates its version first. A pod
+	// mounts after the loser created its version and before it copied the winning value.
+	var winnerValue, readerValue string
+	client.beforeSet = func() {
+		winnerValue = mount("winner")
+		client.afterSet = func() {
+			readerValue = mount("reader")
+		}
+	}
+	loserValue := mount("loser")
+	lateValue := mount("late")
+
+	if winnerValue != *client.versions[0].Value {
+		t.Fatalf("expected the winner to mount the first version")
+	}
+	for desc, value := range map[string]string{"loser": loserValue, "reader": readerValue, "late": lateValue} {
+		if value != winnerValue {
+			t.Fatalf("expected the %s to mount the winning value %q, got: %q", desc, winnerValue, value)
+		}
+	}
+	if len(client.versions) != 3 || to.String(client.versions[2].Tags[copiedFromTag]) != "v1" || *client.versions[1].Attributes.Enabled {
+		t.Fatalf("expected the discarded version v2 to be disabled and copied from v1 to v3")
+	}
+}
+
+func TestGenerateSecretLaggingList(t *testing.T) {
+	const vaultURL = "https://myvault.vault.azure.net"
+	object := KeyVaultObject{ObjectName: "db-password", ObjectType: "secret", Generate: GeneratePolicy{Type: "password"}}
+	client := &fakeGenerateClient{created: time.Unix(1700000000, 0)}
+	defaultListDelay := *GenerateListDelay
+	*GenerateListDelay = 0
+	defer func() { *GenerateListDelay = defaultListDelay }()
+	mount := func(podName string) string {
+		p := &Provider{KeyvaultName: "myvault", PodName: podName, PodNamespace: "default"}
+		version, err := p.generateSecretWithClient(context.TODO(), client, vaultURL, object)
+		if err != nil {
+			t.Fatalf("expected nil err, got: %v", err)
+		}
+		secret, err := client.GetSecret(context.TODO(), vaultURL, object.ObjectName, version)
+		if err != nil {
+			t.Fatalf("expected nil err, got: %v", err)
+		}
+		return *secret.Value
+	}
+
+	// the winner creates its version first, but the version isn't listed yet when the loser
+	// lists the versions the first time
+	var winnerValue string
+	client.beforeSet = func() {
+		winnerValue = mount("winner")
+		client.lagging = []string{"v1"}
+	}
+	loserValue := mount("loser")
+
+	if loserValue != winnerValue {
+		t.Fatalf("expected the loser to mount the winning value %q, got: %q", winnerValue, loserValue)
+	}
+	if len(client.versions) != 3 || to.String(client.versions[2].Tags[copiedFromTag]) != "v1" || *client.versions[1].Attributes.Enabled {
+		t.Fatalf("expected the discarded version v2 to be disabled and copied from v1 to v3")
+	}
+	if client.versions[0].Tags[generatedWinnerTag] == nil || client.versions[1].Tags[generatedWinnerTag] != nil {
+		t.Fatalf("expected only the winning version v1 to be confirmed")
+	}
+}
+
+func TestGenerateSecretConfirmed(t *testing.T) {
+	const vaultURL = "https://myvault.vault.azure.net"
+	object := KeyVaultObject{ObjectName: "db-password", ObjectType: "secret", Generate: GeneratePolicy{Type: "password"}}
+	client := &fakeGenerateClient{created: time.Unix(1700000000, 0)}
+	defaultListDelay := *GenerateListDelay
+	*GenerateListDelay = 0
+	defer func() { *GenerateListDelay = defaultListDelay }()
+
+	p := &Provider{KeyvaultName: "myvault", PodName: "first", PodNamespace: "default"}
+	version, err := p.generateSecretWithClient(context.TODO(), client, vaultURL, object)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	if version != "v1" || to.String(client.versions[0].Tags[generatedWinnerTag]) != "true" || to.String(client.versions[0].Tags[generatedByTag]) != generatedByValue {
+		t.Fatalf("expected the created version v1 to be confirmed, got version: %q, tags: %v", version, client.versions[0].Tags)
+	}
+
+	// the following mounts read the confirmed latest version without listing the versions
+	lists := client.lists
+	p = &Provider{KeyvaultName: "myvault", PodName: "second", PodNamespace: "default"}
+	if version, err = p.generateSecretWithClient(context.TODO(), client, vaultURL, object); err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	if len(version) != 0 || client.lists != lists {
+		t.Fatalf("expected the latest version to be read without listing the versions, got version: %q, lists: %d", version, client.lists-lists)
+	}
+}
+
+func TestIsNotFoundError(t *testing.T) {
+	cases := []struct {
+		desc     string
+		err      error
+		expected bool
+	}{
+		{
+			desc:     "not found",
+			err:      autorest.DetailedError{StatusCode: http.StatusNotFound},
+			expected: true,
+		},
+		{
+			desc:     "wrapped not found",
+			err:      errors.Wrap(autorest.DetailedError{StatusCode: http.StatusNotFound}, "failed to get secret"),
+			expected: true,
+		},
+		{
+			desc:     "forbidden",
+			err:      autorest.DetailedError{StatusCode: http.StatusForbidden},
+			expected: false,
+		},
+		{
+			desc:     "other error",
+			err:      fmt.Errorf("connection refused"),
+			expected: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if actual := isNotFoundError(tc.err); actual != tc.expected {
+				t.Fatalf("expected: %v, got: %v", tc.expected, actual)
+			}
+		})
+	}
+}
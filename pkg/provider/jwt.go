@@ -0,0 +1,232 @@
+package provider
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	kv "github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+	"golang.org/x/net/context"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// defaultJWTLifetime is the lifetime of the tokens if lifetime isn't set
+	defaultJWTLifetime = 10 * time.Minute
+	// maxJWTLifetime is the maximum lifetime of the tokens
+	maxJWTLifetime = 24 * time.Hour
+)
+
+// registeredJWTClaims are the claims set by the provider that can't be set in claims
+var registeredJWTClaims = []string{"iss", "sub", "aud", "exp", "nbf", "iat", "jti"}
+
+// podTemplateData is the pod metadata the claims and certificate names are templated with
+type podTemplateData struct {
+	PodName        string
+	PodNamespace   string
+	ServiceAccount string
+}
+
+// validateJWT checks if the token options are valid for the given object
+func validateJWT(kvObject KeyVaultObject) error {
+	if kvObject.ObjectType != VaultObjectTypeJWT {
+		if len(kvObject.Issuer) > 0 || len(kvObject.Audience) > 0 || len(kvObject.Claims) > 0 {
+			return fmt.Errorf("issuer, audience and claims only supported for objectType: jwt")
+		}
+		// subject, lifetime and signingAlgorithm are validated with the certificates
+		if kvObject.ObjectType != VaultObjectTypePodCertificate && kvObject.ObjectType != VaultObjectTypeSSHCertificate && (len(kvObject.Subject) > 0 || len(kvObject.Lifetime) > 0) {
+			return fmt.Errorf("subject and lifetime only supported for objectType: jwt, podCertificate, sshCertificate")
+		}
+		if kvObject.ObjectType != VaultObjectTypePodCertificate && len(kvObject.SigningAlgorithm) > 0 {
+			return fmt.Errorf("signingAlgorithm only supported for objectType: jwt, podCertificate")
+		}
+		return nil
+	}
+	if _, err := getLifetime(kvObject.Lifetime, defaultJWTLifetime, maxJWTLifetime); err != nil {
+		return err
+	}
+	if len(kvObject.SigningAlgorithm) > 0 {
+		if err := validateSigningAlgorithm(kvObject.SigningAlgorithm); err != nil {
+			return err
+		}
+	}
+	// the templates are rendered with empty pod metadata to fail early
+	if _, err := getJWTClaims(kvObject, podTemplateData{}, time.Now()); err != nil {
+		return err
+	}
+	return nil
+}
+
+// getLifetime parses the lifetime of the generated credentials, defaultLifetime is
+// returned if not set
+func getLifetime(lifetime string, defaultLifetime, maxLifetime time.Duration) (time.Duration, error) {
+	if len(lifetime) == 0 {
+		return defaultLifetime, nil
+	}
+	d, err := time.ParseDuration(lifetime)
+	if err != nil || d <= 0 || d > maxLifetime {
+		return 0, fmt.Errorf("invalid lifetime: %v, should be a duration such as 5m or 1h of at most %v", lifetime, maxLifetime)
+	}
+	return d, nil
+}
+
+// getJWTContent mints a token with the claims of the object and signs it with the Key Vault
+// key objectName. The version changes with every token, so the token is refreshed on each
+// rotation poll.
+func (p *Provider) getJWTContent(ctx context.Context, kvClient *kv.BaseClient, vaultURL string, kvObject KeyVaultObject) (string, string, error) {
+	key, sign, err := p.getKeyVaultSigner(ctx, kvClient, vaultURL, kvObject.ObjectName, kvObject.ObjectVersion)
+	if err != nil {
+		return "", "", err
+	}
+	alg, err := getSigningAlgorithm(kvObject.SigningAlgorithm, *key)
+	if err != nil {
+		return "", "", err
+	}
+	claims, err := getJWTClaims(kvObject, p.getPodTemplateData(), time.Now())
+	if err != nil {
+		return "", "", err
+	}
+	token, err := signJWT(ctx, map[string]interface{}{"alg": alg, "typ": "JWT", "kid": *key.Kid}, claims, sign)
+	if err != nil {
+		return "", "", err
+	}
+	klog.InfoS("signed token", "objectName", kvObject.ObjectName, "algorithm", alg, "expiry", time.Unix(claims["exp"].(int64), 0).UTC(), "pod", klog.ObjectRef{Namespace: p.PodNamespace, Name: p.PodName})
+	return token, getCompositeVersion([]string{getObjectVersion(*key.Kid), strconv.FormatInt(claims["exp"].(int64), 10)}), nil
+}
+
+// getPodTemplateData returns the metadata of the pod the volume is mounted in
+func (p *Provider) getPodTemplateData() podTemplateData {
+	return podTemplateData{PodName: p.PodName, PodNamespace: p.PodNamespace, ServiceAccount: p.ServiceAccountName}
+}
+
+// getJWTClaims returns the claims of the token issued at now. The issuer, subject, audience
+// and the string values of the custom claims are templated with the pod metadata.
+func getJWTClaims(kvObject KeyVaultObject, pod podTemplateData, now time.Time) (map[string]interface{}, error) {
+	lifetime, err := getLifetime(kvObject.Lifetime, defaultJWTLifetime, maxJWTLifetime)
+	if err != nil {
+		return nil, err
+	}
+	claims := make(map[string]interface{})
+	if len(kvObject.Claims) > 0 {
+		decoder := json.NewDecoder(strings.NewReader(kvObject.Claims))
+		decoder.UseNumber()
+		if err := decoder.Decode(&claims); err != nil {
+			return nil, fmt.Errorf("failed to parse claims, should be a JSON object, error: %w", err)
+		}
+		for _, name := range registeredJWTClaims {
+			if _, ok := claims[name]; ok {
+				return nil, fmt.Errorf("claim %s can't be set in claims", name)
+			}
+		}
+		for name, value := range claims {
+			if claims[name], err = renderClaim(name, value, pod); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for name, text := range map[string]string{"iss": kvObject.Issuer, "sub": kvObject.Subject} {
+		if len(text) == 0 {
+			continue
+		}
+		if claims[name], err = renderClaim(name, text, pod); err != nil {
+			return nil, err
+		}
+	}
+	var audience []interface{}
+	for _, aud := range splitList(kvObject.Audience) {
+		value, err := renderClaim("aud", aud, pod)
+		if err != nil {
+			return nil, err
+		}
+		audience = append(audience, value)
+	}
+	// a single audience is set as a string as expected by most verifiers
+	switch len(audience) {
+	case 0:
+	case 1:
+		claims["aud"] = audience[0]
+	default:
+		claims["aud"] = audience
+	}
+
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return nil, err
+	}
+	claims["jti"] = hex.EncodeToString(jti)
+	claims["iat"] = now.Unix()
+	claims["nbf"] = now.Unix()
+	claims["exp"] = now.Add(lifetime).Unix()
+	return claims, nil
+}
+
+// renderClaim renders the templates in the string values of the claim with the pod metadata
+func renderClaim(name string, value interface{}, pod podTemplateData) (interface{}, error) {
+	switch value := value.(type) {
+	case string:
+		return renderPodTemplate("claim", name, value, pod)
+	case []interface{}:
+		for i, item := range value {
+			rendered, err := renderClaim(name, item, pod)
+			if err != nil {
+				return nil, err
+			}
+			value[i] = rendered
+		}
+		return value, nil
+	case map[string]interface{}:
+		for key, item := range value {
+			rendered, err := renderClaim(name, item, pod)
+			if err != nil {
+				return nil, err
+			}
+			value[key] = rendered
+		}
+		return value, nil
+	default:
+		return value, nil
+	}
+}
+
+// renderPodTemplate renders the template text of the named field with the pod metadata
+func renderPodTemplate(kind, name, text string, pod podTemplateData) (string, error) {
+	tmpl, err := newTemplate(name, nil).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse template of %s %s, error: %w", kind, name, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, pod); err != nil {
+		return "", fmt.Errorf("failed to render template of %s %s, error: %w", kind, name, err)
+	}
+	return buf.String(), nil
+}
+
+// signJWT returns the token in JWS compact serialization signed with the algorithm in the header
+func signJWT(ctx context.Context, header, claims map[string]interface{}, sign signFunc) (string, error) {
+	encodedHeader, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	encodedClaims, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(encodedHeader) + "." + base64.RawURLEncoding.EncodeToString(encodedClaims)
+	alg := fmt.Sprint(header["alg"])
+	digest, err := getSigningDigest(alg, []byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+	signature, err := sign(ctx, alg, digest)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token, error: %w", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -0,0 +1,225 @@
+package provider
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestValidateJWT(t *testing.T) {
+	cases := []struct {
+		desc        string
+		object      KeyVaultObject
+		expectedErr error
+	}{
+		{
+			desc:        "token options for object type secret",
+			object:      KeyVaultObject{ObjectName: "secret1", ObjectType: "secret", Audience: "api://partner"},
+			expectedErr: fmt.Errorf("issuer, audience and claims only supported for objectType: jwt"),
+		},
+		{
+			desc:        "token options for object type podCertificate",
+			object:      KeyVaultObject{ObjectName: "ca", ObjectType: "podCertificate", Claims: `{"a": "b"}`},
+			expectedErr: fmt.Errorf("issuer, audience and claims only supported for objectType: jwt"),
+		},
+		{
+			desc:        "lifetime for object type secret",
+			object:      KeyVaultObject{ObjectName: "secret1", ObjectType: "secret", Lifetime: "1h"},
+			expectedErr: fmt.Errorf("subject and lifetime only supported for objectType: jwt, podCertificate, sshCertificate"),
+		},
+		{
+			desc:        "signing algorithm for object type sshCertificate",
+			object:      KeyVaultObject{ObjectName: "ssh-ca", ObjectType: "sshCertificate", SigningAlgorithm: "RS256"},
+			expectedErr: fmt.Errorf("signingAlgorithm only supported for objectType: jwt, podCertificate"),
+		},
+		{
+			desc:        "certificate options validated with the pod certificate",
+			object:      KeyVaultObject{ObjectName: "ca", ObjectType: "podCertificate", Subject: "{{ .PodName }}", Lifetime: "720h"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "default options",
+			object:      KeyVaultObject{ObjectName: "assertion-key", ObjectType: "jwt"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "invalid lifetime",
+			object:      KeyVaultObject{ObjectName: "assertion-key", ObjectType: "jwt", Lifetime: "10"},
+			expectedErr: fmt.Errorf("invalid lifetime: 10, should be a duration such as 5m or 1h of at most 24h0m0s"),
+		},
+		{
+			desc:        "lifetime too long",
+			object:      KeyVaultObject{ObjectName: "assertion-key", ObjectType: "jwt", Lifetime: "48h"},
+			expectedErr: fmt.Errorf("invalid lifetime: 48h, should be a duration such as 5m or 1h of at most 24h0m0s"),
+		},
+		{
+			desc:        "invalid signing algorithm",
+			object:      KeyVaultObject{ObjectName: "assertion-key", ObjectType: "jwt", SigningAlgorithm: "none"},
+			expectedErr: fmt.Errorf("invalid signingAlgorithm: none, should be RS256, RS384, RS512, PS256, PS384, PS512, ES256, ES384 or ES512"),
+		},
+		{
+			desc:        "claims not an object",
+			object:      KeyVaultObject{ObjectName: "assertion-key", ObjectType: "jwt", Claims: `["a"]`},
+			expectedErr: fmt.Errorf("failed to parse claims, should be a JSON object, error: json: cannot unmarshal array into Go value of type map[string]interface {}"),
+		},
+		{
+			desc:        "registered claim in claims",
+			object:      KeyVaultObject{ObjectName: "assertion-key", ObjectType: "jwt", Claims: `{"exp": 1}`},
+			expectedErr: fmt.Errorf("claim exp can't be set in claims"),
+		},
+		{
+			desc:        "invalid template",
+			object:      KeyVaultObject{ObjectName: "assertion-key", ObjectType: "jwt", Subject: "{{ .PodName "},
+			expectedErr: fmt.Errorf("failed to parse template of claim sub, error: template: sub:1: unclosed action"),
+		},
+		{
+			desc:        "unknown template field",
+			object:      KeyVaultObject{ObjectName: "assertion-key", ObjectType: "jwt", Claims: `{"uid": "{{ .PodUID }}"}`},
+			expectedErr: fmt.Errorf("failed to render template of claim uid, error: template: uid:1:3: executing \"uid\" at <.PodUID>: can't evaluate field PodUID in type provider.podTemplateData"),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := validateJWT(tc.object)
+			if tc.expectedErr != nil && (err == nil || err.Error() != tc.expectedErr.Error()) || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestGetJWTClaims(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	pod := podTemplateData{PodName: "checkout-7d9f", PodNamespace: "shop", ServiceAccount: "checkout"}
+	cases := []struct {
+		desc     string
+		object   KeyVaultObject
+		expected map[string]interface{}
+	}{
+		{
+			desc:   "default lifetime",
+			object: KeyVaultObject{ObjectType: "jwt"},
+			expected: map[string]interface{}{
+				"iat": int64(1700000000),
+				"nbf": int64(1700000000),
+				"exp": int64(1700000600),
+			},
+		},
+		{
+			desc: "templated claims",
+			object: KeyVaultObject{
+				ObjectType: "jwt",
+				Issuer:     "https://{{ .PodNamespace }}.contoso.com",
+				Subject:    "system:serviceaccount:{{ .PodNamespace }}:{{ .ServiceAccount }}",
+				Audience:   "api://partner",
+				Lifetime:   "1h",
+				Claims:     `{"tenant": "{{ .PodNamespace }}", "roles": ["reader", "{{ .PodName }}"], "level": 3}`,
+			},
+			expected: map[string]interface{}{
+				"iss":    "https://shop.contoso.com",
+				"sub":    "system:serviceaccount:shop:checkout",
+				"aud":    "api://partner",
+				"tenant": "shop",
+				"roles":  []interface{}{"reader", "checkout-7d9f"},
+				"level":  json.Number("3"),
+				"iat":    int64(1700000000),
+				"nbf":    int64(1700000000),
+				"exp":    int64(1700003600),
+			},
+		},
+		{
+			desc:   "several audiences",
+			object: KeyVaultObject{ObjectType: "jwt", Audience: "api://a, api://{{ .PodNamespace }}"},
+			expected: map[string]interface{}{
+				"aud": []interface{}{"api://a", "api://shop"},
+				"iat": int64(1700000000),
+				"nbf": int64(1700000000),
+				"exp": int64(1700000600),
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			claims, err := getJWTClaims(tc.object, pod, now)
+			if err != nil {
+				t.Fatalf("expected nil err, got: %v", err)
+			}
+			// the token id is random
+			if jti, ok := claims["jti"].(string); !ok || len(jti) != 32 {
+				t.Fatalf("expected 16 byte hex jti, got: %v", claims["jti"])
+			}
+			delete(claims, "jti")
+			if !reflect.DeepEqual(claims, tc.expected) {
+				t.Fatalf("expected claims: %v, got: %v", tc.expected, claims)
+			}
+		})
+	}
+}
+
+func TestSignJWT(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	claims := map[string]interface{}{"sub": "checkout", "exp": int64(1700000600)}
+
+	cases := []struct {
+		alg string
+		key crypto.Signer
+	}{
+		{alg: "RS256", key: rsaKey},
+		{alg: "PS256", key: rsaKey},
+		{alg: "ES256", key: ecKey},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.alg, func(t *testing.T) {
+			header := map[string]interface{}{"alg": tc.alg, "typ": "JWT", "kid": "https://myvault.vault.azure.net/keys/assertion-key/v1"}
+			token, err := signJWT(context.TODO(), header, claims, newTestSignFunc(tc.key))
+			if err != nil {
+				t.Fatalf("expected nil err, got: %v", err)
+			}
+			parts := strings.Split(token, ".")
+			if len(parts) != 3 {
+				t.Fatalf("expected 3 parts, got: %d", len(parts))
+			}
+			if err := verifySignature([]byte(parts[0]+"."+parts[1]), parts[2], tc.alg, tc.key.Public()); err != nil {
+				t.Fatalf("expected valid signature, got: %v", err)
+			}
+			payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+			if err != nil {
+				t.Fatalf("expected nil err, got: %v", err)
+			}
+			if string(payload) != `{"exp":1700000600,"sub":"checkout"}` {
+				t.Fatalf("unexpected payload: %s", payload)
+			}
+		})
+	}
+}
+
+func TestSignJWTError(t *testing.T) {
+	sign := func(ctx context.Context, alg string, digest []byte) ([]byte, error) {
+		return nil, fmt.Errorf("Forbidden")
+	}
+	_, err := signJWT(context.TODO(), map[string]interface{}{"alg": "ES256"}, map[string]interface{}{}, sign)
+	if err == nil || err.Error() != "failed to sign token, error: Forbidden" {
+		t.Fatalf("expected sign error, got: %v", err)
+	}
+}
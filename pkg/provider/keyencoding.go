@@ -0,0 +1,271 @@
+package provider
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/net/context"
+)
+
+const (
+	// keyEncodingPKCS8 writes the private keys as PKCS#8 "PRIVATE KEY" blocks
+	keyEncodingPKCS8 = "pkcs8"
+	// keyEncodingPKCS1 writes the RSA private keys as PKCS#1 "RSA PRIVATE KEY" blocks
+	keyEncodingPKCS1 = "pkcs1"
+	// keyEncodingSEC1 writes the EC private keys as SEC1 "EC PRIVATE KEY" blocks
+	keyEncodingSEC1 = "sec1"
+
+	// pbkdf2Iterations is the PBKDF2 iteration count used to derive the key encryption
+	// key from the passphrase, as recommended by OWASP for PBKDF2-HMAC-SHA256
+	pbkdf2Iterations = 600000
+	pbkdf2SaltSize   = 16
+	// encryptedKeyCacheSize is the maximum number of encrypted private keys cached
+	encryptedKeyCacheSize = 256
+)
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// encryptedKeyCache caches the encrypted private keys by the HMAC of the private key with
+// the passphrase. The encryption is deterministic, so the keys are encrypted, and PBKDF2
+// runs, only when the private key or the passphrase changes instead of on every mount.
+type encryptedKeyCache struct {
+	mu   sync.Mutex
+	keys map[[sha256.Size]byte][]byte
+}
+
+var encryptedKeys = &encryptedKeyCache{keys: make(map[[sha256.Size]byte][]byte)}
+
+func (c *encryptedKeyCache) get(id [sha256.Size]byte) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	encrypted, ok := c.keys[id]
+	return encrypted, ok
+}
+
+func (c *encryptedKeyCache) add(id [sha256.Size]byte, encrypted []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.keys) >= encryptedKeyCacheSize {
+		// remove any key, the removed key is encrypted again on its next mount
+		for k := range c.keys {
+			delete(c.keys, k)
+			break
+		}
+	}
+	c.keys[id] = encrypted
+}
+
+// encryptedPrivateKeyInfo is the PKCS#8 EncryptedPrivateKeyInfo structure
+type encryptedPrivateKeyInfo struct {
+	EncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedData       []byte
+}
+
+// pbes2Params are the PBES2 parameters from RFC 8018
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+// pbkdf2Params are the PBKDF2 parameters from RFC 8018
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	PRF            pkix.AlgorithmIdentifier
+}
+
+// validateKeyEncoding checks if the private key encoding options are valid
+// for the given object
+func validateKeyEncoding(kvObject KeyVaultObject) error {
+	if len(kvObject.KeyEncoding) == 0 && len(kvObject.KeyPassphraseSecretName) == 0 {
+		return nil
+	}
+	if len(kvObject.KeyEncoding) > 0 && !strings.EqualFold(kvObject.KeyEncoding, keyEncodingPKCS8) && !strings.EqualFold(kvObject.KeyEncoding, keyEncodingPKCS1) && !strings.EqualFold(kvObject.KeyEncoding, keyEncodingSEC1) {
+		return fmt.Errorf("invalid keyEncoding: %v, should be pkcs8, pkcs1 or sec1", kvObject.KeyEncoding)
+	}
+	// the private keys are only returned by Azure Key Vault for type secret
+	if kvObject.ObjectType != VaultObjectTypeSecret {
+		return fmt.Errorf("keyEncoding and keyPassphraseSecretName only supported for objectType: secret")
+	}
+	if len(kvObject.ObjectFormat) > 0 && !strings.EqualFold(kvObject.ObjectFormat, objectFormatPEM) {
+		return fmt.Errorf("keyEncoding and keyPassphraseSecretName only supported with objectFormat: pem")
+	}
+	if len(kvObject.KeyPassphraseSecretName) > 0 && len(kvObject.KeyEncoding) > 0 && !strings.EqualFold(kvObject.KeyEncoding, keyEncodingPKCS8) {
+		return fmt.Errorf("keyPassphraseSecretName only supported with keyEncoding: pkcs8")
+	}
+	return nil
+}
+
+// getKeyEncodingContent re-encodes the private keys in the PEM content with the key encoding
+// of the object. The keys are encrypted with the passphrase from the referenced Key Vault
+// secret if configured.
+func (p *Provider) getKeyEncodingContent(ctx context.Context, kvObject KeyVaultObject, content string) (string, error) {
+	if len(kvObject.KeyEncoding) == 0 && len(kvObject.KeyPassphraseSecretName) == 0 {
+		return content, nil
+	}
+	var passphrase string
+	if len(kvObject.KeyPassphraseSecretName) > 0 {
+		var err error
+		if passphrase, _, err = p.getSecretValue(ctx, kvObject.KeyPassphraseSecretName, ""); err != nil {
+			return "", fmt.Errorf("failed to get key passphrase from secret %s, error: %w", kvObject.KeyPassphraseSecretName, err)
+		}
+		if len(passphrase) == 0 {
+			return "", fmt.Errorf("key passphrase in secret %s can't be empty", kvObject.KeyPassphraseSecretName)
+		}
+	}
+	return encodePrivateKeys(content, kvObject.KeyEncoding, passphrase)
+}
+
+// encodePrivateKeys re-encodes the private keys in the PEM data with the key encoding,
+// defaulting to PKCS#8. With a passphrase, the keys are written as encrypted PKCS#8 using
+// PBES2 with PBKDF2-HMAC-SHA256 and AES-256-CBC. The rest of the blocks are written as is
+// in the same order.
+func encodePrivateKeys(content, keyEncoding, passphrase string) (string, error) {
+	var pemData []byte
+	foundKey := false
+	data := []byte(content)
+	for {
+		block, rest := pem.Decode(data)
+		if block == nil {
+			break
+		}
+		data = rest
+		if block.Type == certificateType {
+			pemData = append(pemData, pem.EncodeToMemory(block)...)
+			continue
+		}
+		key, err := parsePrivateKey(block.Bytes)
+		if err != nil {
+			return "", err
+		}
+		keyBlock, err := encodePrivateKey(key, keyEncoding, passphrase)
+		if err != nil {
+			return "", err
+		}
+		pemData = append(pemData, pem.EncodeToMemory(keyBlock)...)
+		foundKey = true
+	}
+	if !foundKey {
+		return "", fmt.Errorf("no private key found")
+	}
+	return string(pemData), nil
+}
+
+// encodePrivateKey encodes the private key to a PEM block with the key encoding
+func encodePrivateKey(key interface{}, keyEncoding, passphrase string) (*pem.Block, error) {
+	switch {
+	case strings.EqualFold(keyEncoding, keyEncodingPKCS1):
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("keyEncoding: pkcs1 only supported for RSA keys, got %T", key)
+		}
+		return &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}, nil
+	case strings.EqualFold(keyEncoding, keyEncodingSEC1):
+		ecKey, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("keyEncoding: sec1 only supported for EC keys, got %T", key)
+		}
+		der, err := x509.MarshalECPrivateKey(ecKey)
+		if err != nil {
+			return nil, err
+		}
+		return &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}, nil
+	default:
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(passphrase) == 0 {
+			return &pem.Block{Type: "PRIVATE KEY", Bytes: der}, nil
+		}
+		encrypted, err := encryptPKCS8(der, []byte(passphrase))
+		if err != nil {
+			return nil, err
+		}
+		return &pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: encrypted}, nil
+	}
+}
+
+// encryptPKCS8 encrypts the PKCS#8 private key to a PKCS#8 EncryptedPrivateKeyInfo
+// using PBES2 with PBKDF2-HMAC-SHA256 and AES-256-CBC. The salt and IV are derived from
+// the private key and the passphrase with HKDF, so the encrypted key written on each
+// rotation poll only changes when the private key or the passphrase changes.
+func encryptPKCS8(der, passphrase []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, passphrase)
+	mac.Write(der)
+	var id [sha256.Size]byte
+	copy(id[:], mac.Sum(nil))
+	if encrypted, ok := encryptedKeys.get(id); ok {
+		return encrypted, nil
+	}
+
+	salt := make([]byte, pbkdf2SaltSize)
+	iv := make([]byte, aes.BlockSize)
+	kdf := hkdf.New(sha256.New, passphrase, der, []byte("pkcs8 pbes2 salt and iv"))
+	if _, err := io.ReadFull(kdf, salt); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(kdf, iv); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(pbkdf2.Key(passphrase, salt, pbkdf2Iterations, 32, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+	// PKCS#7 padding to a multiple of the block size
+	padding := aes.BlockSize - len(der)%aes.BlockSize
+	encrypted := make([]byte, len(der), len(der)+padding)
+	copy(encrypted, der)
+	for i := 0; i < padding; i++ {
+		encrypted = append(encrypted, byte(padding))
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, encrypted)
+
+	kdfParams, err := asn1.Marshal(pbkdf2Params{
+		Salt:           salt,
+		IterationCount: pbkdf2Iterations,
+		PRF:            pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ivParams, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParams}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	encryptedKey, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		EncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedData:       encrypted,
+	})
+	if err != nil {
+		return nil, err
+	}
+	encryptedKeys.add(id, encryptedKey)
+	return encryptedKey, nil
+}
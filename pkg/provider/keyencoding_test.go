@@ -0,0 +1,275 @@
+package provider
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// decryptPKCS8 decrypts the PBES2 encrypted PKCS#8 private key
+func decryptPKCS8(t *testing.T, der, passphrase []byte) interface{} {
+	t.Helper()
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	if !info.EncryptionAlgorithm.Algorithm.Equal(oidPBES2) {
+		t.Fatalf("expected PBES2, got: %v", info.EncryptionAlgorithm.Algorithm)
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.EncryptionAlgorithm.Parameters.FullBytes, &params); err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	var kdfParams pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdfParams); err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	if !kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA256) || !params.EncryptionScheme.Algorithm.Equal(oidAES256CBC) {
+		t.Fatalf("expected PBKDF2-HMAC-SHA256 and AES-256-CBC")
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	block, err := aes.NewCipher(pbkdf2.Key(passphrase, kdfParams.Salt, kdfParams.IterationCount, 32, sha256.New))
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	decrypted := make([]byte, len(info.EncryptedData))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted, info.EncryptedData)
+	padding := int(decrypted[len(decrypted)-1])
+	key, err := x509.ParsePKCS8PrivateKey(decrypted[:len(decrypted)-padding])
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	return key
+}
+
+func TestValidateKeyEncoding(t *testing.T) {
+	cases := []struct {
+		desc        string
+		object      KeyVaultObject
+		expectedErr error
+	}{
+		{
+			desc:        "key encoding not configured",
+			object:      KeyVaultObject{ObjectType: "cert"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "invalid key encoding",
+			object:      KeyVaultObject{ObjectType: "secret", KeyEncoding: "der"},
+			expectedErr: fmt.Errorf("invalid keyEncoding: der, should be pkcs8, pkcs1 or sec1"),
+		},
+		{
+			desc:        "object type cert",
+			object:      KeyVaultObject{ObjectType: "cert", KeyEncoding: "pkcs1"},
+			expectedErr: fmt.Errorf("keyEncoding and keyPassphraseSecretName only supported for objectType: secret"),
+		},
+		{
+			desc:        "object format pfx",
+			object:      KeyVaultObject{ObjectType: "secret", ObjectFormat: "pfx", KeyPassphraseSecretName: "passphrase"},
+			expectedErr: fmt.Errorf("keyEncoding and keyPassphraseSecretName only supported with objectFormat: pem"),
+		},
+		{
+			desc:        "passphrase with pkcs1",
+			object:      KeyVaultObject{ObjectType: "secret", KeyEncoding: "pkcs1", KeyPassphraseSecretName: "passphrase"},
+			expectedErr: fmt.Errorf("keyPassphraseSecretName only supported with keyEncoding: pkcs8"),
+		},
+		{
+			desc:        "valid key encoding case insensitive check",
+			object:      KeyVaultObject{ObjectType: "secret", ObjectFormat: "PEM", KeyEncoding: "SEC1"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "passphrase with default key encoding",
+			object:      KeyVaultObject{ObjectType: "secret", KeyPassphraseSecretName: "passphrase"},
+			expectedErr: nil,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := validateKeyEncoding(tc.object)
+			if tc.expectedErr != nil && (err == nil || err.Error() != tc.expectedErr.Error()) || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestEncodePrivateKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	rsaKeyBytes, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	ecKeyBytes, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	rsaKeyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: rsaKeyBytes}))
+	ecKeyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: ecKeyBytes}))
+	cert := newTestCA(t, "Test CA", nil)
+
+	cases := []struct {
+		desc          string
+		content       string
+		keyEncoding   string
+		passphrase    string
+		expectedType  string
+		expectedKey   interface{}
+		expectedCerts int
+		expectedErr   bool
+	}{
+		{
+			desc:          "pkcs1 rsa key",
+			content:       rsaKeyPEM + cert.pem(),
+			keyEncoding:   "pkcs1",
+			expectedType:  "RSA PRIVATE KEY",
+			expectedKey:   rsaKey,
+			expectedCerts: 1,
+		},
+		{
+			desc:        "pkcs1 ec key",
+			content:     ecKeyPEM,
+			keyEncoding: "pkcs1",
+			expectedErr: true,
+		},
+		{
+			desc:          "sec1 ec key",
+			content:       ecKeyPEM + cert.pem(),
+			keyEncoding:   "sec1",
+			expectedType:  "EC PRIVATE KEY",
+			expectedKey:   ecKey,
+			expectedCerts: 1,
+		},
+		{
+			desc:        "sec1 rsa key",
+			content:     rsaKeyPEM,
+			keyEncoding: "sec1",
+			expectedErr: true,
+		},
+		{
+			desc:         "pkcs8 from pkcs1",
+			content:      string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})),
+			keyEncoding:  "pkcs8",
+			expectedType: "PRIVATE KEY",
+			expectedKey:  rsaKey,
+		},
+		{
+			desc:          "encrypted pkcs8",
+			content:       cert.pem() + ecKeyPEM,
+			passphrase:    "passphrase",
+			expectedType:  "ENCRYPTED PRIVATE KEY",
+			expectedKey:   ecKey,
+			expectedCerts: 1,
+		},
+		{
+			desc:        "no private key",
+			content:     cert.pem(),
+			keyEncoding: "pkcs8",
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			content, err := encodePrivateKeys(tc.content, tc.keyEncoding, tc.passphrase)
+			if tc.expectedErr && err == nil || !tc.expectedErr && err != nil {
+				t.Fatalf("expected error: %v, got error: %v", tc.expectedErr, err)
+			}
+			if tc.expectedErr {
+				return
+			}
+			var key interface{}
+			certs := 0
+			data := []byte(content)
+			for {
+				block, rest := pem.Decode(data)
+				if block == nil {
+					break
+				}
+				data = rest
+				if block.Type == certificateType {
+					certs++
+					continue
+				}
+				if block.Type != tc.expectedType {
+					t.Fatalf("expected block type %s, got: %s", tc.expectedType, block.Type)
+				}
+				if block.Type == "ENCRYPTED PRIVATE KEY" {
+					key = decryptPKCS8(t, block.Bytes, []byte(tc.passphrase))
+				} else if key, err = parsePrivateKey(block.Bytes); err != nil {
+					t.Fatalf("expected nil err, got: %v", err)
+				}
+			}
+			if !reflect.DeepEqual(key, tc.expectedKey) {
+				t.Fatalf("expected private key to match")
+			}
+			// the certificates are kept
+			if certs != tc.expectedCerts {
+				t.Fatalf("expected %d certificates, got: %d", tc.expectedCerts, certs)
+			}
+		})
+	}
+}
+
+func TestEncryptPKCS8Deterministic(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	encrypted, err := encryptPKCS8(der, []byte("passphrase"))
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+
+	// the key is encrypted to the same bytes on the next mount, with or without the cache
+	encryptedKeys = &encryptedKeyCache{keys: make(map[[sha256.Size]byte][]byte)}
+	actual, err := encryptPKCS8(der, []byte("passphrase"))
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	if !reflect.DeepEqual(actual, encrypted) {
+		t.Fatalf("expected the encrypted key to be unchanged")
+	}
+	if len(encryptedKeys.keys) != 1 {
+		t.Fatalf("expected the encrypted key to be cached")
+	}
+	if !reflect.DeepEqual(decryptPKCS8(t, actual, []byte("passphrase")), ecKey) {
+		t.Fatalf("expected private key to match")
+	}
+
+	// another passphrase changes the salt and IV
+	other, err := encryptPKCS8(der, []byte("other-passphrase"))
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	if reflect.DeepEqual(other, encrypted) {
+		t.Fatalf("expected the key encrypted with another passphrase to differ")
+	}
+}
@@ -0,0 +1,282 @@
+package provider
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	kv "github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/context"
+)
+
+// maxObjectVersionHistory is the maximum number of key versions included in a jwks
+const maxObjectVersionHistory = 25
+
+// publicJWK is the public part of a JSON Web Key from RFC 7517
+type publicJWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// jwks is the JSON Web Key Set from RFC 7517
+type jwks struct {
+	Keys []publicJWK `json:"keys"`
+}
+
+// isKeyFormat returns true if the object format is one of the formats the
+// JSON Web Key of a key object is written in instead of PEM
+func isKeyFormat(objectFormat string) bool {
+	return strings.EqualFold(objectFormat, objectFormatJWK) || strings.EqualFold(objectFormat, objectFormatJWKS) || strings.EqualFold(objectFormat, objectFormatSSH)
+}
+
+// validateObjectVersionHistory checks if the number of versions to include in
+// the jwks is valid for the given object
+func validateObjectVersionHistory(kvObject KeyVaultObject) error {
+	if len(kvObject.ObjectVersionHistory) == 0 {
+		return nil
+	}
+	if !strings.EqualFold(kvObject.ObjectFormat, objectFormatJWKS) {
+		return fmt.Errorf("objectVersionHistory only supported with objectFormat: jwks")
+	}
+	history, err := strconv.Atoi(kvObject.ObjectVersionHistory)
+	if err != nil || history < 1 || history > maxObjectVersionHistory {
+		return fmt.Errorf("invalid objectVersionHistory: %v, should be a number between 1 and %d", kvObject.ObjectVersionHistory, maxObjectVersionHistory)
+	}
+	if history > 1 && len(kvObject.ObjectVersion) > 0 {
+		return fmt.Errorf("objectVersionHistory can't be used with objectVersion")
+	}
+	return nil
+}
+
+// getObjectVersionHistory returns the number of versions to include in the jwks
+func getObjectVersionHistory(kvObject KeyVaultObject) int {
+	history, err := strconv.Atoi(kvObject.ObjectVersionHistory)
+	if err != nil || history < 1 {
+		return 1
+	}
+	return history
+}
+
+// getJWKSContent returns the jwks with the most recent enabled versions of the key.
+// The version is the version of the key if the jwks has a single version, otherwise
+// it's derived from all the versions so it changes when any of the versions change.
+func (p *Provider) getJWKSContent(ctx context.Context, kvClient *kv.BaseClient, vaultURL string, kvObject KeyVaultObject) (string, string, error) {
+	versions := []string{kvObject.ObjectVersion}
+	if history := getObjectVersionHistory(kvObject); history > 1 {
+		var items []kv.KeyItem
+		it, err := kvClient.GetKeyVersionsComplete(ctx, vaultURL, kvObject.ObjectName, nil)
+		if err != nil {
+			return "", "", err
+		}
+		for it.NotDone() {
+			items = append(items, it.Value())
+			if err := it.NextWithContext(ctx); err != nil {
+				return "", "", err
+			}
+		}
+		if versions = getRecentEnabledVersions(items, history); len(versions) == 0 {
+			return "", "", fmt.Errorf("no enabled versions found for key %s", kvObject.ObjectName)
+		}
+	}
+
+	var keys []kv.JSONWebKey
+	var keyVersions []string
+	for _, version := range versions {
+		keybundle, err := kvClient.GetKey(ctx, vaultURL, kvObject.ObjectName, version)
+		if err != nil {
+			return "", "", err
+		}
+		if keybundle.Key == nil || keybundle.Key.Kid == nil {
+			return "", "", fmt.Errorf("key value is nil")
+		}
+		keys = append(keys, *keybundle.Key)
+		keyVersions = append(keyVersions, getObjectVersion(*keybundle.Key.Kid))
+	}
+	content, err := encodeJWKS(keys)
+	if err != nil {
+		return "", "", err
+	}
+	if len(keyVersions) == 1 {
+		return content, keyVersions[0], nil
+	}
+	return content, getCompositeVersion(keyVersions), nil
+}
+
+// getRecentEnabledVersions returns the versions of the most recently created
+// enabled keys, newest first
+func getRecentEnabledVersions(items []kv.KeyItem, count int) []string {
+	type keyVersion struct {
+		version string
+		created time.Time
+	}
+	var enabled []keyVersion
+	for _, item := range items {
+		if item.Kid == nil || item.Attributes == nil || item.Attributes.Enabled == nil || !*item.Attributes.Enabled {
+			continue
+		}
+		var created time.Time
+		if item.Attributes.Created != nil {
+			created = time.Time(*item.Attributes.Created)
+		}
+		enabled = append(enabled, keyVersion{version: getObjectVersion(*item.Kid), created: created})
+	}
+	sort.SliceStable(enabled, func(i, j int) bool {
+		if enabled[i].created.Equal(enabled[j].created) {
+			return enabled[i].version < enabled[j].version
+		}
+		return enabled[i].created.After(enabled[j].created)
+	})
+	var versions []string
+	for i := 0; i < len(enabled) && i < count; i++ {
+		versions = append(versions, enabled[i].version)
+	}
+	return versions
+}
+
+// getCompositeVersion derives a version from the versions of several objects.
+// The version changes when any of the versions change.
+func getCompositeVersion(versions []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(versions, ",")))
+	return hex.EncodeToString(sum[:16])
+}
+
+// getKeyFormatContent returns the JSON Web Key of the key object in the jwk, jwks or ssh object format
+func getKeyFormatContent(key kv.JSONWebKey, kvObject KeyVaultObject) (string, error) {
+	switch strings.ToLower(kvObject.ObjectFormat) {
+	case objectFormatJWK:
+		jwk, err := getPublicJWK(key)
+		if err != nil {
+			return "", err
+		}
+		data, err := json.Marshal(jwk)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	case objectFormatJWKS:
+		return encodeJWKS([]kv.JSONWebKey{key})
+	case objectFormatSSH:
+		return encodeSSHPublicKey(key, kvObject.ObjectName)
+	default:
+		return "", fmt.Errorf("invalid objectFormat: %v, should be JWK, JWKS or SSH", kvObject.ObjectFormat)
+	}
+}
+
+// getPublicJWK returns the public components of the JSON Web Key. The base64url encoded
+// components are copied as returned by Key Vault, so the key isn't altered by conversions.
+func getPublicJWK(key kv.JSONWebKey) (publicJWK, error) {
+	jwk := publicJWK{}
+	if key.Kid != nil {
+		jwk.Kid = *key.Kid
+	}
+	switch key.Kty {
+	case kv.RSA, kv.RSAHSM:
+		if key.N == nil || key.E == nil {
+			return jwk, fmt.Errorf("RSA key is missing the modulus or exponent")
+		}
+		jwk.Kty, jwk.N, jwk.E = "RSA", *key.N, *key.E
+	case kv.EC, kv.ECHSM:
+		if key.X == nil || key.Y == nil {
+			return jwk, fmt.Errorf("EC key is missing the x or y coordinate")
+		}
+		jwk.Kty, jwk.X, jwk.Y = "EC", *key.X, *key.Y
+		// SECP256K1 is registered as secp256k1 in RFC 8812
+		jwk.Crv = string(key.Crv)
+		if key.Crv == kv.SECP256K1 {
+			jwk.Crv = "secp256k1"
+		}
+	default:
+		return jwk, fmt.Errorf("key type '%s' currently not supported", key.Kty)
+	}
+	return jwk, nil
+}
+
+// encodeJWKS returns the JSON Web Key Set with the public components of the keys
+func encodeJWKS(keys []kv.JSONWebKey) (string, error) {
+	set := jwks{Keys: make([]publicJWK, 0, len(keys))}
+	for _, key := range keys {
+		jwk, err := getPublicJWK(key)
+		if err != nil {
+			return "", err
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	data, err := json.Marshal(set)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// encodeSSHPublicKey returns the key as an OpenSSH authorized_keys line with the comment
+func encodeSSHPublicKey(key kv.JSONWebKey, comment string) (string, error) {
+	pub, err := getPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+	sshKey, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	line := strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(sshKey)), "\n")
+	if len(comment) > 0 {
+		line += " " + comment
+	}
+	return line + "\n", nil
+}
+
+// getPublicKey returns the RSA or EC public key of the JSON Web Key
+func getPublicKey(key kv.JSONWebKey) (crypto.PublicKey, error) {
+	// the public key requires the same public components as the jwk
+	if _, err := getPublicJWK(key); err != nil {
+		return nil, err
+	}
+	switch key.Kty {
+	case kv.RSA, kv.RSAHSM:
+		nb, err := base64.RawURLEncoding.DecodeString(*key.N)
+		if err != nil {
+			return nil, err
+		}
+		eb, err := base64.RawURLEncoding.DecodeString(*key.E)
+		if err != nil {
+			return nil, err
+		}
+		e := new(big.Int).SetBytes(eb)
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("RSA public exponent is too large")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(nb), E: int(e.Int64())}, nil
+	case kv.EC, kv.ECHSM:
+		xb, err := base64.RawURLEncoding.DecodeString(*key.X)
+		if err != nil {
+			return nil, err
+		}
+		yb, err := base64.RawURLEncoding.DecodeString(*key.Y)
+		if err != nil {
+			return nil, err
+		}
+		crv, err := getCurve(key.Crv)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: crv, X: new(big.Int).SetBytes(xb), Y: new(big.Int).SetBytes(yb)}, nil
+	default:
+		return nil, fmt.Errorf("key type '%s' currently not supported", key.Kty)
+	}
+}
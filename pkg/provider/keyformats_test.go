@@ -0,0 +1,276 @@
+package provider
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	kv "github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+	"github.com/Azure/go-autorest/autorest/date"
+	"github.com/Azure/go-autorest/autorest/to"
+	"golang.org/x/crypto/ssh"
+)
+
+// newTestJSONWebKey returns the public key as a Key Vault JSON Web Key
+func newTestJSONWebKey(t *testing.T, pub interface{}, kid string) kv.JSONWebKey {
+	t.Helper()
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return kv.JSONWebKey{
+			Kid: to.StringPtr(kid),
+			Kty: kv.RSA,
+			N:   to.StringPtr(base64.RawURLEncoding.EncodeToString(pub.N.Bytes())),
+			E:   to.StringPtr(base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())),
+		}
+	case *ecdsa.PublicKey:
+		// the coordinates are encoded with the fixed size of the curve
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		crv := map[int]kv.JSONWebKeyCurveName{256: kv.P256, 384: kv.P384, 521: kv.P521}[pub.Curve.Params().BitSize]
+		return kv.JSONWebKey{
+			Kid: to.StringPtr(kid),
+			Kty: kv.ECHSM,
+			Crv: crv,
+			X:   to.StringPtr(base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, size)))),
+			Y:   to.StringPtr(base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, size)))),
+		}
+	default:
+		t.Fatalf("unsupported public key type %T", pub)
+		return kv.JSONWebKey{}
+	}
+}
+
+func TestValidateObjectVersionHistory(t *testing.T) {
+	cases := []struct {
+		desc        string
+		object      KeyVaultObject
+		expectedErr error
+	}{
+		{
+			desc:        "version history not configured",
+			object:      KeyVaultObject{ObjectType: "key", ObjectFormat: "jwks"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "version history without jwks",
+			object:      KeyVaultObject{ObjectType: "key", ObjectFormat: "jwk", ObjectVersionHistory: "2"},
+			expectedErr: fmt.Errorf("objectVersionHistory only supported with objectFormat: jwks"),
+		},
+		{
+			desc:        "invalid version history",
+			object:      KeyVaultObject{ObjectType: "key", ObjectFormat: "jwks", ObjectVersionHistory: "0"},
+			expectedErr: fmt.Errorf("invalid objectVersionHistory: 0, should be a number between 1 and 25"),
+		},
+		{
+			desc:        "version history with object version",
+			object:      KeyVaultObject{ObjectType: "key", ObjectFormat: "jwks", ObjectVersionHistory: "3", ObjectVersion: "v1"},
+			expectedErr: fmt.Errorf("objectVersionHistory can't be used with objectVersion"),
+		},
+		{
+			desc:        "valid version history",
+			object:      KeyVaultObject{ObjectType: "key", ObjectFormat: "JWKS", ObjectVersionHistory: "3"},
+			expectedErr: nil,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := validateObjectVersionHistory(tc.object)
+			if tc.expectedErr != nil && (err == nil || err.Error() != tc.expectedErr.Error()) || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestGetRecentEnabledVersions(t *testing.T) {
+	now := time.Now()
+	item := func(version string, enabled bool, created time.Time) kv.KeyItem {
+		return kv.KeyItem{
+			Kid: to.StringPtr("https://kv.vault.azure.net/keys/key1/" + version),
+			Attributes: &kv.KeyAttributes{
+				Enabled: to.BoolPtr(enabled),
+				Created: (*date.UnixTime)(&created),
+			},
+		}
+	}
+	items := []kv.KeyItem{
+		item("v1", true, now.Add(-3*time.Hour)),
+		item("v4", true, now),
+		item("v2", false, now.Add(-2*time.Hour)),
+		item("v3", true, now.Add(-time.Hour)),
+	}
+
+	cases := []struct {
+		desc     string
+		count    int
+		expected []string
+	}{
+		{
+			desc:     "most recent version",
+			count:    1,
+			expected: []string{"v4"},
+		},
+		{
+			desc:     "disabled versions are skipped",
+			count:    3,
+			expected: []string{"v4", "v3", "v1"},
+		},
+		{
+			desc:     "fewer enabled versions than requested",
+			count:    10,
+			expected: []string{"v4", "v3", "v1"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			versions := getRecentEnabledVersions(items, tc.count)
+			if !reflect.DeepEqual(versions, tc.expected) {
+				t.Fatalf("expected versions: %v, got: %v", tc.expected, versions)
+			}
+		})
+	}
+}
+
+func TestGetKeyFormatContent(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	rsaJWK := newTestJSONWebKey(t, &rsaKey.PublicKey, "https://kv.vault.azure.net/keys/rsa/v1")
+	ecJWK := newTestJSONWebKey(t, &ecKey.PublicKey, "https://kv.vault.azure.net/keys/ec/v1")
+
+	cases := []struct {
+		desc         string
+		key          kv.JSONWebKey
+		objectFormat string
+		expectedKey  interface{}
+		expectedJWK  *publicJWK
+		expectedErr  bool
+	}{
+		{
+			desc:         "rsa jwk",
+			key:          rsaJWK,
+			objectFormat: "jwk",
+			expectedJWK:  &publicJWK{Kty: "RSA", Kid: *rsaJWK.Kid, N: *rsaJWK.N, E: *rsaJWK.E},
+		},
+		{
+			desc:         "ec hsm jwk",
+			key:          ecJWK,
+			objectFormat: "jwk",
+			expectedJWK:  &publicJWK{Kty: "EC", Kid: *ecJWK.Kid, Crv: "P-256", X: *ecJWK.X, Y: *ecJWK.Y},
+		},
+		{
+			desc:         "ec jwks",
+			key:          ecJWK,
+			objectFormat: "jwks",
+			expectedJWK:  &publicJWK{Kty: "EC", Kid: *ecJWK.Kid, Crv: "P-256", X: *ecJWK.X, Y: *ecJWK.Y},
+		},
+		{
+			desc:         "rsa ssh",
+			key:          rsaJWK,
+			objectFormat: "ssh",
+			expectedKey:  &rsaKey.PublicKey,
+		},
+		{
+			desc:         "ec ssh",
+			key:          ecJWK,
+			objectFormat: "SSH",
+			expectedKey:  &ecKey.PublicKey,
+		},
+		{
+			desc:         "unsupported key type",
+			key:          kv.JSONWebKey{Kty: kv.Oct},
+			objectFormat: "jwk",
+			expectedErr:  true,
+		},
+		{
+			desc:         "missing public components",
+			key:          kv.JSONWebKey{Kty: kv.RSA, N: rsaJWK.N},
+			objectFormat: "ssh",
+			expectedErr:  true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			content, err := getKeyFormatContent(tc.key, KeyVaultObject{ObjectName: "key1", ObjectFormat: tc.objectFormat})
+			if tc.expectedErr && err == nil || !tc.expectedErr && err != nil {
+				t.Fatalf("expected error: %v, got error: %v", tc.expectedErr, err)
+			}
+			if tc.expectedErr {
+				return
+			}
+			switch tc.objectFormat {
+			case "jwk":
+				var jwk publicJWK
+				if err := json.Unmarshal([]byte(content), &jwk); err != nil {
+					t.Fatalf("expected nil err, got: %v", err)
+				}
+				if !reflect.DeepEqual(jwk, *tc.expectedJWK) {
+					t.Fatalf("expected jwk: %+v, got: %+v", *tc.expectedJWK, jwk)
+				}
+			case "jwks":
+				var set jwks
+				if err := json.Unmarshal([]byte(content), &set); err != nil {
+					t.Fatalf("expected nil err, got: %v", err)
+				}
+				if len(set.Keys) != 1 || !reflect.DeepEqual(set.Keys[0], *tc.expectedJWK) {
+					t.Fatalf("expected jwks with jwk: %+v, got: %+v", *tc.expectedJWK, set.Keys)
+				}
+			default:
+				pub, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(content))
+				if err != nil {
+					t.Fatalf("expected nil err, got: %v", err)
+				}
+				if comment != "key1" {
+					t.Fatalf("expected comment key1, got: %s", comment)
+				}
+				cryptoPub := pub.(ssh.CryptoPublicKey).CryptoPublicKey()
+				if !reflect.DeepEqual(cryptoPub, tc.expectedKey) {
+					t.Fatalf("expected ssh public key to match")
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeJWKS(t *testing.T) {
+	first, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	second, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	content, err := encodeJWKS([]kv.JSONWebKey{
+		newTestJSONWebKey(t, &first.PublicKey, "https://kv.vault.azure.net/keys/key1/v2"),
+		newTestJSONWebKey(t, &second.PublicKey, "https://kv.vault.azure.net/keys/key1/v1"),
+	})
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	var set jwks
+	if err := json.Unmarshal([]byte(content), &set); err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	if len(set.Keys) != 2 || set.Keys[0].Kid != "https://kv.vault.azure.net/keys/key1/v2" || set.Keys[1].Kid != "https://kv.vault.azure.net/keys/key1/v1" {
+		t.Fatalf("expected jwks with both versions in order, got: %+v", set.Keys)
+	}
+	if getCompositeVersion([]string{"v2", "v1"}) == getCompositeVersion([]string{"v3", "v1"}) {
+		t.Fatalf("expected composite version to change with the versions")
+	}
+}
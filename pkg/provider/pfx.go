@@ -0,0 +1,116 @@
+package provider
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+
+	"golang.org/x/net/context"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// getPfxContent converts the PEM content of the object to a base64 encoded pfx
+// protected with the password from the Key Vault secret set in pfxOutputPasswordSecretName
+func (p *Provider) getPfxContent(ctx context.Context, kvObject KeyVaultObject, content string) (string, error) {
+	password, err := p.getPfxOutputPassword(ctx, kvObject)
+	if err != nil {
+		return "", err
+	}
+	return encodePKCS12(content, password)
+}
+
+// convertPKCS12Secret decodes the PKCS#12 content of a secret with the password, the PEM
+// content is used as is. For objectFormat pfx, the PEM content is encoded to a PKCS#12
+// protected with the output password.
+func convertPKCS12Secret(content, password, outputPassword string, isPfxFormat bool) (string, error) {
+	if block, _ := pem.Decode([]byte(content)); block == nil {
+		var err error
+		if content, err = decodePKCS12(content, password); err != nil {
+			return "", err
+		}
+	}
+	if !isPfxFormat {
+		return content, nil
+	}
+	return encodePKCS12(content, outputPassword)
+}
+
+// encodePKCS12 converts the PEM private key and certificates to a base64 encoded PKCS#12
+// protected with the password. The PKCS#12 uses PBES2 with AES-256 encryption and a SHA-256
+// MAC. The certificate matching the private key is the leaf, the rest of the certificates
+// are added as CA certificates in the order they appear in the content. Without a private key,
+// the certificates are encoded as a trust store.
+func encodePKCS12(content, password string) (string, error) {
+	var privateKey crypto.Signer
+	var certs []*x509.Certificate
+	data := []byte(content)
+	for {
+		block, rest := pem.Decode(data)
+		if block == nil {
+			break
+		}
+		data = rest
+		if block.Type == certificateType {
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return "", err
+			}
+			certs = append(certs, cert)
+			continue
+		}
+		if privateKey != nil {
+			return "", fmt.Errorf("more than one private key found")
+		}
+		key, err := parsePrivateKey(block.Bytes)
+		if err != nil {
+			return "", err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return "", fmt.Errorf("unsupported private key type %T", key)
+		}
+		privateKey = signer
+	}
+	if len(certs) == 0 {
+		return "", fmt.Errorf("no certificates found")
+	}
+
+	if privateKey == nil {
+		pfxData, err := pkcs12.Modern2023.EncodeTrustStore(certs, password)
+		if err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString(pfxData), nil
+	}
+	leaf, caCerts, err := getKeyCertificate(privateKey, certs)
+	if err != nil {
+		return "", err
+	}
+	pfxData, err := pkcs12.Modern2023.Encode(privateKey, leaf, caCerts, password)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(pfxData), nil
+}
+
+// getKeyCertificate returns the certificate for the private key and the rest of the certificates
+func getKeyCertificate(privateKey crypto.Signer, certs []*x509.Certificate) (*x509.Certificate, []*x509.Certificate, error) {
+	type publicKey interface {
+		Equal(crypto.PublicKey) bool
+	}
+	pub, ok := privateKey.Public().(publicKey)
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported public key type %T", privateKey.Public())
+	}
+	for i, cert := range certs {
+		if pub.Equal(cert.PublicKey) {
+			caCerts := make([]*x509.Certificate, 0, len(certs)-1)
+			caCerts = append(caCerts, certs[:i]...)
+			caCerts = append(caCerts, certs[i+1:]...)
+			return cert, caCerts, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("no certificate found for the private key")
+}
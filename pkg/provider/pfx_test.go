@@ -0,0 +1,160 @@
+package provider
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"testing"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func TestEncodePKCS12(t *testing.T) {
+	root := newTestCA(t, "Test Root CA", nil)
+	intermediate := newTestCA(t, "Test Intermediate CA", root)
+	leaf := newTestLeaf(t, "example.com", intermediate)
+	other := newTestLeaf(t, "other.example.com", intermediate)
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(leaf.key)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	keyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes}))
+
+	cases := []struct {
+		desc            string
+		content         string
+		expectedCert    *x509.Certificate
+		expectedCACerts []*x509.Certificate
+		expectedErr     bool
+	}{
+		{
+			desc:            "private key and chain",
+			content:         keyPEM + leaf.pem() + intermediate.pem() + root.pem(),
+			expectedCert:    leaf.cert,
+			expectedCACerts: []*x509.Certificate{intermediate.cert, root.cert},
+		},
+		{
+			desc:            "leaf after the intermediate",
+			content:         intermediate.pem() + leaf.pem() + keyPEM,
+			expectedCert:    leaf.cert,
+			expectedCACerts: []*x509.Certificate{intermediate.cert},
+		},
+		{
+			desc:            "certificates without private key",
+			content:         intermediate.pem() + root.pem(),
+			expectedCACerts: []*x509.Certificate{intermediate.cert, root.cert},
+		},
+		{
+			desc:        "no certificate for the private key",
+			content:     keyPEM + other.pem(),
+			expectedErr: true,
+		},
+		{
+			desc:        "more than one private key",
+			content:     keyPEM + keyPEM + leaf.pem(),
+			expectedErr: true,
+		},
+		{
+			desc:        "no certificates",
+			content:     keyPEM,
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			content, err := encodePKCS12(tc.content, "password")
+			if tc.expectedErr && err == nil || !tc.expectedErr && err != nil {
+				t.Fatalf("expected error: %v, got error: %v", tc.expectedErr, err)
+			}
+			if tc.expectedErr {
+				return
+			}
+			pfxData, err := base64.StdEncoding.DecodeString(content)
+			if err != nil {
+				t.Fatalf("expected nil err, got: %v", err)
+			}
+			var caCerts []*x509.Certificate
+			if tc.expectedCert == nil {
+				caCerts, err = pkcs12.DecodeTrustStore(pfxData, "password")
+			} else {
+				var cert *x509.Certificate
+				_, cert, caCerts, err = pkcs12.DecodeChain(pfxData, "password")
+				if err == nil && !cert.Equal(tc.expectedCert) {
+					t.Fatalf("expected certificate %s, got: %s", tc.expectedCert.Subject, cert.Subject)
+				}
+			}
+			if err != nil {
+				t.Fatalf("expected nil err, got: %v", err)
+			}
+			if len(caCerts) != len(tc.expectedCACerts) {
+				t.Fatalf("expected %d ca certificates, got: %d", len(tc.expectedCACerts), len(caCerts))
+			}
+			for i := range caCerts {
+				if !caCerts[i].Equal(tc.expectedCACerts[i]) {
+					t.Fatalf("expected ca certificate %s, got: %s", tc.expectedCACerts[i].Subject, caCerts[i].Subject)
+				}
+			}
+			// the pfx can be decoded to pem with the password
+			if _, err := decodePKCS12(content, "password"); tc.expectedCert != nil && err != nil {
+				t.Fatalf("expected nil err, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestConvertPKCS12Secret(t *testing.T) {
+	root := newTestCA(t, "Test Root CA", nil)
+	leaf := newTestLeaf(t, "example.com", root)
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(leaf.key)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	pemContent := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})) + leaf.pem() + root.pem()
+	pfxContent, err := encodePKCS12(pemContent, "input")
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+
+	cases := []struct {
+		desc        string
+		content     string
+		isPfxFormat bool
+	}{
+		{
+			desc:    "pfx converted to pem",
+			content: pfxContent,
+		},
+		{
+			desc:        "pfx protected with the output password",
+			content:     pfxContent,
+			isPfxFormat: true,
+		},
+		{
+			desc:        "pem protected with the output password",
+			content:     pemContent,
+			isPfxFormat: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			content, err := convertPKCS12Secret(tc.content, "input", "output", tc.isPfxFormat)
+			if err != nil {
+				t.Fatalf("expected nil err, got: %v", err)
+			}
+			if tc.isPfxFormat {
+				if content, err = decodePKCS12(content, "output"); err != nil {
+					t.Fatalf("expected pfx protected with the output password, got: %v", err)
+				}
+			}
+			certs, err := parseCertificates([]byte(content))
+			if err != nil {
+				t.Fatalf("expected nil err, got: %v", err)
+			}
+			if len(certs) != 2 || !certs[0].Equal(leaf.cert) {
+				t.Fatalf("expected the leaf and root certificates, got: %d certificates", len(certs))
+			}
+		})
+	}
+}
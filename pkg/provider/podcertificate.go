@@ -0,0 +1,472 @@
+package provider
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	kv "github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+	"golang.org/x/net/context"
+	"k8s.io/klog/v2"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+const (
+	// defaultPodCertificateLifetime is the lifetime of the pod certificates if lifetime isn't set
+	defaultPodCertificateLifetime = 24 * time.Hour
+	// maxPodCertificateLifetime is the maximum lifetime of the pod certificates
+	maxPodCertificateLifetime = 90 * 24 * time.Hour
+	// defaultPodCertificateRenewalFraction is the fraction of the lifetime after which the
+	// pod certificates are renewed if renewalFraction isn't set
+	defaultPodCertificateRenewalFraction = 2.0 / 3
+	// defaultPodCertificateSubject is the common name of the pod certificates if subject isn't set
+	defaultPodCertificateSubject = "{{ .PodName }}.{{ .PodNamespace }}"
+	// defaultPodCertificateURI is the SPIFFE ID of the service account of the pod, set as the
+	// URI of the pod certificates if uris isn't set
+	defaultPodCertificateURI = "spiffe://cluster.local/ns/{{ .PodNamespace }}/sa/{{ .ServiceAccount }}"
+
+	keyTypeECDSA   = "ecdsa"
+	keyTypeRSA     = "rsa"
+	keyTypeEd25519 = "ed25519"
+)
+
+// x509SignatureAlgorithms are the certificate signature algorithms of the supported
+// signature algorithms
+var x509SignatureAlgorithms = map[string]x509.SignatureAlgorithm{
+	"RS256": x509.SHA256WithRSA,
+	"RS384": x509.SHA384WithRSA,
+	"RS512": x509.SHA512WithRSA,
+	"PS256": x509.SHA256WithRSAPSS,
+	"PS384": x509.SHA384WithRSAPSS,
+	"PS512": x509.SHA512WithRSAPSS,
+	"ES256": x509.ECDSAWithSHA256,
+	"ES384": x509.ECDSAWithSHA384,
+	"ES512": x509.ECDSAWithSHA512,
+}
+
+// validatePodCertificate checks if the pod certificate options are valid for the given object
+func validatePodCertificate(kvObject KeyVaultObject) error {
+	if kvObject.ObjectType != VaultObjectTypePodCertificate {
+		if len(kvObject.DNSNames) > 0 || len(kvObject.URIs) > 0 || len(kvObject.RenewalFraction) > 0 || len(kvObject.CACertificateAlias) > 0 {
+			return fmt.Errorf("dnsNames, uris, renewalFraction and caCertificateAlias only supported for objectType: podCertificate")
+		}
+		// the key type of the SSH certificates is validated with the SSH certificate options
+		if kvObject.ObjectType != VaultObjectTypeSSHCertificate && len(kvObject.KeyType) > 0 {
+			return fmt.Errorf("keyType only supported for objectType: podCertificate, sshCertificate")
+		}
+		return nil
+	}
+	if _, err := getLifetime(kvObject.Lifetime, defaultPodCertificateLifetime, maxPodCertificateLifetime); err != nil {
+		return err
+	}
+	if len(kvObject.SigningAlgorithm) > 0 {
+		if err := validateSigningAlgorithm(kvObject.SigningAlgorithm); err != nil {
+			return err
+		}
+	}
+	if err := validateKeyType(kvObject.KeyType); err != nil {
+		return err
+	}
+	if _, err := getRenewalFraction(kvObject.RenewalFraction); err != nil {
+		return err
+	}
+	if len(kvObject.CACertificateAlias) > 0 {
+		if err := validateFileName(kvObject.CACertificateAlias); err != nil {
+			return fmt.Errorf("invalid caCertificateAlias, error: %w", err)
+		}
+	}
+	// the templates are rendered with empty pod metadata to fail early
+	if _, err := getPodCertificateTemplate(kvObject, podTemplateData{}, time.Now()); err != nil {
+		return err
+	}
+	return nil
+}
+
+// validateKeyType checks if the type of the generated private key is supported
+func validateKeyType(keyType string) error {
+	if len(keyType) > 0 && !strings.EqualFold(keyType, keyTypeECDSA) && !strings.EqualFold(keyType, keyTypeRSA) && !strings.EqualFold(keyType, keyTypeEd25519) {
+		return fmt.Errorf("invalid keyType: %v, should be ecdsa, rsa or ed25519", keyType)
+	}
+	return nil
+}
+
+// getRenewalFraction parses the fraction of the lifetime after which the certificates are renewed
+func getRenewalFraction(renewalFraction string) (float64, error) {
+	if len(renewalFraction) == 0 {
+		return defaultPodCertificateRenewalFraction, nil
+	}
+	f, err := strconv.ParseFloat(renewalFraction, 64)
+	if err != nil || f <= 0 || f >= 1 {
+		return 0, fmt.Errorf("invalid renewalFraction: %v, should be a number between 0 and 1 such as 0.5", renewalFraction)
+	}
+	return f, nil
+}
+
+// getPodCertificateContent issues a certificate to the pod signed with the CA key of the Key
+// Vault certificate objectName, and returns the generated private key, the certificate and the
+// CA chain in PEM format. The certificate written on the previous mount is reused until it
+// passes the renewal fraction of its lifetime, so with auto rotation enabled the certificate
+// is renewed on the first rotation poll after that.
+func (p *Provider) getPodCertificateContent(ctx context.Context, kvClient *kv.BaseClient, vaultURL string, kvObject KeyVaultObject) (string, string, error) {
+	// the default uri is the SPIFFE ID of the service account, which is only set by the
+	// driver if the pod info is passed to the provider
+	if len(kvObject.URIs) == 0 && len(p.ServiceAccountName) == 0 {
+		return "", "", fmt.Errorf("service account name of the pod is required for the default uri, set uris or enable podInfoOnMount in the CSIDriver")
+	}
+	certbundle, err := kvClient.GetCertificate(ctx, vaultURL, kvObject.ObjectName, kvObject.ObjectVersion)
+	if err != nil {
+		return "", "", wrapObjectTypeError(err, VaultObjectTypeCertificate, kvObject.ObjectName, kvObject.ObjectVersion)
+	}
+	if certbundle.Cer == nil || certbundle.Kid == nil {
+		return "", "", fmt.Errorf("certificate %s value is nil", kvObject.ObjectName)
+	}
+	ca, err := x509.ParseCertificate(*certbundle.Cer)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to parse certificate %s, error: %w", kvObject.ObjectName, err)
+	}
+	if !ca.IsCA {
+		return "", "", fmt.Errorf("certificate %s is not a CA certificate", kvObject.ObjectName)
+	}
+	caChain, err := p.getCAChainPEM(ctx, kvClient, vaultURL, certbundle, ca)
+	if err != nil {
+		return "", "", err
+	}
+	now := time.Now()
+	template, err := getPodCertificateTemplate(kvObject, p.getPodTemplateData(), now)
+	if err != nil {
+		return "", "", err
+	}
+	renewalFraction, err := getRenewalFraction(kvObject.RenewalFraction)
+	if err != nil {
+		return "", "", err
+	}
+
+	if previous, err := os.ReadFile(filepath.Join(p.TargetPath, getObjectFileName(kvObject))); err == nil {
+		if key, cert, ok := getReusablePodCertificate(previous, template, ca, kvObject.KeyType, renewalFraction, now); ok {
+			klog.V(2).InfoS("certificate not due for renewal", "objectName", kvObject.ObjectName, "serialNumber", cert.SerialNumber.Text(16), "pod", klog.ObjectRef{Namespace: p.PodNamespace, Name: p.PodName})
+			content, err := encodePodCertificate(key, cert, caChain)
+			if err != nil {
+				return "", "", err
+			}
+			return content, cert.SerialNumber.Text(16), nil
+		}
+	}
+
+	key, err := generatePrivateKey(kvObject.KeyType)
+	if err != nil {
+		return "", "", err
+	}
+	// the certificate is signed with the key of the version of the CA certificate
+	caKey, sign, err := p.getKeyVaultSigner(ctx, kvClient, vaultURL, kvObject.ObjectName, getObjectVersion(*certbundle.Kid))
+	if err != nil {
+		return "", "", err
+	}
+	caSigner, err := newCryptoSigner(ctx, *caKey, sign)
+	if err != nil {
+		return "", "", err
+	}
+	cert, err := issuePodCertificate(template, ca, key, caSigner)
+	if err != nil {
+		return "", "", err
+	}
+	klog.InfoS("issued certificate", "objectName", kvObject.ObjectName, "serialNumber", cert.SerialNumber.Text(16), "expiry", cert.NotAfter, "pod", klog.ObjectRef{Namespace: p.PodNamespace, Name: p.PodName})
+	content, err := encodePodCertificate(key, cert, caChain)
+	if err != nil {
+		return "", "", err
+	}
+	return content, cert.SerialNumber.Text(16), nil
+}
+
+// getCAChainPEM returns the CA certificate followed by its issuers in PEM format. The issuers
+// are read from the certificates of the secret backing the Key Vault certificate, which needs
+// the get permission on secrets: if the secret can't be read only the CA certificate is
+// returned. The chain is completed from the AIA CA Issuers URLs if enabled.
+func (p *Provider) getCAChainPEM(ctx context.Context, kvClient *kv.BaseClient, vaultURL string, certbundle kv.CertificateBundle, ca *x509.Certificate) ([]byte, error) {
+	var certs []*x509.Certificate
+	if certbundle.Sid != nil {
+		var err error
+		if certs, err = getCertificateSecretCertificates(ctx, kvClient, vaultURL, *certbundle.Sid); err != nil {
+			klog.V(2).InfoS("failed to get the issuers of the CA certificate", "sid", *certbundle.Sid, "error", err, "pod", klog.ObjectRef{Namespace: p.PodNamespace, Name: p.PodName})
+		}
+	}
+	var pemData []byte
+	for _, cert := range buildCAChain(ca, certs) {
+		pemData = append(pemData, pem.EncodeToMemory(&pem.Block{Type: certificateType, Bytes: cert.Raw})...)
+	}
+	if *FetchAIAIssuers {
+		return completeCertChain(pemData)
+	}
+	return pemData, nil
+}
+
+// getCertificateSecretCertificates returns the certificates of the secret backing a Key Vault
+// certificate, the private key in the secret isn't decoded
+func getCertificateSecretCertificates(ctx context.Context, kvClient *kv.BaseClient, vaultURL, sid string) ([]*x509.Certificate, error) {
+	splitID := strings.Split(sid, "/")
+	if len(splitID) < 2 {
+		return nil, fmt.Errorf("invalid secret id: %s", sid)
+	}
+	secret, err := kvClient.GetSecret(ctx, vaultURL, splitID[len(splitID)-2], splitID[len(splitID)-1])
+	if err != nil {
+		return nil, err
+	}
+	if secret.Value == nil {
+		return nil, fmt.Errorf("secret value is nil")
+	}
+	if secret.ContentType == nil || *secret.ContentType != certTypePfx {
+		return parseCertificates([]byte(*secret.Value))
+	}
+	pfxRaw, err := base64.StdEncoding.DecodeString(*secret.Value)
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := pkcs12.ToPEM(pfxRaw, "") //nolint:staticcheck
+	if err != nil {
+		return nil, err
+	}
+	var certs []*x509.Certificate
+	for _, block := range blocks {
+		if block.Type != certificateType {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// buildCAChain returns the CA certificate followed by the certificates it chains to in certs
+func buildCAChain(ca *x509.Certificate, certs []*x509.Certificate) []*x509.Certificate {
+	chain := []*x509.Certificate{ca}
+	current := ca
+	for len(chain) <= len(certs) && !bytes.Equal(current.RawIssuer, current.RawSubject) {
+		var issuer *x509.Certificate
+		for _, cert := range certs {
+			if !cert.Equal(current) && current.CheckSignatureFrom(cert) == nil {
+				issuer = cert
+				break
+			}
+		}
+		if issuer == nil {
+			break
+		}
+		chain = append(chain, issuer)
+		current = issuer
+	}
+	return chain
+}
+
+// getPodCertificateTemplate returns the template of the certificate issued at now. The common
+// name, DNS names and URIs are templated with the pod metadata.
+func getPodCertificateTemplate(kvObject KeyVaultObject, pod podTemplateData, now time.Time) (*x509.Certificate, error) {
+	lifetime, err := getLifetime(kvObject.Lifetime, defaultPodCertificateLifetime, maxPodCertificateLifetime)
+	if err != nil {
+		return nil, err
+	}
+	subject := kvObject.Subject
+	if len(subject) == 0 {
+		subject = defaultPodCertificateSubject
+	}
+	commonName, err := renderPodTemplate("certificate", "subject", subject, pod)
+	if err != nil {
+		return nil, err
+	}
+	var dnsNames []string
+	for _, dnsName := range splitList(kvObject.DNSNames) {
+		rendered, err := renderPodTemplate("certificate", "dnsNames", dnsName, pod)
+		if err != nil {
+			return nil, err
+		}
+		dnsNames = append(dnsNames, rendered)
+	}
+	uriList := splitList(kvObject.URIs)
+	if len(uriList) == 0 {
+		uriList = []string{defaultPodCertificateURI}
+	}
+	var uris []*url.URL
+	for _, uri := range uriList {
+		rendered, err := renderPodTemplate("certificate", "uris", uri, pod)
+		if err != nil {
+			return nil, err
+		}
+		u, err := url.Parse(rendered)
+		if err != nil || len(u.Scheme) == 0 {
+			return nil, fmt.Errorf("invalid uri: %v, should be an absolute URI such as spiffe://cluster.local/ns/default/sa/default", rendered)
+		}
+		uris = append(uris, u)
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	return &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: commonName},
+		DNSNames:              dnsNames,
+		URIs:                  uris,
+		NotBefore:             now,
+		NotAfter:              now.Add(lifetime),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		SignatureAlgorithm:    x509SignatureAlgorithms[strings.ToUpper(kvObject.SigningAlgorithm)],
+	}, nil
+}
+
+// generatePrivateKey generates a private key of the key type, an ECDSA P-256 key if not set
+func generatePrivateKey(keyType string) (crypto.Signer, error) {
+	switch {
+	case len(keyType) == 0 || strings.EqualFold(keyType, keyTypeECDSA):
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case strings.EqualFold(keyType, keyTypeRSA):
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case strings.EqualFold(keyType, keyTypeEd25519):
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	default:
+		return nil, validateKeyType(keyType)
+	}
+}
+
+// getKeyType returns the key type of the private key
+func getKeyType(key crypto.Signer) string {
+	switch key.(type) {
+	case *ecdsa.PrivateKey:
+		return keyTypeECDSA
+	case *rsa.PrivateKey:
+		return keyTypeRSA
+	case ed25519.PrivateKey:
+		return keyTypeEd25519
+	default:
+		return ""
+	}
+}
+
+// issuePodCertificate signs the certificate of the public key of the private key with the CA
+func issuePodCertificate(template, ca *x509.Certificate, key, caSigner crypto.Signer) (*x509.Certificate, error) {
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	// the certificate isn't valid after the CA expires
+	if template.NotAfter.After(ca.NotAfter) {
+		template.NotAfter = ca.NotAfter
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, key.Public(), caSigner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign certificate, error: %w", err)
+	}
+	return x509.ParseCertificate(der)
+}
+
+// getReusablePodCertificate returns the private key and certificate in the content written on
+// the previous mount if the certificate was issued by the CA for the same names and key type,
+// and hasn't passed the renewal fraction of its lifetime
+func getReusablePodCertificate(content []byte, template, ca *x509.Certificate, keyType string, renewalFraction float64, now time.Time) (crypto.Signer, *x509.Certificate, bool) {
+	var key crypto.Signer
+	var cert *x509.Certificate
+	for {
+		block, rest := pem.Decode(content)
+		if block == nil {
+			break
+		}
+		content = rest
+		if block.Type == certificateType {
+			if cert == nil {
+				cert, _ = x509.ParseCertificate(block.Bytes)
+			}
+			continue
+		}
+		if parsed, err := parsePrivateKey(block.Bytes); err == nil && key == nil {
+			key, _ = parsed.(crypto.Signer)
+		}
+	}
+	if key == nil || cert == nil || cert.CheckSignatureFrom(ca) != nil {
+		return nil, nil, false
+	}
+	if public, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !public.Equal(cert.PublicKey) {
+		return nil, nil, false
+	}
+	if len(keyType) == 0 {
+		keyType = keyTypeECDSA
+	}
+	if getKeyType(key) != strings.ToLower(keyType) {
+		return nil, nil, false
+	}
+	if cert.Subject.CommonName != template.Subject.CommonName || !reflect.DeepEqual(cert.DNSNames, template.DNSNames) || !reflect.DeepEqual(getURIStrings(cert.URIs), getURIStrings(template.URIs)) {
+		return nil, nil, false
+	}
+	renewAt := cert.NotBefore.Add(time.Duration(renewalFraction * float64(cert.NotAfter.Sub(cert.NotBefore))))
+	if !now.Before(renewAt) {
+		return nil, nil, false
+	}
+	return key, cert, true
+}
+
+// getURIStrings returns the string forms of the URIs
+func getURIStrings(uris []*url.URL) []string {
+	var s []string
+	for _, u := range uris {
+		s = append(s, u.String())
+	}
+	return s
+}
+
+// encodePodCertificate returns the PKCS#8 private key, the certificate and the CA chain in PEM format
+func encodePodCertificate(key crypto.Signer, cert *x509.Certificate, caChain []byte) (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	var pemData []byte
+	pemData = append(pemData, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})...)
+	pemData = append(pemData, pem.EncodeToMemory(&pem.Block{Type: certificateType, Bytes: cert.Raw})...)
+	pemData = append(pemData, caChain...)
+	return string(pemData), nil
+}
+
+// getCACertificatesPEM returns the certificates following the pod certificate in the PEM data
+func getCACertificatesPEM(content string) ([]byte, error) {
+	var pemData []byte
+	foundLeaf := false
+	data := []byte(content)
+	for {
+		block, rest := pem.Decode(data)
+		if block == nil {
+			break
+		}
+		data = rest
+		if block.Type != certificateType {
+			continue
+		}
+		if !foundLeaf {
+			foundLeaf = true
+			continue
+		}
+		pemData = append(pemData, pem.EncodeToMemory(block)...)
+	}
+	if len(pemData) == 0 {
+		return nil, fmt.Errorf("no CA certificate found")
+	}
+	return pemData, nil
+}
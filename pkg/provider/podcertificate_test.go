@@ -0,0 +1,343 @@
+package provider
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestValidatePodCertificate(t *testing.T) {
+	cases := []struct {
+		desc        string
+		object      KeyVaultObject
+		expectedErr error
+	}{
+		{
+			desc:        "certificate options for object type secret",
+			object:      KeyVaultObject{ObjectName: "secret1", ObjectType: "secret", DNSNames: "checkout.shop.svc"},
+			expectedErr: fmt.Errorf("dnsNames, uris, renewalFraction and caCertificateAlias only supported for objectType: podCertificate"),
+		},
+		{
+			desc:        "key type for object type jwt",
+			object:      KeyVaultObject{ObjectName: "assertion-key", ObjectType: "jwt", KeyType: "rsa"},
+			expectedErr: fmt.Errorf("keyType only supported for objectType: podCertificate, sshCertificate"),
+		},
+		{
+			desc:        "default options",
+			object:      KeyVaultObject{ObjectName: "workload-ca", ObjectType: "podCertificate"},
+			expectedErr: nil,
+		},
+		{
+			desc: "all options",
+			object: KeyVaultObject{
+				ObjectName:         "workload-ca",
+				ObjectType:         "podCertificate",
+				Subject:            "{{ .ServiceAccount }}",
+				DNSNames:           "{{ .ServiceAccount }}.{{ .PodNamespace }}.svc",
+				URIs:               "spiffe://contoso.com/ns/{{ .PodNamespace }}/sa/{{ .ServiceAccount }}",
+				Lifetime:           "12h",
+				KeyType:            "RSA",
+				SigningAlgorithm:   "ES384",
+				RenewalFraction:    "0.5",
+				CACertificateAlias: "ca.crt",
+			},
+			expectedErr: nil,
+		},
+		{
+			desc:        "lifetime too long",
+			object:      KeyVaultObject{ObjectName: "workload-ca", ObjectType: "podCertificate", Lifetime: "8760h"},
+			expectedErr: fmt.Errorf("invalid lifetime: 8760h, should be a duration such as 5m or 1h of at most 2160h0m0s"),
+		},
+		{
+			desc:        "invalid key type",
+			object:      KeyVaultObject{ObjectName: "workload-ca", ObjectType: "podCertificate", KeyType: "dsa"},
+			expectedErr: fmt.Errorf("invalid keyType: dsa, should be ecdsa, rsa or ed25519"),
+		},
+		{
+			desc:        "invalid renewal fraction",
+			object:      KeyVaultObject{ObjectName: "workload-ca", ObjectType: "podCertificate", RenewalFraction: "1.5"},
+			expectedErr: fmt.Errorf("invalid renewalFraction: 1.5, should be a number between 0 and 1 such as 0.5"),
+		},
+		{
+			desc:        "invalid CA certificate alias",
+			object:      KeyVaultObject{ObjectName: "workload-ca", ObjectType: "podCertificate", CACertificateAlias: "../ca.crt"},
+			expectedErr: fmt.Errorf("invalid caCertificateAlias, error: file name must not contain '..'"),
+		},
+		{
+			desc:        "relative uri",
+			object:      KeyVaultObject{ObjectName: "workload-ca", ObjectType: "podCertificate", URIs: "checkout"},
+			expectedErr: fmt.Errorf("invalid uri: checkout, should be an absolute URI such as spiffe://cluster.local/ns/default/sa/default"),
+		},
+		{
+			desc:        "invalid template",
+			object:      KeyVaultObject{ObjectName: "workload-ca", ObjectType: "podCertificate", DNSNames: "{{ .PodName "},
+			expectedErr: fmt.Errorf("failed to parse template of certificate dnsNames, error: template: dnsNames:1: unclosed action"),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := validatePodCertificate(tc.object)
+			if tc.expectedErr != nil && (err == nil || err.Error() != tc.expectedErr.Error()) || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestGetPodCertificateTemplate(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	pod := podTemplateData{PodName: "checkout-7d9f", PodNamespace: "shop", ServiceAccount: "checkout"}
+	cases := []struct {
+		desc               string
+		object             KeyVaultObject
+		expectedCommonName string
+		expectedDNSNames   []string
+		expectedURIs       []string
+		expectedNotAfter   time.Time
+		expectedAlgorithm  x509.SignatureAlgorithm
+	}{
+		{
+			desc:               "default names",
+			object:             KeyVaultObject{ObjectType: "podCertificate"},
+			expectedCommonName: "checkout-7d9f.shop",
+			expectedURIs:       []string{"spiffe://cluster.local/ns/shop/sa/checkout"},
+			expectedNotAfter:   now.Add(24 * time.Hour),
+		},
+		{
+			desc: "templated names",
+			object: KeyVaultObject{
+				ObjectType:       "podCertificate",
+				Subject:          "{{ .ServiceAccount }}",
+				DNSNames:         "{{ .ServiceAccount }}.{{ .PodNamespace }}.svc, {{ .ServiceAccount }}.{{ .PodNamespace }}.svc.cluster.local",
+				URIs:             "spiffe://contoso.com/ns/{{ .PodNamespace }}/sa/{{ .ServiceAccount }}",
+				Lifetime:         "1h",
+				SigningAlgorithm: "ps256",
+			},
+			expectedCommonName: "checkout",
+			expectedDNSNames:   []string{"checkout.shop.svc", "checkout.shop.svc.cluster.local"},
+			expectedURIs:       []string{"spiffe://contoso.com/ns/shop/sa/checkout"},
+			expectedNotAfter:   now.Add(time.Hour),
+			expectedAlgorithm:  x509.SHA256WithRSAPSS,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			template, err := getPodCertificateTemplate(tc.object, pod, now)
+			if err != nil {
+				t.Fatalf("expected nil err, got: %v", err)
+			}
+			if template.Subject.CommonName != tc.expectedCommonName {
+				t.Fatalf("expected common name: %s, got: %s", tc.expectedCommonName, template.Subject.CommonName)
+			}
+			if !reflect.DeepEqual(template.DNSNames, tc.expectedDNSNames) {
+				t.Fatalf("expected DNS names: %v, got: %v", tc.expectedDNSNames, template.DNSNames)
+			}
+			if !reflect.DeepEqual(getURIStrings(template.URIs), tc.expectedURIs) {
+				t.Fatalf("expected URIs: %v, got: %v", tc.expectedURIs, getURIStrings(template.URIs))
+			}
+			if !template.NotBefore.Equal(now) || !template.NotAfter.Equal(tc.expectedNotAfter) {
+				t.Fatalf("expected validity %v to %v, got: %v to %v", now, tc.expectedNotAfter, template.NotBefore, template.NotAfter)
+			}
+			if template.SignatureAlgorithm != tc.expectedAlgorithm {
+				t.Fatalf("expected signature algorithm: %v, got: %v", tc.expectedAlgorithm, template.SignatureAlgorithm)
+			}
+		})
+	}
+}
+
+func TestIssuePodCertificate(t *testing.T) {
+	ca := newTestCA(t, "workload-ca", nil)
+	caSigner, err := newCryptoSigner(context.TODO(), newTestJSONWebKey(t, ca.key.Public(), "https://myvault.vault.azure.net/keys/workload-ca/v1"), newTestSignFunc(ca.key))
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	pod := podTemplateData{PodName: "checkout-7d9f", PodNamespace: "shop", ServiceAccount: "checkout"}
+
+	for _, keyType := range []string{"", "rsa", "ed25519"} {
+		t.Run("key type "+keyType, func(t *testing.T) {
+			template, err := getPodCertificateTemplate(KeyVaultObject{ObjectType: "podCertificate", DNSNames: "checkout.shop.svc"}, pod, time.Now())
+			if err != nil {
+				t.Fatalf("expected nil err, got: %v", err)
+			}
+			key, err := generatePrivateKey(keyType)
+			if err != nil {
+				t.Fatalf("expected nil err, got: %v", err)
+			}
+			cert, err := issuePodCertificate(template, ca.cert, key, caSigner)
+			if err != nil {
+				t.Fatalf("expected nil err, got: %v", err)
+			}
+			roots := x509.NewCertPool()
+			roots.AddCert(ca.cert)
+			for _, usage := range []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth} {
+				if _, err := cert.Verify(x509.VerifyOptions{DNSName: "checkout.shop.svc", Roots: roots, KeyUsages: []x509.ExtKeyUsage{usage}}); err != nil {
+					t.Fatalf("expected valid certificate, got: %v", err)
+				}
+			}
+			// the test CA expires in an hour, before the default lifetime
+			if !cert.NotAfter.Equal(ca.cert.NotAfter) {
+				t.Fatalf("expected certificate to expire with the CA at %v, got: %v", ca.cert.NotAfter, cert.NotAfter)
+			}
+			_, isRSA := key.(*rsa.PrivateKey)
+			if hasKeyEncipherment := cert.KeyUsage&x509.KeyUsageKeyEncipherment != 0; hasKeyEncipherment != isRSA {
+				t.Fatalf("expected key encipherment usage only for RSA keys, got: %v", cert.KeyUsage)
+			}
+		})
+	}
+}
+
+func TestGetReusablePodCertificate(t *testing.T) {
+	ca := newTestCA(t, "workload-ca", nil)
+	otherCA := newTestCA(t, "other-ca", nil)
+	caSigner, err := newCryptoSigner(context.TODO(), newTestJSONWebKey(t, ca.key.Public(), "https://myvault.vault.azure.net/keys/workload-ca/v1"), newTestSignFunc(ca.key))
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	pod := podTemplateData{PodName: "checkout-7d9f", PodNamespace: "shop", ServiceAccount: "checkout"}
+	issuedAt := time.Now().Add(-time.Minute)
+	object := KeyVaultObject{ObjectType: "podCertificate", Lifetime: "30m"}
+	template, err := getPodCertificateTemplate(object, pod, issuedAt)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	key, err := generatePrivateKey("")
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	cert, err := issuePodCertificate(template, ca.cert, key, caSigner)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	content, err := encodePodCertificate(key, cert, []byte(ca.pem()))
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+
+	cases := []struct {
+		desc     string
+		content  string
+		pod      podTemplateData
+		ca       *x509.Certificate
+		keyType  string
+		now      time.Time
+		expected bool
+	}{
+		{desc: "not due for renewal", content: content, pod: pod, ca: ca.cert, now: issuedAt.Add(10 * time.Minute), expected: true},
+		{desc: "due for renewal", content: content, pod: pod, ca: ca.cert, now: issuedAt.Add(20 * time.Minute), expected: false},
+		{desc: "different CA", content: content, pod: pod, ca: otherCA.cert, now: issuedAt, expected: false},
+		{desc: "different key type", content: content, pod: pod, ca: ca.cert, keyType: "rsa", now: issuedAt, expected: false},
+		{desc: "different service account", content: content, pod: podTemplateData{PodName: "checkout-7d9f", PodNamespace: "shop", ServiceAccount: "payments"}, ca: ca.cert, now: issuedAt, expected: false},
+		{desc: "private key missing", content: content[strings.Index(content, "-----BEGIN CERTIFICATE"):], pod: pod, ca: ca.cert, now: issuedAt, expected: false},
+		{desc: "not PEM", content: "tls.crt", pod: pod, ca: ca.cert, now: issuedAt, expected: false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			template, err := getPodCertificateTemplate(object, tc.pod, tc.now)
+			if err != nil {
+				t.Fatalf("expected nil err, got: %v", err)
+			}
+			reusedKey, reusedCert, ok := getReusablePodCertificate([]byte(tc.content), template, tc.ca, tc.keyType, 2.0/3, tc.now)
+			if ok != tc.expected {
+				t.Fatalf("expected reusable: %v, got: %v", tc.expected, ok)
+			}
+			if ok && (!reusedCert.Equal(cert) || !reusedKey.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(key.Public())) {
+				t.Fatalf("expected the previous key and certificate")
+			}
+		})
+	}
+}
+
+func TestGetCACertificatesPEM(t *testing.T) {
+	ca := newTestCA(t, "workload-ca", nil)
+	leaf := newTestLeaf(t, "checkout.shop.svc", ca)
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	content, err := encodePodCertificate(key, leaf.cert, []byte(ca.pem()))
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	caContent, err := getCACertificatesPEM(content)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	if string(caContent) != ca.pem() {
+		t.Fatalf("expected CA certificate: %s, got: %s", ca.pem(), caContent)
+	}
+	if _, err := getCACertificatesPEM(leaf.pem()); err == nil || err.Error() != "no CA certificate found" {
+		t.Fatalf("expected no CA certificate error, got: %v", err)
+	}
+}
+
+func TestBuildCAChain(t *testing.T) {
+	root := newTestCA(t, "root", nil)
+	intermediate := newTestCA(t, "intermediate", root)
+	ca := newTestCA(t, "workload-ca", intermediate)
+	other := newTestCA(t, "other-ca", nil)
+
+	cases := []struct {
+		desc     string
+		ca       *x509.Certificate
+		certs    []*x509.Certificate
+		expected []*x509.Certificate
+	}{
+		{
+			desc:     "no certificates in the secret",
+			ca:       ca.cert,
+			expected: []*x509.Certificate{ca.cert},
+		},
+		{
+			desc:     "chain in the secret in any order",
+			ca:       ca.cert,
+			certs:    []*x509.Certificate{root.cert, ca.cert, other.cert, intermediate.cert},
+			expected: []*x509.Certificate{ca.cert, intermediate.cert, root.cert},
+		},
+		{
+			desc:     "root missing from the secret",
+			ca:       ca.cert,
+			certs:    []*x509.Certificate{ca.cert, intermediate.cert},
+			expected: []*x509.Certificate{ca.cert, intermediate.cert},
+		},
+		{
+			desc:     "self-signed CA",
+			ca:       other.cert,
+			certs:    []*x509.Certificate{other.cert, root.cert},
+			expected: []*x509.Certificate{other.cert},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			chain := buildCAChain(tc.ca, tc.certs)
+			if len(chain) != len(tc.expected) {
+				t.Fatalf("expected %d certificates, got: %d", len(tc.expected), len(chain))
+			}
+			for i := range chain {
+				if !chain[i].Equal(tc.expected[i]) {
+					t.Fatalf("expected certificate %d: %s, got: %s", i, tc.expected[i].Subject.CommonName, chain[i].Subject.CommonName)
+				}
+			}
+		})
+	}
+}
+
+func TestGetPodCertificateContentServiceAccountRequired(t *testing.T) {
+	p := &Provider{PodName: "checkout-7d9f", PodNamespace: "shop"}
+	object := KeyVaultObject{ObjectName: "workload-ca", ObjectType: "podCertificate"}
+	expectedErr := "service account name of the pod is required for the default uri, set uris or enable podInfoOnMount in the CSIDriver"
+	if _, _, err := p.getPodCertificateContent(context.TODO(), nil, "", object); err == nil || err.Error() != expectedErr {
+		t.Fatalf("expected err: %s, got: %v", expectedErr, err)
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/base64"
-	"encoding/hex"
 	"encoding/pem"
 	"flag"
 	"fmt"
@@ -15,8 +14,10 @@ import (
 	"path/filepath"
 	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/auth"
 	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/version"
@@ -26,15 +27,27 @@ import (
 	"github.com/Azure/go-autorest/autorest/adal"
 	"github.com/Azure/go-autorest/autorest/azure"
 	"github.com/pkg/errors"
-	"golang.org/x/crypto/pkcs12"
 	"golang.org/x/net/context"
 	"gopkg.in/yaml.v2"
 	"k8s.io/klog/v2"
+	"software.sslmate.com/src/go-pkcs12"
 )
 
 var (
 	ConstructPEMChain  = flag.Bool("construct-pem-chain", false, "explicitly reconstruct the pem chain in the order: SERVER, INTERMEDIATE, ROOT")
 	DriverWriteSecrets = flag.Bool("driver-write-secrets", true, "Return secrets in gRPC response to the driver (supported in driver v0.0.21+) instead of writing to filesystem")
+	FetchAIAIssuers    = flag.Bool("fetch-aia-issuers", false, "complete the pem chain with the intermediates downloaded from the Authority Information Access CA Issuers URLs")
+	AIAFetchTimeout    = flag.Duration("aia-fetch-timeout", 5*time.Second, "timeout for downloading an intermediate from the Authority Information Access CA Issuers URL")
+	AIAMaxIssuerSize   = flag.Int64("aia-max-issuer-size", 64*1024, "maximum size in bytes of an intermediate downloaded from the Authority Information Access CA Issuers URL")
+
+	RevocationCheckTimeout    = flag.Duration("revocation-check-timeout", 5*time.Second, "timeout for requests to the OCSP responders and CRL distribution points")
+	RevocationMaxResponseSize = flag.Int64("revocation-max-response-size", 10*1024*1024, "maximum size in bytes of the OCSP responses and CRLs")
+
+	ACRExchangeTimeout = flag.Duration("acr-exchange-timeout", 10*time.Second, "timeout for the token exchange requests to the container registries")
+
+	GenerateListDelay = flag.Duration("generate-list-delay", 2*time.Second, "delay before the versions of a generated secret are listed again to confirm the winning version, as the versions created concurrently may not be listed right away")
+
+	ObjectEncodingMaxSize = flag.Int64("object-encoding-max-size", 10*1024*1024, "maximum size in bytes of the content produced by each stage of the objectEncoding")
 )
 
 // Type of Azure Key Vault objects
@@ -45,12 +58,42 @@ const (
 	VaultObjectTypeKey string = "key"
 	// VaultObjectTypeCertificate certificate vault object type
 	VaultObjectTypeCertificate string = "cert"
+	// VaultObjectTypeBundle bundle of the certificates of other objects
+	VaultObjectTypeBundle string = "bundle"
+	// VaultObjectTypeTemplate template rendered with the content of other objects
+	VaultObjectTypeTemplate string = "template"
+	// VaultObjectTypeDockerConfigJSON docker config JSON built from the registry credentials in other objects
+	VaultObjectTypeDockerConfigJSON string = "dockerconfigjson"
+	// VaultObjectTypeEnv dotenv file or flat JSON map of other objects
+	VaultObjectTypeEnv string = "env"
+	// VaultObjectTypeSops SOPS document with the data key wrapped by a Key Vault key
+	VaultObjectTypeSops string = "sops"
+	// VaultObjectTypeJWT token signed with a Key Vault key
+	VaultObjectTypeJWT string = "jwt"
+	// VaultObjectTypePodCertificate certificate of the pod signed with a Key Vault CA key
+	VaultObjectTypePodCertificate string = "podCertificate"
+	// VaultObjectTypeSSHCertificate OpenSSH certificate signed with a Key Vault CA key
+	VaultObjectTypeSSHCertificate string = "sshCertificate"
+	// VaultObjectTypeToken AAD access token acquired with the identity of the mount
+	VaultObjectTypeToken string = "token"
+	// VaultObjectTypeACRToken registry refresh token exchanged for the AAD token of the mount
+	VaultObjectTypeACRToken string = "acrToken"
+	// VaultObjectTypeSAS SAS token minted from a SAS definition of a Key Vault managed storage account
+	VaultObjectTypeSAS string = "sas"
 
 	certTypePem          = "application/x-pem-file"
 	certTypePfx          = "application/x-pkcs12"
 	certificateType      = "CERTIFICATE"
 	objectFormatPEM      = "pem"
 	objectFormatPFX      = "pfx"
+	objectFormatJKS      = "jks"
+	objectFormatP7B      = "p7b"
+	objectFormatDER      = "der"
+	objectFormatJWK      = "jwk"
+	objectFormatJWKS     = "jwks"
+	objectFormatSSH      = "ssh"
+	objectFormatDotenv   = "dotenv"
+	objectFormatJSON     = "json"
 	objectEncodingHex    = "hex"
 	objectEncodingBase64 = "base64"
 	objectEncodingUtf8   = "utf-8"
@@ -77,6 +120,11 @@ type Provider struct {
 	PodName string
 	// PodNamespace is the pod namespace
 	PodNamespace string
+	// ServiceAccountName is the name of the service account of the pod
+	ServiceAccountName string
+	// TargetPath is the path the objects are mounted to, the pod certificates
+	// previously written there are reused until they're due for renewal
+	TargetPath string
 	// EnvironmentFilepathName captures the name of the environment variable containing the path to the file
 	// to be used while populating the Azure Environment.
 	EnvironmentFilepathName string
@@ -93,11 +141,126 @@ type KeyVaultObject struct {
 	// the type of the Azure Key Vault objects
 	ObjectType string `json:"objectType" yaml:"objectType"`
 	// the format of the Azure Key Vault objects
-	// supported formats are PEM, PFX
+	// supported formats are PEM, PFX, JKS, P7B, DER, JWK, JWKS, SSH, DOTENV, JSON
 	ObjectFormat string `json:"objectFormat" yaml:"objectFormat"`
 	// The encoding of the object in KeyVault
 	// Supported encodings are Base64, Hex, Utf-8
 	ObjectEncoding string `json:"objectEncoding" yaml:"objectEncoding"`
+	// the policy to verify the certificate chain before mounting
+	// supported policies are fail, warn
+	VerifyChain string `json:"verifyChain" yaml:"verifyChain"`
+	// the name of the Azure Key Vault secret containing the PEM encoded trust roots
+	// to verify the certificate chain against
+	TrustRootsSecretName string `json:"trustRootsSecretName" yaml:"trustRootsSecretName"`
+	// the PEM encoded trust roots to verify the certificate chain against
+	TrustRootsPEM string `json:"trustRootsPEM" yaml:"trustRootsPEM"`
+	// comma separated list of extended key usages the certificate must be valid for
+	RequiredEKUs string `json:"requiredEKUs" yaml:"requiredEKUs"`
+	// comma separated list of DNS names the certificate must be valid for
+	RequiredDNSNames string `json:"requiredDNSNames" yaml:"requiredDNSNames"`
+	// the action to take when the certificate is revoked
+	// supported actions are fail, log, metric
+	RevocationCheck string `json:"revocationCheck" yaml:"revocationCheck"`
+	// the name of the Azure Key Vault secret containing the password of the PKCS#12 secret
+	PfxPasswordSecretName string `json:"pfxPasswordSecretName" yaml:"pfxPasswordSecretName"`
+	// the name of the Azure Key Vault secret containing the password to protect the pfx
+	// written for objectFormat pfx with
+	PfxOutputPasswordSecretName string `json:"pfxOutputPasswordSecretName" yaml:"pfxOutputPasswordSecretName"`
+	// the filename the password of the pfx written for objectFormat pfx will be written to
+	PfxPasswordAlias string `json:"pfxPasswordAlias" yaml:"pfxPasswordAlias"`
+	// the name of the Azure Key Vault secret containing the store password for objectFormat jks
+	JksPasswordSecretName string `json:"jksPasswordSecretName" yaml:"jksPasswordSecretName"`
+	// the encoding of the private keys in the PEM content
+	// supported encodings are pkcs8, pkcs1, sec1
+	KeyEncoding string `json:"keyEncoding" yaml:"keyEncoding"`
+	// the name of the Azure Key Vault secret containing the passphrase to encrypt the PKCS#8 private keys with
+	KeyPassphraseSecretName string `json:"keyPassphraseSecretName" yaml:"keyPassphraseSecretName"`
+	// the number of most recent enabled key versions included with objectFormat jwks
+	ObjectVersionHistory string `json:"objectVersionHistory" yaml:"objectVersionHistory"`
+	// comma separated list of the objects in the objects array the object is built from.
+	// The objects are referenced by objectAlias, or objectName if no alias is set.
+	ObjectRefs string `json:"objectRefs" yaml:"objectRefs"`
+	// drop the expired certificates from the bundle
+	ExcludeExpired string `json:"excludeExpired" yaml:"excludeExpired"`
+	// the Go text/template rendered for objectType template
+	Template string `json:"template" yaml:"template"`
+	// the name of the Azure Key Vault secret containing the template for objectType template
+	TemplateSecretName string `json:"templateSecretName" yaml:"templateSecretName"`
+	// the format to parse the secret with to write its fields to separate files
+	// supported formats are json, yaml
+	Explode string `json:"explode" yaml:"explode"`
+	// the fields written to separate files, all the top level fields are written if not set
+	ExplodeFields []ExplodeField `json:"explodeFields" yaml:"explodeFields"`
+	// the registry server for objectType dockerconfigjson and acrToken, for example myregistry.azurecr.io
+	Registry string `json:"registry" yaml:"registry"`
+	// the object in the objects array containing the registry server for objectType dockerconfigjson
+	RegistryRef string `json:"registryRef" yaml:"registryRef"`
+	// the object in the objects array containing the username for objectType dockerconfigjson
+	UsernameRef string `json:"usernameRef" yaml:"usernameRef"`
+	// the object in the objects array containing the password for objectType dockerconfigjson
+	PasswordRef string `json:"passwordRef" yaml:"passwordRef"`
+	// the name of the Azure Key Vault key the content encryption key of the JWE or wrapped
+	// key envelope in the secret is unwrapped with
+	DecryptWithKey string `json:"decryptWithKey" yaml:"decryptWithKey"`
+	// the SOPS document decrypted for objectType sops, the document is read from the
+	// secret objectName if not set
+	SopsDocument string `json:"sopsDocument" yaml:"sopsDocument"`
+	// the algorithm of the detached signature the content is verified with before mounting
+	// supported algorithms are RS256, ES256, PS256
+	VerifySignature string `json:"verifySignature" yaml:"verifySignature"`
+	// the name of the Azure Key Vault secret containing the base64 encoded signature
+	SignatureSecretName string `json:"signatureSecretName" yaml:"signatureSecretName"`
+	// the tag of the secret version containing the base64 encoded signature
+	SignatureTag string `json:"signatureTag" yaml:"signatureTag"`
+	// the name of the Azure Key Vault key the signature is verified with
+	SignatureKeyName string `json:"signatureKeyName" yaml:"signatureKeyName"`
+	// the PEM encoded public key the signature is verified with
+	SignaturePublicKeyPEM string `json:"signaturePublicKeyPEM" yaml:"signaturePublicKeyPEM"`
+	// comma separated list of the hex encoded SHA-1 thumbprints or SHA-256 fingerprints
+	// the certificate must match
+	CertificateThumbprints string `json:"certificateThumbprints" yaml:"certificateThumbprints"`
+	// the iss claim of the token for objectType jwt
+	Issuer string `json:"issuer" yaml:"issuer"`
+	// the sub claim of the token for objectType jwt
+	Subject string `json:"subject" yaml:"subject"`
+	// comma separated list of the audiences of the token for objectType jwt
+	Audience string `json:"audience" yaml:"audience"`
+	// the lifetime of the token for objectType jwt, for example 10m
+	Lifetime string `json:"lifetime" yaml:"lifetime"`
+	// the JSON object of the custom claims of the token for objectType jwt
+	Claims string `json:"claims" yaml:"claims"`
+	// the algorithm the token or certificate is signed with, the default algorithm of the key type is used if not set
+	SigningAlgorithm string `json:"signingAlgorithm" yaml:"signingAlgorithm"`
+	// comma separated list of the DNS names of the certificate for objectType podCertificate
+	DNSNames string `json:"dnsNames" yaml:"dnsNames"`
+	// comma separated list of the URIs of the certificate for objectType podCertificate
+	URIs string `json:"uris" yaml:"uris"`
+	// the type of the private key generated for objectType podCertificate and sshCertificate
+	// supported types are ecdsa, rsa, ed25519
+	KeyType string `json:"keyType" yaml:"keyType"`
+	// the fraction of the lifetime of the certificate after which it's renewed, for example 0.5
+	RenewalFraction string `json:"renewalFraction" yaml:"renewalFraction"`
+	// the filename the CA certificate of objectType podCertificate will be written to
+	CACertificateAlias string `json:"caCertificateAlias" yaml:"caCertificateAlias"`
+	// comma separated list of the principals of the certificate for objectType sshCertificate
+	Principals string `json:"principals" yaml:"principals"`
+	// the type of the certificate for objectType sshCertificate
+	// supported types are user, host
+	SSHCertificateType string `json:"sshCertificateType" yaml:"sshCertificateType"`
+	// comma separated list of the extensions of the user certificates for objectType sshCertificate
+	Extensions string `json:"extensions" yaml:"extensions"`
+	// the resource the access token of objectType token is requested for, for example https://database.windows.net/
+	Resource string `json:"resource" yaml:"resource"`
+	// the scope the access token of objectType token is requested for, the resource followed by /.default
+	Scope string `json:"scope" yaml:"scope"`
+	// the Key Vault managed storage account of the SAS definition of objectType sas
+	StorageAccount string `json:"storageAccount" yaml:"storageAccount"`
+	// the container the URL of objectType sas is composed for
+	Container string `json:"container" yaml:"container"`
+	// the blob in the container the URL of objectType sas is composed for
+	Blob string `json:"blob" yaml:"blob"`
+	// the policy the secret is generated with if it doesn't exist in Key Vault
+	Generate GeneratePolicy `json:"generate" yaml:"generate"`
 }
 
 // StringArray ...
@@ -186,6 +349,8 @@ func (p *Provider) MountSecretsStoreObjectContent(ctx context.Context, attrib ma
 	cloudEnvFileName := strings.TrimSpace(attrib["cloudEnvFileName"])
 	p.PodName = strings.TrimSpace(attrib["csi.storage.k8s.io/pod.name"])
 	p.PodNamespace = strings.TrimSpace(attrib["csi.storage.k8s.io/pod.namespace"])
+	p.ServiceAccountName = strings.TrimSpace(attrib["csi.storage.k8s.io/serviceAccount.name"])
+	p.TargetPath = targetPath
 
 	if keyvaultName == "" {
 		return nil, nil, fmt.Errorf("keyvaultName is not set")
@@ -258,6 +423,9 @@ func (p *Provider) MountSecretsStoreObjectContent(ctx context.Context, attrib ma
 
 	objectVersionMap := make(map[string]string)
 	files := make(map[string][]byte)
+	// the objects fetched from Key Vault by file name, used to build the composed objects
+	fetchedObjects := make(map[string]fetchedObject)
+	var composedObjects []KeyVaultObject
 	for _, keyVaultObject := range keyVaultObjects {
 		klog.InfoS("fetching object from key vault", "objectName", keyVaultObject.ObjectName, "objectType", keyVaultObject.ObjectType, "keyvault", p.KeyvaultName, "pod", klog.ObjectRef{Namespace: p.PodNamespace, Name: p.PodName})
 		if err := validateObjectFormat(keyVaultObject.ObjectFormat, keyVaultObject.ObjectType); err != nil {
@@ -266,117 +434,436 @@ func (p *Provider) MountSecretsStoreObjectContent(ctx context.Context, attrib ma
 		if err := validateObjectEncoding(keyVaultObject.ObjectEncoding, keyVaultObject.ObjectType); err != nil {
 			return nil, nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
 		}
-		fileName := keyVaultObject.ObjectName
-		if keyVaultObject.ObjectAlias != "" {
-			fileName = keyVaultObject.ObjectAlias
+		if err := validateVerifyChain(keyVaultObject); err != nil {
+			return nil, nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
+		}
+		if err := validateRevocationCheck(keyVaultObject.RevocationCheck, keyVaultObject.ObjectType); err != nil {
+			return nil, nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
+		}
+		if err := validatePfxPassword(keyVaultObject); err != nil {
+			return nil, nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
+		}
+		if err := validateCertificateFormat(keyVaultObject); err != nil {
+			return nil, nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
+		}
+		if err := validateKeyEncoding(keyVaultObject); err != nil {
+			return nil, nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
+		}
+		if err := validateObjectVersionHistory(keyVaultObject); err != nil {
+			return nil, nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
+		}
+		if err := validateBundle(keyVaultObject, keyVaultObjects); err != nil {
+			return nil, nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
+		}
+		if err := validateTemplate(keyVaultObject, keyVaultObjects); err != nil {
+			return nil, nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
+		}
+		if err := validateExplode(keyVaultObject); err != nil {
+			return nil, nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
 		}
+		if err := validateDockerConfigJSON(keyVaultObject, keyVaultObjects); err != nil {
+			return nil, nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
+		}
+		if err := validateEnv(keyVaultObject, keyVaultObjects); err != nil {
+			return nil, nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
+		}
+		if err := validateDecryptWithKey(keyVaultObject); err != nil {
+			return nil, nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
+		}
+		if err := validateSops(keyVaultObject); err != nil {
+			return nil, nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
+		}
+		if err := validateVerifySignature(keyVaultObject); err != nil {
+			return nil, nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
+		}
+		if err := validateCertificateThumbprints(keyVaultObject); err != nil {
+			return nil, nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
+		}
+		if err := validateJWT(keyVaultObject); err != nil {
+			return nil, nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
+		}
+		if err := validatePodCertificate(keyVaultObject); err != nil {
+			return nil, nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
+		}
+		if err := validateSSHCertificate(keyVaultObject); err != nil {
+			return nil, nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
+		}
+		if err := validateGenerate(keyVaultObject); err != nil {
+			return nil, nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
+		}
+		if err := validateToken(keyVaultObject); err != nil {
+			return nil, nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
+		}
+		if err := p.validateACRToken(keyVaultObject); err != nil {
+			return nil, nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
+		}
+		if err := validateSAS(keyVaultObject); err != nil {
+			return nil, nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
+		}
+		fileName := getObjectFileName(keyVaultObject)
 		if err := validateFileName(fileName); err != nil {
 			return nil, nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
 		}
+		// bundles, templates and the other composed objects are built once all the objects they reference are fetched
+		if isComposedObjectType(keyVaultObject.ObjectType) {
+			composedObjects = append(composedObjects, keyVaultObject)
+			continue
+		}
+
+		// the missing secrets with a generate policy are created before they're read,
+		// the version picked by the racing pods is read instead of the latest version
+		if keyVaultObject.Generate != (GeneratePolicy{}) {
+			generatedVersion, err := p.generateSecret(ctx, keyVaultObject)
+			if err != nil {
+				return nil, nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
+			}
+			if len(generatedVersion) > 0 {
+				keyVaultObject.ObjectVersion = generatedVersion
+			}
+		}
 
 		// fetch the object from Key Vault
-		content, newObjectVersion, err := p.GetKeyVaultObjectContent(ctx, keyVaultObject)
+		content, contentType, newObjectVersion, err := p.getKeyVaultObjectContent(ctx, keyVaultObject)
 		if err != nil {
 			return nil, nil, err
 		}
+		// the envelope encrypted content is decrypted before it's verified and written,
+		// the version of the key is reported in addition to the version of the secret
+		if len(keyVaultObject.DecryptWithKey) > 0 {
+			var keyVersion string
+			if content, keyVersion, err = p.decryptWithKey(ctx, keyVaultObject, content); err != nil {
+				return nil, nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
+			}
+			objectVersionMap[getObjectUID(keyVaultObject.DecryptWithKey, VaultObjectTypeKey)] = keyVersion
+		}
+		// the signature is verified over the decrypted content, the mount fails on mismatch
+		signatureVersions, err := p.verifyContentSignature(ctx, keyVaultObject, content, newObjectVersion)
+		if err != nil {
+			return nil, nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
+		}
+		for objectUID, signatureVersion := range signatureVersions {
+			objectVersionMap[objectUID] = signatureVersion
+		}
+		// the secret is signed as it's stored in Key Vault, so it's converted with its
+		// content type once the signature is verified
+		content = p.getContentTypeContent(keyVaultObject, contentType, content)
+		// the certificate checks share the PEM content, so the pfx content is decoded once
+		if hasCertificateChecks(keyVaultObject) {
+			certificatePEM, err := p.getCertificatePEM(ctx, keyVaultObject, content)
+			if err != nil {
+				return nil, nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
+			}
+			if err := p.verifyCertificateThumbprint(keyVaultObject, certificatePEM); err != nil {
+				return nil, nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
+			}
+			// verify the certificate chain before the content is mounted
+			if err := p.verifyCertificateChain(ctx, keyVaultObject, certificatePEM); err != nil {
+				return nil, nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
+			}
+			if err := p.checkRevocation(ctx, keyVaultObject, certificatePEM); err != nil {
+				return nil, nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
+			}
+		}
 
 		// objectUID is a unique identifier in the format <object type>/<object name>
 		// This is the object id the user sees in the SecretProviderClassPodStatus
 		objectUID := getObjectUID(keyVaultObject.ObjectName, keyVaultObject.ObjectType)
 		objectVersionMap[objectUID] = newObjectVersion
 
-		objectContent, err := getContentBytes(content, keyVaultObject.ObjectType, keyVaultObject.ObjectEncoding)
+		// the private keys are re-encoded after the content is verified
+		content, err = p.getKeyEncodingContent(ctx, keyVaultObject, content)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
 		}
-		// if the feature to return secrets to CSI driver isn't enabled, the provider will continue to write
-		// the contents to the filesystem.
-		if !*DriverWriteSecrets {
-			if err := os.WriteFile(filepath.Join(targetPath, fileName), objectContent, permission); err != nil {
-				return nil, nil, errors.Wrapf(err, "failed to write file %s at %s", fileName, targetPath)
+
+		var objectContent []byte
+		if isCertificateFormat(keyVaultObject.ObjectFormat) || isPfxTrustStore(keyVaultObject) {
+			// the verified PEM content is converted to the requested binary format
+			objectContent, err = p.getCertificateFormatContent(ctx, keyVaultObject, content)
+			if err != nil {
+				return nil, nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
 			}
-			klog.InfoS("successfully wrote file", "file", fileName, "pod", klog.ObjectRef{Namespace: p.PodNamespace, Name: p.PodName})
 		} else {
-			// these files will be returned to the CSI driver as part of gRPC response
-			files[fileName] = objectContent
-			klog.InfoS("added file to the gRPC response", "file", fileName, "pod", klog.ObjectRef{Namespace: p.PodNamespace, Name: p.PodName})
+			objectContent, err = getContentBytes(content, keyVaultObject.ObjectEncoding)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		if len(keyVaultObject.Explode) > 0 {
+			// the fields of the secret are written to separate files instead of the secret
+			fieldFiles, err := explodeContent(objectContent, keyVaultObject.Explode, keyVaultObject.ExplodeFields)
+			if err != nil {
+				return nil, nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
+			}
+			for fieldFileName, fieldContent := range fieldFiles {
+				if err := p.writeFile(files, targetPath, fieldFileName, fieldContent, permission); err != nil {
+					return nil, nil, err
+				}
+			}
+		} else if keyVaultObject.ObjectType == VaultObjectTypeSSHCertificate {
+			// the private key, certificate and CA public key are written to sibling files
+			sshFiles, err := getSSHCertificateFiles(fileName, objectContent)
+			if err != nil {
+				return nil, nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
+			}
+			for sshFileName, sshContent := range sshFiles {
+				if err := p.writeFile(files, targetPath, sshFileName, sshContent, permission); err != nil {
+					return nil, nil, err
+				}
+			}
+		} else if keyVaultObject.ObjectType == VaultObjectTypeACRToken && strings.EqualFold(keyVaultObject.ObjectFormat, objectFormatPlain) {
+			// the username and password are written to sibling files instead of the docker config JSON
+			acrFiles, err := getACRCredentialFiles(fileName, keyVaultObject.Registry, objectContent)
+			if err != nil {
+				return nil, nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
+			}
+			for acrFileName, acrContent := range acrFiles {
+				if err := p.writeFile(files, targetPath, acrFileName, acrContent, permission); err != nil {
+					return nil, nil, err
+				}
+			}
+		} else if err := p.writeFile(files, targetPath, fileName, objectContent, permission); err != nil {
+			return nil, nil, err
+		}
+		fetchedObjects[fileName] = fetchedObject{content: objectContent, version: newObjectVersion}
+		// the password of the pfx is written to a sibling file if requested
+		if len(keyVaultObject.PfxPasswordAlias) > 0 {
+			password, err := p.getPfxOutputPassword(ctx, keyVaultObject)
+			if err != nil {
+				return nil, nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
+			}
+			if err := p.writeFile(files, targetPath, keyVaultObject.PfxPasswordAlias, []byte(password), permission); err != nil {
+				return nil, nil, err
+			}
+		}
+		// the CA chain of the pod certificate is written to a sibling file if requested
+		if len(keyVaultObject.CACertificateAlias) > 0 {
+			caContent, err := getCACertificatesPEM(content)
+			if err != nil {
+				return nil, nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
+			}
+			if err := p.writeFile(files, targetPath, keyVaultObject.CACertificateAlias, caContent, permission); err != nil {
+				return nil, nil, err
+			}
 		}
 	}
 
+	// the bundles are built first so they can be referenced in the templates
+	sort.SliceStable(composedObjects, func(i, j int) bool {
+		return composedObjects[i].ObjectType == VaultObjectTypeBundle && composedObjects[j].ObjectType != VaultObjectTypeBundle
+	})
+	for _, composedObject := range composedObjects {
+		content, version, err := p.getComposedObjectContent(ctx, composedObject, fetchedObjects)
+		if err != nil {
+			return nil, nil, wrapObjectTypeError(err, composedObject.ObjectType, composedObject.ObjectName, composedObject.ObjectVersion)
+		}
+		objectVersionMap[getObjectUID(composedObject.ObjectName, composedObject.ObjectType)] = version
+		fileName := getObjectFileName(composedObject)
+		if err := p.writeFile(files, targetPath, fileName, content, permission); err != nil {
+			return nil, nil, err
+		}
+		fetchedObjects[fileName] = fetchedObject{content: content, version: version}
+	}
+
 	return files, objectVersionMap, nil
 }
 
+// writeFile writes the file to the target path or adds it to the files returned to the CSI driver
+func (p *Provider) writeFile(files map[string][]byte, targetPath, fileName string, content []byte, permission os.FileMode) error {
+	// if the feature to return secrets to CSI driver isn't enabled, the provider will continue to write
+	// the contents to the filesystem.
+	if !*DriverWriteSecrets {
+		if err := os.WriteFile(filepath.Join(targetPath, fileName), content, permission); err != nil {
+			return errors.Wrapf(err, "failed to write file %s at %s", fileName, targetPath)
+		}
+		klog.InfoS("successfully wrote file", "file", fileName, "pod", klog.ObjectRef{Namespace: p.PodNamespace, Name: p.PodName})
+		return nil
+	}
+	// these files will be returned to the CSI driver as part of gRPC response
+	files[fileName] = content
+	klog.InfoS("added file to the gRPC response", "file", fileName, "pod", klog.ObjectRef{Namespace: p.PodNamespace, Name: p.PodName})
+	return nil
+}
+
+// isComposedObjectType returns true if the objects of the type are built from
+// other objects in the objects array instead of being fetched from Key Vault
+func isComposedObjectType(objectType string) bool {
+	switch objectType {
+	case VaultObjectTypeBundle, VaultObjectTypeTemplate, VaultObjectTypeDockerConfigJSON, VaultObjectTypeEnv:
+		return true
+	default:
+		return false
+	}
+}
+
+// getComposedObjectContent builds the content of the composed object from the
+// fetched objects it references and returns it with the version of the object
+func (p *Provider) getComposedObjectContent(ctx context.Context, kvObject KeyVaultObject, fetchedObjects map[string]fetchedObject) ([]byte, string, error) {
+	switch kvObject.ObjectType {
+	case VaultObjectTypeBundle:
+		return getBundleContent(kvObject, fetchedObjects)
+	case VaultObjectTypeTemplate:
+		return p.getTemplateContent(ctx, kvObject, fetchedObjects)
+	case VaultObjectTypeDockerConfigJSON:
+		return getDockerConfigJSONContent(kvObject, fetchedObjects)
+	case VaultObjectTypeEnv:
+		return getEnvContent(kvObject, fetchedObjects)
+	default:
+		return nil, "", errors.Errorf("objectType %s is not built from other objects", kvObject.ObjectType)
+	}
+}
+
 // GetKeyVaultObjectContent get content of the keyvault object
 func (p *Provider) GetKeyVaultObjectContent(ctx context.Context, kvObject KeyVaultObject) (content, version string, err error) {
+	content, contentType, version, err := p.getKeyVaultObjectContent(ctx, kvObject)
+	if err != nil {
+		return "", "", err
+	}
+	return p.getContentTypeContent(kvObject, contentType, content), version, nil
+}
+
+// getKeyVaultObjectContent gets the content of the keyvault object. The content of secrets that
+// aren't backed by a certificate is returned as stored in Key Vault with its content type, so
+// the signature is verified before the content is converted with getContentTypeContent.
+func (p *Provider) getKeyVaultObjectContent(ctx context.Context, kvObject KeyVaultObject) (content string, contentType *string, version string, err error) {
 	vaultURL, err := p.getVaultURL(ctx)
 	if err != nil {
-		return "", "", errors.Wrap(err, "failed to get vault")
+		return "", nil, "", errors.Wrap(err, "failed to get vault")
 	}
 	kvClient, err := p.initializeKvClient()
 	if err != nil {
-		return "", "", errors.Wrap(err, "failed to get keyvault client")
+		return "", nil, "", errors.Wrap(err, "failed to get keyvault client")
 	}
 
 	switch kvObject.ObjectType {
 	case VaultObjectTypeSecret:
 		secret, err := kvClient.GetSecret(ctx, *vaultURL, kvObject.ObjectName, kvObject.ObjectVersion)
 		if err != nil {
-			return "", "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+			return "", nil, "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
 		}
 		if secret.Value == nil {
-			return "", "", errors.Errorf("secret value is nil")
+			return "", nil, "", errors.Errorf("secret value is nil")
 		}
 		if secret.ID == nil {
-			return "", "", errors.Errorf("secret id is nil")
+			return "", nil, "", errors.Errorf("secret id is nil")
 		}
 		content := *secret.Value
 		version := getObjectVersion(*secret.ID)
+		contentType := secret.ContentType
+		// the content split across the secrets listed in the manifest is reassembled,
+		// the version of the manifest is the version of the object
+		if isChunkManifest(secret.Tags) {
+			if content, err = p.getChunkedContent(ctx, kvClient, *vaultURL, kvObject, content); err != nil {
+				return "", nil, "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+			}
+			// the content type of the manifest doesn't apply to the reassembled content
+			contentType = nil
+		}
 		// if the secret is part of a certificate, then we need to convert the certificate and key to PEM format
 		if secret.Kid != nil && len(*secret.Kid) > 0 {
 			switch *secret.ContentType {
 			case certTypePem:
-				return content, version, nil
+				// object format requested is pfx with a password, then build the pfx from the
+				// key and certificates. Otherwise the pem content is returned as is.
+				if strings.EqualFold(kvObject.ObjectFormat, objectFormatPFX) && len(kvObject.PfxOutputPasswordSecretName) > 0 {
+					content, err := p.getPfxContent(ctx, kvObject, content)
+					if err != nil {
+						return "", nil, "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+					}
+					return content, nil, version, nil
+				}
+				return content, nil, version, nil
 			case certTypePfx:
-				// object format requested is pfx, then return the content as is
+				// object format requested is pfx, then return the content as is unless
+				// it needs to be protected with a password
 				if strings.EqualFold(kvObject.ObjectFormat, objectFormatPFX) {
-					return content, version, err
+					if len(kvObject.PfxOutputPasswordSecretName) == 0 {
+						return content, nil, version, err
+					}
+					pemContent, err := decodePKCS12(content, "")
+					if err != nil {
+						return "", nil, "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+					}
+					content, err := p.getPfxContent(ctx, kvObject, pemContent)
+					if err != nil {
+						return "", nil, "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+					}
+					return content, nil, version, nil
 				}
 				// convert to pem as that's the default object format for this provider
-				content, err := decodePKCS12(*secret.Value)
+				content, err := decodePKCS12(*secret.Value, "")
 				if err != nil {
-					return "", "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+					return "", nil, "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
 				}
-				return content, version, nil
+				return content, nil, version, nil
 			default:
 				err := errors.Errorf("failed to get certificate. unknown content type '%s'", *secret.ContentType)
-				return "", "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+				return "", nil, "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
 			}
 		}
-		return content, version, nil
+		// password protected pfx uploaded as a secret is converted to pem unless
+		// the object format requested is pfx, then it's returned as is unless it
+		// needs to be protected with another password. The pem secrets are protected
+		// with the output password as well.
+		isPfxFormat := strings.EqualFold(kvObject.ObjectFormat, objectFormatPFX)
+		if len(kvObject.PfxPasswordSecretName) > 0 && !isPfxFormat || isPfxFormat && len(kvObject.PfxOutputPasswordSecretName) > 0 {
+			password, err := p.getPfxPassword(ctx, kvObject)
+			if err != nil {
+				return "", nil, "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+			}
+			var outputPassword string
+			if isPfxFormat {
+				if outputPassword, err = p.getPfxOutputPassword(ctx, kvObject); err != nil {
+					return "", nil, "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+				}
+			}
+			content, err := convertPKCS12Secret(content, password, outputPassword, isPfxFormat)
+			if err != nil {
+				return "", nil, "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+			}
+			return content, nil, version, nil
+		}
+		return content, contentType, version, nil
 	case VaultObjectTypeKey:
+		// the jwks can include several versions of the key
+		if strings.EqualFold(kvObject.ObjectFormat, objectFormatJWKS) {
+			content, version, err := p.getJWKSContent(ctx, kvClient, *vaultURL, kvObject)
+			if err != nil {
+				return "", nil, "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+			}
+			return content, nil, version, nil
+		}
 		keybundle, err := kvClient.GetKey(ctx, *vaultURL, kvObject.ObjectName, kvObject.ObjectVersion)
 		if err != nil {
-			return "", "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+			return "", nil, "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
 		}
 		if keybundle.Key == nil {
-			return "", "", errors.Errorf("key value is nil")
+			return "", nil, "", errors.Errorf("key value is nil")
 		}
 		if keybundle.Key.Kid == nil {
-			return "", "", errors.Errorf("key id is nil")
+			return "", nil, "", errors.Errorf("key id is nil")
 		}
 		version := getObjectVersion(*keybundle.Key.Kid)
+		// the json web key is written as is for the jwk and ssh object formats
+		if isKeyFormat(kvObject.ObjectFormat) {
+			content, err := getKeyFormatContent(*keybundle.Key, kvObject)
+			if err != nil {
+				return "", nil, "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+			}
+			return content, nil, version, nil
+		}
 		// for object type "key" the public key is written to the file in PEM format
 		switch keybundle.Key.Kty {
 		case kv.RSA, kv.RSAHSM:
 			// decode the base64 bytes for n
 			nb, err := base64.RawURLEncoding.DecodeString(*keybundle.Key.N)
 			if err != nil {
-				return "", "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+				return "", nil, "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
 			}
 			// decode the base64 bytes for e
 			eb, err := base64.RawURLEncoding.DecodeString(*keybundle.Key.E)
 			if err != nil {
-				return "", "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+				return "", nil, "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
 			}
 			e := new(big.Int).SetBytes(eb).Int64()
 			pKey := &rsa.PublicKey{
@@ -385,7 +872,7 @@ func (p *Provider) GetKeyVaultObjectContent(ctx context.Context, kvObject KeyVau
 			}
 			derBytes, err := x509.MarshalPKIXPublicKey(pKey)
 			if err != nil {
-				return "", "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+				return "", nil, "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
 			}
 			pubKeyBlock := &pem.Block{
 				Type:  "PUBLIC KEY",
@@ -393,21 +880,21 @@ func (p *Provider) GetKeyVaultObjectContent(ctx context.Context, kvObject KeyVau
 			}
 			var pemData []byte
 			pemData = append(pemData, pem.EncodeToMemory(pubKeyBlock)...)
-			return string(pemData), version, nil
+			return string(pemData), nil, version, nil
 		case kv.EC, kv.ECHSM:
 			// decode the base64 bytes for x
 			xb, err := base64.RawURLEncoding.DecodeString(*keybundle.Key.X)
 			if err != nil {
-				return "", "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+				return "", nil, "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
 			}
 			// decode the base64 bytes for y
 			yb, err := base64.RawURLEncoding.DecodeString(*keybundle.Key.Y)
 			if err != nil {
-				return "", "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+				return "", nil, "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
 			}
 			crv, err := getCurve(keybundle.Key.Crv)
 			if err != nil {
-				return "", "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+				return "", nil, "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
 			}
 			pKey := &ecdsa.PublicKey{
 				X:     new(big.Int).SetBytes(xb),
@@ -416,7 +903,7 @@ func (p *Provider) GetKeyVaultObjectContent(ctx context.Context, kvObject KeyVau
 			}
 			derBytes, err := x509.MarshalPKIXPublicKey(pKey)
 			if err != nil {
-				return "", "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+				return "", nil, "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
 			}
 			pubKeyBlock := &pem.Block{
 				Type:  "PUBLIC KEY",
@@ -424,22 +911,22 @@ func (p *Provider) GetKeyVaultObjectContent(ctx context.Context, kvObject KeyVau
 			}
 			var pemData []byte
 			pemData = append(pemData, pem.EncodeToMemory(pubKeyBlock)...)
-			return string(pemData), version, nil
+			return string(pemData), nil, version, nil
 		default:
 			err := errors.Errorf("failed to get key. key type '%s' currently not supported", keybundle.Key.Kty)
-			return "", "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+			return "", nil, "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
 		}
 	case VaultObjectTypeCertificate:
 		// for object type "cert" the certificate is written to the file in PEM format
 		certbundle, err := kvClient.GetCertificate(ctx, *vaultURL, kvObject.ObjectName, kvObject.ObjectVersion)
 		if err != nil {
-			return "", "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+			return "", nil, "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
 		}
 		if certbundle.Cer == nil {
-			return "", "", errors.Errorf("certificate value is nil")
+			return "", nil, "", errors.Errorf("certificate value is nil")
 		}
 		if certbundle.ID == nil {
-			return "", "", errors.Errorf("certificate id is nil")
+			return "", nil, "", errors.Errorf("certificate id is nil")
 		}
 		version := getObjectVersion(*certbundle.ID)
 
@@ -449,11 +936,106 @@ func (p *Provider) GetKeyVaultObjectContent(ctx context.Context, kvObject KeyVau
 		}
 		var pemData []byte
 		pemData = append(pemData, pem.EncodeToMemory(certBlock)...)
-		return string(pemData), version, nil
+		return string(pemData), nil, version, nil
+	case VaultObjectTypeSops:
+		content, version, err := p.getSopsContent(ctx, kvClient, kvObject)
+		if err != nil {
+			return "", nil, "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+		}
+		return content, nil, version, nil
+	case VaultObjectTypeJWT:
+		content, version, err := p.getJWTContent(ctx, kvClient, *vaultURL, kvObject)
+		if err != nil {
+			return "", nil, "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+		}
+		return content, nil, version, nil
+	case VaultObjectTypePodCertificate:
+		content, version, err := p.getPodCertificateContent(ctx, kvClient, *vaultURL, kvObject)
+		if err != nil {
+			return "", nil, "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+		}
+		return content, nil, version, nil
+	case VaultObjectTypeSSHCertificate:
+		content, version, err := p.getSSHCertificateContent(ctx, kvClient, *vaultURL, kvObject)
+		if err != nil {
+			return "", nil, "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+		}
+		return content, nil, version, nil
+	case VaultObjectTypeToken:
+		content, version, err := p.getTokenContent(ctx, kvObject)
+		if err != nil {
+			return "", nil, "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+		}
+		return content, nil, version, nil
+	case VaultObjectTypeACRToken:
+		content, version, err := p.getACRTokenContent(ctx, kvObject)
+		if err != nil {
+			return "", nil, "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+		}
+		return content, nil, version, nil
+	case VaultObjectTypeSAS:
+		content, version, err := p.getSASContent(ctx, kvClient, *vaultURL, kvObject)
+		if err != nil {
+			return "", nil, "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+		}
+		return content, nil, version, nil
 	default:
-		err := errors.Errorf("Invalid vaultObjectTypes. Should be secret, key, or cert")
-		return "", "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+		err := errors.Errorf("Invalid vaultObjectTypes. Should be secret, key, cert, sops, jwt, podCertificate, sshCertificate, token, acrToken or sas")
+		return "", nil, "", wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
+	}
+}
+
+// getSecretValue gets the value and version of the Key Vault secret. This is used to
+// fetch secrets referenced by the objects that aren't mounted themselves.
+func (p *Provider) getSecretValue(ctx context.Context, secretName, secretVersion string) (value, version string, err error) {
+	vaultURL, err := p.getVaultURL(ctx)
+	if err != nil {
+		return "", "", errors.Wrap(err, "failed to get vault")
+	}
+	kvClient, err := p.initializeKvClient()
+	if err != nil {
+		return "", "", errors.Wrap(err, "failed to get keyvault client")
+	}
+	return getSecretValueWithClient(ctx, kvClient, *vaultURL, secretName, secretVersion)
+}
+
+// getSecretValueWithClient returns the value and version of the secret using the given
+// client, so that objects fetching many secrets don't request a token for each of them
+func getSecretValueWithClient(ctx context.Context, kvClient *kv.BaseClient, vaultURL, secretName, secretVersion string) (value, version string, err error) {
+	secret, err := kvClient.GetSecret(ctx, vaultURL, secretName, secretVersion)
+	if err != nil {
+		return "", "", wrapObjectTypeError(err, VaultObjectTypeSecret, secretName, secretVersion)
+	}
+	if secret.Value == nil {
+		return "", "", errors.Errorf("secret value is nil")
+	}
+	if secret.ID == nil {
+		return "", "", errors.Errorf("secret id is nil")
+	}
+	return *secret.Value, getObjectVersion(*secret.ID), nil
+}
+
+// getPfxPassword gets the password of the PKCS#12 content of the object from the
+// referenced Key Vault secret. The password is empty if no secret is referenced.
+func (p *Provider) getPfxPassword(ctx context.Context, kvObject KeyVaultObject) (string, error) {
+	if len(kvObject.PfxPasswordSecretName) == 0 {
+		return "", nil
 	}
+	password, _, err := p.getSecretValue(ctx, kvObject.PfxPasswordSecretName, "")
+	if err != nil {
+		return "", fmt.Errorf("failed to get pfx password from secret %s, error: %w", kvObject.PfxPasswordSecretName, err)
+	}
+	return password, nil
+}
+
+// getPfxOutputPassword gets the password to protect the pfx written for objectFormat pfx with
+// from the referenced Key Vault secret
+func (p *Provider) getPfxOutputPassword(ctx context.Context, kvObject KeyVaultObject) (string, error) {
+	password, _, err := p.getSecretValue(ctx, kvObject.PfxOutputPasswordSecretName, "")
+	if err != nil {
+		return "", fmt.Errorf("failed to get pfx output password from secret %s, error: %w", kvObject.PfxOutputPasswordSecretName, err)
+	}
+	return password, nil
 }
 
 func wrapObjectTypeError(err error, objectType, objectName, objectVersion string) error {
@@ -461,14 +1043,16 @@ func wrapObjectTypeError(err error, objectType, objectName, objectVersion string
 }
 
 // decodePkcs12 decodes PKCS#12 client certificates by extracting the public certificates, the private
-// keys and converts it to PEM format
-func decodePKCS12(value string) (content string, err error) {
+// keys and converts it to PEM format. Password protected and PBES2 (AES) encrypted PKCS#12 with
+// SHA-256 MACs are supported.
+func decodePKCS12(value, password string) (content string, err error) {
 	pfxRaw, err := base64.StdEncoding.DecodeString(value)
 	if err != nil {
 		return "", err
 	}
-	// using ToPEM to extract more than one certificate and key in pfxData
-	pemBlock, err := pkcs12.ToPEM(pfxRaw, "")
+	// using ToPEM to extract more than one certificate and key in pfxData in the order they were uploaded.
+	// The private keys are converted to pkcs8 below as ToPEM uses pkcs1 and sec1.
+	pemBlock, err := pkcs12.ToPEM(pfxRaw, password) //nolint:staticcheck
 	if err != nil {
 		return "", err
 	}
@@ -502,6 +1086,14 @@ func decodePKCS12(value string) (content string, err error) {
 		}
 	}
 
+	// append the intermediates missing in the pfx data from the AIA CA Issuers URLs
+	if *FetchAIAIssuers {
+		pemCertData, err = completeCertChain(pemCertData)
+		if err != nil {
+			return "", err
+		}
+	}
+
 	// construct the pem chain in the order
 	// SERVER, INTERMEDIATE, ROOT
 	if *ConstructPEMChain {
@@ -558,13 +1150,76 @@ func validateObjectFormat(objectFormat, objectType string) error {
 	if len(objectFormat) == 0 {
 		return nil
 	}
-	if !strings.EqualFold(objectFormat, objectFormatPEM) && !strings.EqualFold(objectFormat, objectFormatPFX) {
-		return fmt.Errorf("invalid objectFormat: %v, should be PEM or PFX", objectFormat)
+	// the registry credentials are written as docker config JSON or as the plain username and password
+	if objectType == VaultObjectTypeACRToken {
+		if !strings.EqualFold(objectFormat, objectFormatPlain) {
+			return fmt.Errorf("%s format not supported for objectType: %s", strings.ToUpper(objectFormat), objectType)
+		}
+		return nil
+	}
+	if !strings.EqualFold(objectFormat, objectFormatPEM) && !strings.EqualFold(objectFormat, objectFormatPFX) && !isCertificateFormat(objectFormat) && !isKeyFormat(objectFormat) && !isSopsFormat(objectFormat) {
+		return fmt.Errorf("invalid objectFormat: %v, should be PEM, PFX, JKS, P7B, DER, JWK, JWKS, SSH, DOTENV, JSON or YAML", objectFormat)
 	}
 	// Azure Key Vault returns the base64 encoded binary content only for type secret
-	// for types cert/key, the content is always in pem format
-	if objectFormat == objectFormatPFX && objectType != VaultObjectTypeSecret {
-		return fmt.Errorf("PFX format only supported for objectType: secret")
+	// for types cert/key, the content is always in pem format. The certificates of type
+	// cert are converted to a pkcs12 truststore.
+	if objectFormat == objectFormatPFX && objectType != VaultObjectTypeSecret && objectType != VaultObjectTypeCertificate {
+		return fmt.Errorf("PFX format only supported for objectType: cert, secret")
+	}
+	// the jks, p7b and der formats are converted from the certificates in the content
+	if isCertificateFormat(objectFormat) && objectType != VaultObjectTypeSecret && objectType != VaultObjectTypeCertificate {
+		return fmt.Errorf("%s format only supported for objectType: cert, secret", strings.ToUpper(objectFormat))
+	}
+	// the jwk, jwks and ssh formats are converted from the json web key of the key
+	if isKeyFormat(objectFormat) && objectType != VaultObjectTypeKey {
+		return fmt.Errorf("%s format only supported for objectType: key", strings.ToUpper(objectFormat))
+	}
+	// the dotenv, json and yaml formats are the formats of the env files and SOPS documents
+	switch objectType {
+	case VaultObjectTypeEnv:
+		if !isEnvFormat(objectFormat) {
+			return fmt.Errorf("%s format not supported for objectType: %s", strings.ToUpper(objectFormat), objectType)
+		}
+	case VaultObjectTypeSops:
+		if !isSopsFormat(objectFormat) {
+			return fmt.Errorf("%s format not supported for objectType: %s", strings.ToUpper(objectFormat), objectType)
+		}
+	case VaultObjectTypeToken, VaultObjectTypeSAS:
+		// the access and SAS tokens are written as is or as json with the expiry
+		if !strings.EqualFold(objectFormat, objectFormatJSON) {
+			return fmt.Errorf("%s format not supported for objectType: %s", strings.ToUpper(objectFormat), objectType)
+		}
+	default:
+		if isSopsFormat(objectFormat) {
+			return fmt.Errorf("%s format not supported for objectType: %s", strings.ToUpper(objectFormat), objectType)
+		}
+	}
+	return nil
+}
+
+// validatePfxPassword checks if the pfx password options are supported
+// for the given object
+func validatePfxPassword(kvObject KeyVaultObject) error {
+	// Azure Key Vault returns the PKCS#12 content only for type secret
+	if len(kvObject.PfxPasswordSecretName) > 0 && kvObject.ObjectType != VaultObjectTypeSecret {
+		return fmt.Errorf("pfxPasswordSecretName only supported for objectType: secret")
+	}
+	if len(kvObject.PfxOutputPasswordSecretName) == 0 {
+		if len(kvObject.PfxPasswordAlias) > 0 {
+			return fmt.Errorf("pfxPasswordAlias requires pfxOutputPasswordSecretName to be set")
+		}
+		return nil
+	}
+	if kvObject.ObjectType != VaultObjectTypeSecret && kvObject.ObjectType != VaultObjectTypeCertificate {
+		return fmt.Errorf("pfxOutputPasswordSecretName only supported for objectType: cert, secret")
+	}
+	if !strings.EqualFold(kvObject.ObjectFormat, objectFormatPFX) {
+		return fmt.Errorf("pfxOutputPasswordSecretName only supported with objectFormat: pfx")
+	}
+	if len(kvObject.PfxPasswordAlias) > 0 {
+		if err := validateFileName(kvObject.PfxPasswordAlias); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -585,41 +1240,57 @@ func getObjectUID(objectName, objectType string) string {
 	return fmt.Sprintf("%s/%s", objectType, objectName)
 }
 
-// validateObjectEncoding checks if the object encoding is valid and is supported
-// for the given object type
+// validateObjectEncoding checks if the stages of the object encoding are valid and
+// are supported for the given object type
 func validateObjectEncoding(objectEncoding, objectType string) error {
 	if len(objectEncoding) == 0 {
 		return nil
 	}
 
-	// ObjectEncoding is supported only for secret types
-	if objectType != VaultObjectTypeSecret {
-		return fmt.Errorf("objectEncoding only supported for objectType: secret")
+	stages, err := getEncodingStages(objectEncoding)
+	if err != nil {
+		return err
 	}
-
-	if !strings.EqualFold(objectEncoding, objectEncodingHex) && !strings.EqualFold(objectEncoding, objectEncodingBase64) && !strings.EqualFold(objectEncoding, objectEncodingUtf8) {
-		return fmt.Errorf("invalid objectEncoding: %v, should be hex, base64 or utf-8", objectEncoding)
+	for _, stage := range stages {
+		if objectType == VaultObjectTypeSecret {
+			continue
+		}
+		// the stages decoding the content are supported only for secret types, the stages
+		// only changing the whitespace are also supported for cert and key types
+		if !isTextEncodingStage(stage) {
+			return fmt.Errorf("objectEncoding stage %s only supported for objectType: secret", stage)
+		}
+		if objectType != VaultObjectTypeCertificate && objectType != VaultObjectTypeKey {
+			return fmt.Errorf("objectEncoding stage %s only supported for objectType: secret, cert, key", stage)
+		}
 	}
 
 	return nil
 }
 
 // getContentBytes takes the given content string and returns the bytes to write to disk
-// If an encoding is specified it will decode the string first
-func getContentBytes(content, objectType, objectEncoding string) ([]byte, error) {
-	if !strings.EqualFold(objectType, VaultObjectTypeSecret) || len(objectEncoding) == 0 || strings.EqualFold(objectEncoding, objectEncodingUtf8) {
-		return []byte(content), nil
-	}
-
-	if strings.EqualFold(objectEncoding, objectEncodingBase64) {
-		return base64.StdEncoding.DecodeString(content)
+// If an encoding is specified, each of its stages is applied to the content in order
+func getContentBytes(content, objectEncoding string) ([]byte, error) {
+	stages, err := getEncodingStages(objectEncoding)
+	if err != nil {
+		return nil, err
 	}
-
-	if strings.EqualFold(objectEncoding, objectEncodingHex) {
-		return hex.DecodeString(content)
+	data := []byte(content)
+	for _, stage := range stages {
+		if data, err = decodeStage(data, stage, *ObjectEncodingMaxSize); err != nil {
+			return nil, err
+		}
 	}
+	return data, nil
+}
 
-	return make([]byte, 0), fmt.Errorf("invalid objectEncoding. Should be utf-8, base64, or hex")
+// inlineContentFields are the fields of KeyVaultObject with inline content, their
+// whitespace is part of the content and isn't trimmed
+var inlineContentFields = map[string]bool{
+	"Template":              true,
+	"TrustRootsPEM":         true,
+	"SignaturePublicKeyPEM": true,
+	"Claims":                true,
 }
 
 // formatKeyVaultObject formats the fields in KeyVaultObject
@@ -632,7 +1303,7 @@ func formatKeyVaultObject(object *KeyVaultObject) {
 
 	for i := 0; i < objectValue.NumField(); i++ {
 		field := objectValue.Field(i)
-		if field.Type() != reflect.TypeOf("") {
+		if field.Type() != reflect.TypeOf("") || inlineContentFields[objectValue.Type().Field(i).Name] {
 			continue
 		}
 		str := field.Interface().(string)
@@ -6,6 +6,8 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/pem"
 	"fmt"
 	"io"
@@ -18,6 +20,7 @@ import (
 	"github.com/Azure/go-autorest/autorest/azure"
 	"github.com/google/go-cmp/cmp"
 	"github.com/stretchr/testify/assert"
+	"software.sslmate.com/src/go-pkcs12"
 
 	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/auth"
 	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/version"
@@ -251,7 +254,7 @@ Uz7sJMWoq7mOrINHQ0ZmaiE=
 
 	for _, tc := range cases {
 		t.Run(tc.desc, func(t *testing.T) {
-			content, err := decodePKCS12(tc.value)
+			content, err := decodePKCS12(tc.value, "")
 			if err != nil {
 				t.Fatalf("expected nil err, got: %v", err)
 			}
@@ -262,6 +265,124 @@ Uz7sJMWoq7mOrINHQ0ZmaiE=
 	}
 }
 
+func TestDecodePKCS12WithPassword(t *testing.T) {
+	root := newTestCA(t, "Test Root CA", nil)
+	leaf := newTestLeaf(t, "example.com", root)
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(leaf.key)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	expectedContent := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})) + leaf.pem() + root.pem()
+
+	cases := []struct {
+		desc        string
+		encoder     *pkcs12.Encoder
+		password    string
+		expectedErr bool
+	}{
+		{
+			desc:     "modern PBES2 AES-256 encryption with SHA-256 MAC",
+			encoder:  pkcs12.Modern2023,
+			password: "password",
+		},
+		{
+			desc:     "legacy RC2 encryption",
+			encoder:  pkcs12.LegacyRC2,
+			password: "password",
+		},
+		{
+			desc:        "incorrect password",
+			encoder:     pkcs12.Modern2023,
+			password:    "incorrect",
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			pfxData, err := tc.encoder.Encode(leaf.key, leaf.cert, []*x509.Certificate{root.cert}, "password")
+			if err != nil {
+				t.Fatalf("expected nil err, got: %v", err)
+			}
+			content, err := decodePKCS12(base64.StdEncoding.EncodeToString(pfxData), tc.password)
+			if tc.expectedErr && err == nil || !tc.expectedErr && err != nil {
+				t.Fatalf("expected error: %v, got error: %v", tc.expectedErr, err)
+			}
+			if !tc.expectedErr && content != expectedContent {
+				t.Fatalf(cmp.Diff(expectedContent, content))
+			}
+		})
+	}
+}
+
+func TestValidatePfxPassword(t *testing.T) {
+	cases := []struct {
+		desc        string
+		object      KeyVaultObject
+		expectedErr error
+	}{
+		{
+			desc:        "no pfx password secret specified",
+			object:      KeyVaultObject{ObjectType: "cert"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "pfx password secret, but object type not secret",
+			object:      KeyVaultObject{ObjectType: "cert", PfxPasswordSecretName: "password"},
+			expectedErr: fmt.Errorf("pfxPasswordSecretName only supported for objectType: secret"),
+		},
+		{
+			desc:        "pfx password alias without pfx output password secret",
+			object:      KeyVaultObject{ObjectType: "secret", ObjectFormat: "pfx", PfxPasswordSecretName: "password", PfxPasswordAlias: "password.txt"},
+			expectedErr: fmt.Errorf("pfxPasswordAlias requires pfxOutputPasswordSecretName to be set"),
+		},
+		{
+			desc:        "pfx output password secret, but object type not cert or secret",
+			object:      KeyVaultObject{ObjectType: "key", ObjectFormat: "pfx", PfxOutputPasswordSecretName: "password"},
+			expectedErr: fmt.Errorf("pfxOutputPasswordSecretName only supported for objectType: cert, secret"),
+		},
+		{
+			desc:        "pfx output password secret for object type cert",
+			object:      KeyVaultObject{ObjectType: "cert", ObjectFormat: "pfx", PfxOutputPasswordSecretName: "password"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "pfx output password secret, but object format not pfx",
+			object:      KeyVaultObject{ObjectType: "secret", PfxOutputPasswordSecretName: "password"},
+			expectedErr: fmt.Errorf("pfxOutputPasswordSecretName only supported with objectFormat: pfx"),
+		},
+		{
+			desc:        "pfx password alias is not a valid file name",
+			object:      KeyVaultObject{ObjectType: "secret", ObjectFormat: "pfx", PfxOutputPasswordSecretName: "password", PfxPasswordAlias: "../password.txt"},
+			expectedErr: fmt.Errorf("file name must not contain '..'"),
+		},
+		{
+			desc:        "valid pfx password secret and type",
+			object:      KeyVaultObject{ObjectType: "secret", PfxPasswordSecretName: "password"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "valid pfx password and output password secrets",
+			object:      KeyVaultObject{ObjectType: "secret", ObjectFormat: "pfx", PfxPasswordSecretName: "input-password", PfxOutputPasswordSecretName: "output-password"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "valid pfx output password secret and alias",
+			object:      KeyVaultObject{ObjectType: "secret", ObjectFormat: "PFX", PfxOutputPasswordSecretName: "password", PfxPasswordAlias: "password.txt"},
+			expectedErr: nil,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := validatePfxPassword(tc.object)
+			if tc.expectedErr != nil && (err == nil || err.Error() != tc.expectedErr.Error()) || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
 func TestParseAzureEnvironmentAzureStackCloud(t *testing.T) {
 	azureStackCloudEnvName := "AZURESTACKCLOUD"
 	file, err := os.CreateTemp("", "ut")
@@ -309,13 +430,13 @@ func TestValidateObjectFormat(t *testing.T) {
 			desc:         "object format not valid",
 			objectFormat: "pkcs",
 			objectType:   "secret",
-			expectedErr:  fmt.Errorf("invalid objectFormat: pkcs, should be PEM or PFX"),
+			expectedErr:  fmt.Errorf("invalid objectFormat: pkcs, should be PEM, PFX, JKS, P7B, DER, JWK, JWKS, SSH, DOTENV, JSON or YAML"),
 		},
 		{
-			desc:         "object format PFX, but object type not secret",
+			desc:         "object format PFX, but object type not cert or secret",
 			objectFormat: "pfx",
-			objectType:   "cert",
-			expectedErr:  fmt.Errorf("PFX format only supported for objectType: secret"),
+			objectType:   "key",
+			expectedErr:  fmt.Errorf("PFX format only supported for objectType: cert, secret"),
 		},
 		{
 			desc:         "object format PFX case insensitive check",
@@ -329,6 +450,108 @@ func TestValidateObjectFormat(t *testing.T) {
 			objectType:   "secret",
 			expectedErr:  nil,
 		},
+		{
+			desc:         "object format JKS, but object type key",
+			objectFormat: "jks",
+			objectType:   "key",
+			expectedErr:  fmt.Errorf("JKS format only supported for objectType: cert, secret"),
+		},
+		{
+			desc:         "object format P7B for object type cert",
+			objectFormat: "p7b",
+			objectType:   "cert",
+			expectedErr:  nil,
+		},
+		{
+			desc:         "object format DER case insensitive check",
+			objectFormat: "DER",
+			objectType:   "secret",
+			expectedErr:  nil,
+		},
+		{
+			desc:         "object format JWKS, but object type cert",
+			objectFormat: "jwks",
+			objectType:   "cert",
+			expectedErr:  fmt.Errorf("JWKS format only supported for objectType: key"),
+		},
+		{
+			desc:         "object format SSH for object type key",
+			objectFormat: "SSH",
+			objectType:   "key",
+			expectedErr:  nil,
+		},
+		{
+			desc:         "object format JSON for object type env",
+			objectFormat: "json",
+			objectType:   "env",
+			expectedErr:  nil,
+		},
+		{
+			desc:         "object format DOTENV, but object type secret",
+			objectFormat: "dotenv",
+			objectType:   "secret",
+			expectedErr:  fmt.Errorf("DOTENV format not supported for objectType: secret"),
+		},
+		{
+			desc:         "object format PEM, but object type env",
+			objectFormat: "pem",
+			objectType:   "env",
+			expectedErr:  fmt.Errorf("PEM format not supported for objectType: env"),
+		},
+		{
+			desc:         "object format YAML for object type sops",
+			objectFormat: "YAML",
+			objectType:   "sops",
+			expectedErr:  nil,
+		},
+		{
+			desc:         "object format YAML, but object type env",
+			objectFormat: "yaml",
+			objectType:   "env",
+			expectedErr:  fmt.Errorf("YAML format not supported for objectType: env"),
+		},
+		{
+			desc:         "object format PFX, but object type sops",
+			objectFormat: "pfx",
+			objectType:   "sops",
+			expectedErr:  fmt.Errorf("PFX format only supported for objectType: cert, secret"),
+		},
+		{
+			desc:         "object format JSON for object type token",
+			objectFormat: "json",
+			objectType:   "token",
+			expectedErr:  nil,
+		},
+		{
+			desc:         "object format PEM, but object type token",
+			objectFormat: "pem",
+			objectType:   "token",
+			expectedErr:  fmt.Errorf("PEM format not supported for objectType: token"),
+		},
+		{
+			desc:         "object format JSON for object type sas",
+			objectFormat: "json",
+			objectType:   "sas",
+			expectedErr:  nil,
+		},
+		{
+			desc:         "object format plain for object type acrToken",
+			objectFormat: "plain",
+			objectType:   "acrToken",
+			expectedErr:  nil,
+		},
+		{
+			desc:         "object format JSON, but object type acrToken",
+			objectFormat: "json",
+			objectType:   "acrToken",
+			expectedErr:  fmt.Errorf("JSON format not supported for objectType: acrToken"),
+		},
+		{
+			desc:         "object format plain, but object type secret",
+			objectFormat: "plain",
+			objectType:   "secret",
+			expectedErr:  fmt.Errorf("invalid objectFormat: plain, should be PEM, PFX, JKS, P7B, DER, JWK, JWKS, SSH, DOTENV, JSON or YAML"),
+		},
 	}
 
 	for _, tc := range cases {
@@ -358,13 +581,13 @@ func TestValidateObjectEncoding(t *testing.T) {
 			desc:           "Invalid encoding specified",
 			objectEncoding: "utf-16",
 			objectType:     "secret",
-			expectedErr:    fmt.Errorf("invalid objectEncoding: utf-16, should be hex, base64 or utf-8"),
+			expectedErr:    fmt.Errorf("invalid objectEncoding: utf-16, should be utf-8, hex, base64, base64url, gzip, zstd, trim or ensure-trailing-newline"),
 		},
 		{
 			desc:           "Object Encoding Base64, but objectType is not secret",
 			objectEncoding: "base64",
 			objectType:     "cert",
-			expectedErr:    fmt.Errorf("objectEncoding only supported for objectType: secret"),
+			expectedErr:    fmt.Errorf("objectEncoding stage base64 only supported for objectType: secret"),
 		},
 		{
 			desc:           "Object Encoding case-insensitive check",
@@ -378,6 +601,36 @@ func TestValidateObjectEncoding(t *testing.T) {
 			objectType:     "secret",
 			expectedErr:    nil,
 		},
+		{
+			desc:           "Valid pipeline of stages",
+			objectEncoding: "base64url, gzip, trim",
+			objectType:     "secret",
+			expectedErr:    nil,
+		},
+		{
+			desc:           "Invalid stage in the pipeline",
+			objectEncoding: "base64,bzip2",
+			objectType:     "secret",
+			expectedErr:    fmt.Errorf("invalid objectEncoding: bzip2, should be utf-8, hex, base64, base64url, gzip, zstd, trim or ensure-trailing-newline"),
+		},
+		{
+			desc:           "Text stages for objectType cert",
+			objectEncoding: "trim,ensure-trailing-newline",
+			objectType:     "cert",
+			expectedErr:    nil,
+		},
+		{
+			desc:           "Decoding stage for objectType key",
+			objectEncoding: "trim,gzip",
+			objectType:     "key",
+			expectedErr:    fmt.Errorf("objectEncoding stage gzip only supported for objectType: secret"),
+		},
+		{
+			desc:           "Text stage for objectType template",
+			objectEncoding: "trim",
+			objectType:     "template",
+			expectedErr:    fmt.Errorf("objectEncoding stage trim only supported for objectType: secret, cert, key"),
+		},
 	}
 
 	for _, tc := range cases {
@@ -395,7 +648,6 @@ func TestGetContentBytes(t *testing.T) {
 		desc           string
 		objectContent  string
 		objectEncoding string
-		objectType     string
 		expectedErr    error
 		expectedValue  []byte
 	}{
@@ -403,7 +655,6 @@ func TestGetContentBytes(t *testing.T) {
 			desc:           "No encoding specified for a secret",
 			objectContent:  "abcdefg",
 			objectEncoding: "",
-			objectType:     "secret",
 			expectedErr:    nil,
 			expectedValue:  []byte{97, 98, 99, 100, 101, 102, 103},
 		},
@@ -411,7 +662,6 @@ func TestGetContentBytes(t *testing.T) {
 			desc:           "Certificate object type",
 			objectContent:  "foobar123",
 			objectEncoding: "",
-			objectType:     "cert",
 			expectedErr:    nil,
 			expectedValue:  []byte{102, 111, 111, 98, 97, 114, 49, 50, 51},
 		},
@@ -419,7 +669,6 @@ func TestGetContentBytes(t *testing.T) {
 			desc:           "Key object type",
 			objectContent:  "keyobjecttype",
 			objectEncoding: "",
-			objectType:     "key",
 			expectedErr:    nil,
 			expectedValue:  []byte{107, 101, 121, 111, 98, 106, 101, 99, 116, 116, 121, 112, 101},
 		},
@@ -427,7 +676,6 @@ func TestGetContentBytes(t *testing.T) {
 			desc:           "UTF-8 encoding",
 			objectContent:  "TestSecret1",
 			objectEncoding: "utf-8",
-			objectType:     "secret",
 			expectedErr:    nil,
 			expectedValue:  []byte{84, 101, 115, 116, 83, 101, 99, 114, 101, 116, 49},
 		},
@@ -435,7 +683,6 @@ func TestGetContentBytes(t *testing.T) {
 			desc:           "Base64 encoding",
 			objectContent:  "QmFzZTY0RW5jb2RlZFN0cmluZw==",
 			objectEncoding: "base64",
-			objectType:     "secret",
 			expectedErr:    nil,
 			expectedValue:  []byte{66, 97, 115, 101, 54, 52, 69, 110, 99, 111, 100, 101, 100, 83, 116, 114, 105, 110, 103},
 		},
@@ -443,7 +690,6 @@ func TestGetContentBytes(t *testing.T) {
 			desc:           "Hex encoding",
 			objectContent:  "486578456E636F646564537472696E67",
 			objectEncoding: "hex",
-			objectType:     "secret",
 			expectedErr:    nil,
 			expectedValue:  []byte{72, 101, 120, 69, 110, 99, 111, 100, 101, 100, 83, 116, 114, 105, 110, 103},
 		},
@@ -451,15 +697,14 @@ func TestGetContentBytes(t *testing.T) {
 			desc:           "Invalid encoding",
 			objectContent:  "TestSecret1",
 			objectEncoding: "NotAnEncoding",
-			objectType:     "secret",
-			expectedErr:    fmt.Errorf("invalid objectEncoding. Should be utf-8, base64, or hex"),
+			expectedErr:    fmt.Errorf("invalid objectEncoding: NotAnEncoding, should be utf-8, hex, base64, base64url, gzip, zstd, trim or ensure-trailing-newline"),
 			expectedValue:  []byte{},
 		},
 	}
 
 	for _, tc := range cases {
 		t.Run(tc.desc, func(t *testing.T) {
-			actualValue, err := getContentBytes(tc.objectContent, tc.objectType, tc.objectEncoding)
+			actualValue, err := getContentBytes(tc.objectContent, tc.objectEncoding)
 			if tc.expectedErr != nil && err.Error() != tc.expectedErr.Error() || tc.expectedErr == nil && err != nil {
 				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
 			}
@@ -495,6 +740,21 @@ func TestFormatKeyVaultObject(t *testing.T) {
 				ObjectAlias:    "",
 			},
 		},
+		{
+			desc: "whitespace of inline content kept",
+			keyVaultObject: KeyVaultObject{
+				ObjectName: " config ",
+				ObjectType: "template ",
+				Template:   "host={{ object \"host\" }}\n",
+				Claims:     " {\"role\": \"reader\"}\n",
+			},
+			expectedKeyVaultObject: KeyVaultObject{
+				ObjectName: "config",
+				ObjectType: "template",
+				Template:   "host={{ object \"host\" }}\n",
+				Claims:     " {\"role\": \"reader\"}\n",
+			},
+		},
 		{
 			desc: "no data loss for already sanitized object",
 			keyVaultObject: KeyVaultObject{
@@ -0,0 +1,221 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// dockerConfigJSON is the content of a kubernetes.io/dockerconfigjson secret
+type dockerConfigJSON struct {
+	Auths map[string]dockerConfigEntry `json:"auths"`
+}
+
+// dockerConfigEntry holds the credentials of a registry
+type dockerConfigEntry struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Auth     string `json:"auth"`
+}
+
+// validateDockerConfigJSON checks if the dockerconfigjson options are valid for the given object
+func validateDockerConfigJSON(kvObject KeyVaultObject, objects []KeyVaultObject) error {
+	if kvObject.ObjectType != VaultObjectTypeDockerConfigJSON {
+		if len(kvObject.RegistryRef) > 0 || len(kvObject.UsernameRef) > 0 || len(kvObject.PasswordRef) > 0 {
+			return fmt.Errorf("registryRef, usernameRef and passwordRef only supported for objectType: dockerconfigjson")
+		}
+		if len(kvObject.Registry) > 0 && kvObject.ObjectType != VaultObjectTypeACRToken {
+			return fmt.Errorf("registry only supported for objectType: dockerconfigjson, acrToken")
+		}
+		return nil
+	}
+	if len(kvObject.Registry) == 0 && len(kvObject.RegistryRef) == 0 {
+		return fmt.Errorf("either registry or registryRef should be set")
+	}
+	if len(kvObject.Registry) > 0 && len(kvObject.RegistryRef) > 0 {
+		return fmt.Errorf("only one of registry or registryRef can be set")
+	}
+	if len(kvObject.UsernameRef) == 0 || len(kvObject.PasswordRef) == 0 {
+		return fmt.Errorf("usernameRef and passwordRef should be set")
+	}
+	if len(kvObject.ObjectVersion) > 0 {
+		return fmt.Errorf("objectVersion not supported for objectType: dockerconfigjson")
+	}
+	if len(kvObject.RegistryRef) > 0 {
+		if err := validateRefs("registryRef", []string{kvObject.RegistryRef}, objects, VaultObjectTypeSecret); err != nil {
+			return err
+		}
+	}
+	if err := validateRefs("usernameRef", []string{kvObject.UsernameRef}, objects, VaultObjectTypeSecret); err != nil {
+		return err
+	}
+	return validateRefs("passwordRef", []string{kvObject.PasswordRef}, objects, VaultObjectTypeSecret)
+}
+
+// getDockerConfigJSONContent returns the docker config JSON with the credentials of the
+// registry from the referenced objects. The version is derived from the versions of the
+// referenced objects.
+func getDockerConfigJSONContent(kvObject KeyVaultObject, fetchedObjects map[string]fetchedObject) ([]byte, string, error) {
+	var versions []string
+	getValue := func(field, ref string) (string, error) {
+		fetched, ok := fetchedObjects[ref]
+		if !ok {
+			return "", fmt.Errorf("object %s referenced in %s not found", ref, field)
+		}
+		versions = append(versions, fetched.version)
+		return string(fetched.content), nil
+	}
+	registry := kvObject.Registry
+	if len(kvObject.RegistryRef) > 0 {
+		var err error
+		if registry, err = getValue("registryRef", kvObject.RegistryRef); err != nil {
+			return nil, "", err
+		}
+	}
+	username, err := getValue("usernameRef", kvObject.UsernameRef)
+	if err != nil {
+		return nil, "", err
+	}
+	password, err := getValue("passwordRef", kvObject.PasswordRef)
+	if err != nil {
+		return nil, "", err
+	}
+	content, err := renderDockerConfigJSON(registry, username, password)
+	if err != nil {
+		return nil, "", err
+	}
+	return content, getCompositeVersion(versions), nil
+}
+
+// renderDockerConfigJSON returns the docker config JSON for the registry credentials.
+// Surrounding whitespace is removed from the registry and username, and a trailing
+// newline from the password, as secrets uploaded from files often end with a newline.
+func renderDockerConfigJSON(registry, username, password string) ([]byte, error) {
+	registry, username = strings.TrimSpace(registry), strings.TrimSpace(username)
+	password = strings.TrimSuffix(strings.TrimSuffix(password, "\n"), "\r")
+	if len(registry) == 0 || len(username) == 0 || len(password) == 0 {
+		return nil, fmt.Errorf("registry, username and password can't be empty")
+	}
+	return marshalJSON(dockerConfigJSON{
+		Auths: map[string]dockerConfigEntry{
+			registry: {
+				Username: username,
+				Password: password,
+				Auth:     base64.StdEncoding.EncodeToString([]byte(username + ":" + password)),
+			},
+		},
+	})
+}
+
+// isEnvFormat returns true if the format is one of the formats of objectType env
+func isEnvFormat(objectFormat string) bool {
+	return strings.EqualFold(objectFormat, objectFormatDotenv) || strings.EqualFold(objectFormat, objectFormatJSON)
+}
+
+// validateEnv checks if the objects referenced by the env object are valid and
+// have unique keys
+func validateEnv(kvObject KeyVaultObject, objects []KeyVaultObject) error {
+	if kvObject.ObjectType != VaultObjectTypeEnv {
+		return nil
+	}
+	if len(kvObject.ObjectVersion) > 0 {
+		return fmt.Errorf("objectVersion not supported for objectType: env")
+	}
+	if err := validateObjectRefs(kvObject, objects, VaultObjectTypeSecret); err != nil {
+		return err
+	}
+	keys := make(map[string]string)
+	for _, ref := range splitList(kvObject.ObjectRefs) {
+		key := getEnvKey(ref)
+		if other, ok := keys[key]; ok {
+			return fmt.Errorf("objects %s and %s have the same key %s, set an objectAlias to use a different key", other, ref, key)
+		}
+		keys[key] = ref
+	}
+	return nil
+}
+
+// getEnvContent returns the dotenv file or flat JSON map with the values of the
+// referenced objects. The version is derived from the versions of the referenced objects.
+func getEnvContent(kvObject KeyVaultObject, fetchedObjects map[string]fetchedObject) ([]byte, string, error) {
+	values := make(map[string]string)
+	var versions []string
+	for _, ref := range splitList(kvObject.ObjectRefs) {
+		fetched, ok := fetchedObjects[ref]
+		if !ok {
+			return nil, "", fmt.Errorf("object %s referenced in objectRefs not found", ref)
+		}
+		values[getEnvKey(ref)] = string(fetched.content)
+		versions = append(versions, fetched.version)
+	}
+	var content []byte
+	var err error
+	if strings.EqualFold(kvObject.ObjectFormat, objectFormatJSON) {
+		content, err = marshalJSON(values)
+	} else {
+		content = renderDotenv(values)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return content, getCompositeVersion(versions), nil
+}
+
+// getEnvKey sanitizes the object name or alias to an environment variable name. The
+// name is upper cased and characters other than letters, digits and underscores are
+// replaced with underscores, for example db-password becomes DB_PASSWORD.
+func getEnvKey(name string) string {
+	var b strings.Builder
+	for i, r := range strings.ToUpper(name) {
+		switch {
+		case r >= 'A' && r <= 'Z', r == '_':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteRune('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	return b.String()
+}
+
+// renderDotenv returns the KEY=value lines sorted by key. Values are single quoted so
+// they're read literally, values containing single quotes or line breaks are double
+// quoted with backslashes, double quotes, dollar signs, backticks and line breaks escaped
+// so they aren't expanded.
+func renderDotenv(values map[string]string) []byte {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var buf bytes.Buffer
+	for _, key := range keys {
+		value := values[key]
+		if !strings.ContainsAny(value, "'\n\r") {
+			fmt.Fprintf(&buf, "%s='%s'\n", key, value)
+			continue
+		}
+		escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`", "\n", `\n`, "\r", `\r`).Replace(value)
+		fmt.Fprintf(&buf, "%s=\"%s\"\n", key, escaped)
+	}
+	return buf.Bytes()
+}
+
+// marshalJSON returns the indented JSON without escaping HTML characters
+func marshalJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,244 @@
+package provider
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestValidateDockerConfigJSON(t *testing.T) {
+	objects := []KeyVaultObject{
+		{ObjectName: "acr-server", ObjectType: "secret"},
+		{ObjectName: "acr-username", ObjectType: "secret"},
+		{ObjectName: "acr-password", ObjectType: "secret"},
+		{ObjectName: "acr-cert", ObjectType: "cert"},
+	}
+
+	cases := []struct {
+		desc        string
+		object      KeyVaultObject
+		expectedErr error
+	}{
+		{
+			desc:        "not a dockerconfigjson",
+			object:      KeyVaultObject{ObjectName: "acr-username", ObjectType: "secret"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "usernameRef without objectType dockerconfigjson",
+			object:      KeyVaultObject{ObjectName: "acr-username", ObjectType: "secret", UsernameRef: "acr-username"},
+			expectedErr: fmt.Errorf("registryRef, usernameRef and passwordRef only supported for objectType: dockerconfigjson"),
+		},
+		{
+			desc:        "registry without objectType dockerconfigjson",
+			object:      KeyVaultObject{ObjectName: "acr-username", ObjectType: "secret", Registry: "myregistry.azurecr.io"},
+			expectedErr: fmt.Errorf("registry only supported for objectType: dockerconfigjson, acrToken"),
+		},
+		{
+			desc:        "registry for objectType acrToken",
+			object:      KeyVaultObject{ObjectName: "pull-secret", ObjectType: "acrToken", Registry: "myregistry.azurecr.io"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "registry not set",
+			object:      KeyVaultObject{ObjectName: "pull-secret", ObjectType: "dockerconfigjson", UsernameRef: "acr-username", PasswordRef: "acr-password"},
+			expectedErr: fmt.Errorf("either registry or registryRef should be set"),
+		},
+		{
+			desc:        "registry and registryRef set",
+			object:      KeyVaultObject{ObjectName: "pull-secret", ObjectType: "dockerconfigjson", Registry: "myacr.azurecr.io", RegistryRef: "acr-server", UsernameRef: "acr-username", PasswordRef: "acr-password"},
+			expectedErr: fmt.Errorf("only one of registry or registryRef can be set"),
+		},
+		{
+			desc:        "passwordRef not set",
+			object:      KeyVaultObject{ObjectName: "pull-secret", ObjectType: "dockerconfigjson", Registry: "myacr.azurecr.io", UsernameRef: "acr-username"},
+			expectedErr: fmt.Errorf("usernameRef and passwordRef should be set"),
+		},
+		{
+			desc:        "password referencing a cert",
+			object:      KeyVaultObject{ObjectName: "pull-secret", ObjectType: "dockerconfigjson", Registry: "myacr.azurecr.io", UsernameRef: "acr-username", PasswordRef: "acr-cert"},
+			expectedErr: fmt.Errorf("object acr-cert referenced in passwordRef has unsupported objectType: cert"),
+		},
+		{
+			desc:        "valid registry",
+			object:      KeyVaultObject{ObjectName: "pull-secret", ObjectType: "dockerconfigjson", Registry: "myacr.azurecr.io", UsernameRef: "acr-username", PasswordRef: "acr-password"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "valid registryRef",
+			object:      KeyVaultObject{ObjectName: "pull-secret", ObjectType: "dockerconfigjson", RegistryRef: "acr-server", UsernameRef: "acr-username", PasswordRef: "acr-password"},
+			expectedErr: nil,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := validateDockerConfigJSON(tc.object, objects)
+			if tc.expectedErr != nil && (err == nil || err.Error() != tc.expectedErr.Error()) || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestGetDockerConfigJSONContent(t *testing.T) {
+	object := KeyVaultObject{ObjectName: "pull-secret", ObjectType: "dockerconfigjson", RegistryRef: "acr-server", UsernameRef: "acr-username", PasswordRef: "acr-password"}
+	fetchedObjects := map[string]fetchedObject{
+		"acr-server":   {content: []byte("myacr.azurecr.io\n"), version: "v1"},
+		"acr-username": {content: []byte(" puller\n"), version: "v1"},
+		"acr-password": {content: []byte("p\"a:ss<word>\n"), version: "v1"},
+	}
+
+	content, version, err := getDockerConfigJSONContent(object, fetchedObjects)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	var config dockerConfigJSON
+	if err := json.Unmarshal(content, &config); err != nil {
+		t.Fatalf("expected valid json, got: %v", err)
+	}
+	entry, ok := config.Auths["myacr.azurecr.io"]
+	if !ok || len(config.Auths) != 1 {
+		t.Fatalf("expected auths for myacr.azurecr.io, got: %s", content)
+	}
+	if entry.Username != "puller" || entry.Password != "p\"a:ss<word>" {
+		t.Fatalf("expected username puller and the password without the trailing newline, got: %s", content)
+	}
+	if expectedAuth := base64.StdEncoding.EncodeToString([]byte("puller:p\"a:ss<word>")); entry.Auth != expectedAuth {
+		t.Fatalf("expected auth: %s, got: %s", expectedAuth, entry.Auth)
+	}
+
+	// rotating the password changes the version
+	fetchedObjects["acr-password"] = fetchedObject{content: []byte("rotated"), version: "v2"}
+	_, rotatedVersion, err := getDockerConfigJSONContent(object, fetchedObjects)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	if version == rotatedVersion {
+		t.Fatalf("expected version to change after rotation, got: %s", version)
+	}
+
+	fetchedObjects["acr-username"] = fetchedObject{content: []byte("\n"), version: "v2"}
+	if _, _, err := getDockerConfigJSONContent(object, fetchedObjects); err == nil || err.Error() != "registry, username and password can't be empty" {
+		t.Fatalf("expected error for the empty username, got: %v", err)
+	}
+}
+
+func TestValidateEnv(t *testing.T) {
+	objects := []KeyVaultObject{
+		{ObjectName: "db-password", ObjectType: "secret"},
+		{ObjectName: "db_password", ObjectType: "secret"},
+		{ObjectName: "api-key", ObjectType: "secret", ObjectAlias: "API_TOKEN"},
+		{ObjectName: "tls", ObjectType: "cert"},
+	}
+
+	cases := []struct {
+		desc        string
+		object      KeyVaultObject
+		expectedErr error
+	}{
+		{
+			desc:        "not an env",
+			object:      KeyVaultObject{ObjectName: "db-password", ObjectType: "secret"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "objectRefs not set",
+			object:      KeyVaultObject{ObjectName: "app.env", ObjectType: "env"},
+			expectedErr: fmt.Errorf("objectRefs is not set"),
+		},
+		{
+			desc:        "referencing a cert",
+			object:      KeyVaultObject{ObjectName: "app.env", ObjectType: "env", ObjectRefs: "db-password,tls"},
+			expectedErr: fmt.Errorf("object tls referenced in objectRefs has unsupported objectType: cert"),
+		},
+		{
+			desc:        "duplicate key",
+			object:      KeyVaultObject{ObjectName: "app.env", ObjectType: "env", ObjectRefs: "db-password,db_password"},
+			expectedErr: fmt.Errorf("objects db-password and db_password have the same key DB_PASSWORD, set an objectAlias to use a different key"),
+		},
+		{
+			desc:        "valid refs",
+			object:      KeyVaultObject{ObjectName: "app.env", ObjectType: "env", ObjectRefs: "db-password,API_TOKEN"},
+			expectedErr: nil,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := validateEnv(tc.object, objects)
+			if tc.expectedErr != nil && (err == nil || err.Error() != tc.expectedErr.Error()) || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestGetEnvKey(t *testing.T) {
+	cases := map[string]string{
+		"db-password":  "DB_PASSWORD",
+		"API_TOKEN":    "API_TOKEN",
+		"app.env.key1": "APP_ENV_KEY1",
+		"1password":    "_1PASSWORD",
+		"clé":          "CL_",
+	}
+
+	for name, expectedKey := range cases {
+		if key := getEnvKey(name); key != expectedKey {
+			t.Fatalf("expected key for %s: %s, got: %s", name, expectedKey, key)
+		}
+	}
+}
+
+func TestGetEnvContent(t *testing.T) {
+	fetchedObjects := map[string]fetchedObject{
+		"db-password": {content: []byte(`pa$$"w\rd`), version: "v1"},
+		"api-token":   {content: []byte("it's$HOME`id`"), version: "v1"},
+		"cert":        {content: []byte("line1\nline2\n"), version: "v1"},
+		"user":        {content: []byte("admin"), version: "v1"},
+	}
+
+	cases := []struct {
+		desc            string
+		objectFormat    string
+		expectedContent string
+	}{
+		{
+			desc:         "dotenv",
+			objectFormat: "",
+			expectedContent: `API_TOKEN="it's\$HOME\` + "`" + `id\` + "`" + `"
+CERT="line1\nline2\n"
+DB_PASSWORD='pa$$"w\rd'
+USER='admin'
+`,
+		},
+		{
+			desc:         "json",
+			objectFormat: "JSON",
+			expectedContent: `{
+  "API_TOKEN": "it's$HOME` + "`" + `id` + "`" + `",
+  "CERT": "line1\nline2\n",
+  "DB_PASSWORD": "pa$$\"w\\rd",
+  "USER": "admin"
+}
+`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			object := KeyVaultObject{ObjectName: "app.env", ObjectType: "env", ObjectFormat: tc.objectFormat, ObjectRefs: "db-password,api-token,cert,user"}
+			content, version, err := getEnvContent(object, fetchedObjects)
+			if err != nil {
+				t.Fatalf("expected nil err, got: %v", err)
+			}
+			if string(content) != tc.expectedContent {
+				t.Fatalf("expected content: %q, got: %q", tc.expectedContent, string(content))
+			}
+			if version != getCompositeVersion([]string{"v1", "v1", "v1", "v1"}) {
+				t.Fatalf("expected composite version of the referenced objects, got: %s", version)
+			}
+		})
+	}
+}
@@ -0,0 +1,347 @@
+package provider
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/metrics"
+
+	"golang.org/x/crypto/ocsp"
+	"golang.org/x/net/context"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// revocationCheckFail fails the mount when the certificate is revoked
+	revocationCheckFail = "fail"
+	// revocationCheckLog logs the revoked certificate and continues with the mount
+	revocationCheckLog = "log"
+	// revocationCheckMetric records the revoked certificate in the metrics and continues with the mount
+	revocationCheckMetric = "metric"
+
+	// ocspResponseCacheSize is the maximum number of OCSP responses cached
+	ocspResponseCacheSize = 1024
+	// crlCacheSize is the maximum number of CRLs cached
+	crlCacheSize = 32
+	// revocationCacheTTL is the maximum duration the OCSP responses and CRLs are cached for
+	revocationCacheTTL = 24 * time.Hour
+)
+
+// errRevoked is returned when the certificate is revoked
+type errRevoked struct {
+	serialNumber string
+	revokedAt    time.Time
+	source       string
+}
+
+func (e *errRevoked) Error() string {
+	return fmt.Sprintf("certificate with serial number %s was revoked at %s according to %s", e.serialNumber, e.revokedAt.Format(time.RFC3339), e.source)
+}
+
+// revocationCache caches the OCSP responses or CRLs until their next update. The responses
+// are keyed by the certificates and URLs of the mounted objects, so the number of responses
+// and the duration they're cached for are limited.
+type revocationCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]*cachedRevocation
+}
+
+type cachedRevocation struct {
+	// the *ocsp.Response or *x509.RevocationList
+	value     interface{}
+	expiresAt time.Time
+}
+
+var (
+	// ocsp responses keyed by the issuer and serial number of the certificate
+	ocspResponses = newRevocationCache(ocspResponseCacheSize, revocationCacheTTL)
+	// certificate revocation lists keyed by the distribution point URL
+	crls = newRevocationCache(crlCacheSize, revocationCacheTTL)
+)
+
+func newRevocationCache(size int, ttl time.Duration) *revocationCache {
+	return &revocationCache{size: size, ttl: ttl, entries: make(map[string]*cachedRevocation)}
+}
+
+func (c *revocationCache) get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// add caches the value until its next update, or the cache TTL if it's sooner. The values
+// without next update have newer information available at any time and aren't cached.
+func (c *revocationCache) add(key string, value interface{}, nextUpdate time.Time) {
+	if nextUpdate.IsZero() {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	expiresAt := now.Add(c.ttl)
+	if nextUpdate.Before(expiresAt) {
+		expiresAt = nextUpdate
+	}
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.size {
+		// remove the expired entries, or the entry that expires first if none expired
+		var oldest string
+		for k, entry := range c.entries {
+			if now.After(entry.expiresAt) {
+				delete(c.entries, k)
+				continue
+			}
+			if len(oldest) == 0 || entry.expiresAt.Before(c.entries[oldest].expiresAt) {
+				oldest = k
+			}
+		}
+		if len(c.entries) >= c.size {
+			delete(c.entries, oldest)
+		}
+	}
+	c.entries[key] = &cachedRevocation{value: value, expiresAt: expiresAt}
+}
+
+// validateRevocationCheck checks if the revocation check action is valid
+// for the given object type
+func validateRevocationCheck(revocationCheck, objectType string) error {
+	if len(revocationCheck) == 0 {
+		return nil
+	}
+	if !strings.EqualFold(revocationCheck, revocationCheckFail) && !strings.EqualFold(revocationCheck, revocationCheckLog) && !strings.EqualFold(revocationCheck, revocationCheckMetric) {
+		return fmt.Errorf("invalid revocationCheck: %v, should be fail, log or metric", revocationCheck)
+	}
+	if objectType != VaultObjectTypeCertificate && objectType != VaultObjectTypeSecret {
+		return fmt.Errorf("revocationCheck only supported for objectType: cert, secret")
+	}
+	return nil
+}
+
+// checkRevocation checks the revocation status of the leaf certificate in the PEM content.
+// Depending on the revocationCheck action, a revoked certificate fails the mount, is
+// logged or is recorded in the metrics.
+func (p *Provider) checkRevocation(ctx context.Context, kvObject KeyVaultObject, content string) error {
+	if len(kvObject.RevocationCheck) == 0 {
+		return nil
+	}
+	err := checkCertificateRevocation(ctx, []byte(content))
+	if err == nil {
+		klog.InfoS("certificate is not revoked", "objectName", kvObject.ObjectName, "pod", klog.ObjectRef{Namespace: p.PodNamespace, Name: p.PodName})
+		return nil
+	}
+	if strings.EqualFold(kvObject.RevocationCheck, revocationCheckFail) {
+		return err
+	}
+	var revokedErr *errRevoked
+	if strings.EqualFold(kvObject.RevocationCheck, revocationCheckMetric) && errors.As(err, &revokedErr) {
+		metrics.RecordRevokedCertificate(kvObject.ObjectName, p.PodNamespace)
+	}
+	klog.ErrorS(err, "certificate revocation check failed, continuing with the mount", "revocationCheck", kvObject.RevocationCheck, "objectName", kvObject.ObjectName, "pod", klog.ObjectRef{Namespace: p.PodNamespace, Name: p.PodName})
+	return nil
+}
+
+// checkCertificateRevocation checks the revocation status of the leaf certificate in the PEM
+// data with the OCSP responders of the certificate, falling back to the CRL distribution points.
+// The issuer is read from the data or downloaded from the AIA CA Issuers URLs.
+func checkCertificateRevocation(ctx context.Context, data []byte) error {
+	certs, err := parseCertificates(data)
+	if err != nil {
+		return err
+	}
+	leaf, err := getLeafCertificate(certs)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(leaf.RawIssuer, leaf.RawSubject) {
+		return fmt.Errorf("revocation status can't be checked for self-signed certificate %q", leaf.Subject.String())
+	}
+	issuer, err := getIssuer(leaf, certs)
+	if err != nil {
+		return err
+	}
+
+	var errs []string
+	if len(leaf.OCSPServer) > 0 {
+		err = checkOCSP(ctx, leaf, issuer)
+		if err == nil {
+			return nil
+		}
+		var revokedErr *errRevoked
+		if errors.As(err, &revokedErr) {
+			return err
+		}
+		errs = append(errs, err.Error())
+	}
+	if len(leaf.CRLDistributionPoints) > 0 {
+		err = checkCRL(ctx, leaf, issuer)
+		if err == nil {
+			return nil
+		}
+		var revokedErr *errRevoked
+		if errors.As(err, &revokedErr) {
+			return err
+		}
+		errs = append(errs, err.Error())
+	}
+	if len(errs) == 0 {
+		return fmt.Errorf("certificate %q has no OCSP responder or CRL distribution point", leaf.Subject.String())
+	}
+	return fmt.Errorf("failed to check revocation status of certificate %q, errors: %s", leaf.Subject.String(), strings.Join(errs, "; "))
+}
+
+// getIssuer returns the issuer of the certificate from the certificates or
+// downloads it from the AIA CA Issuers URLs
+func getIssuer(cert *x509.Certificate, certs []*x509.Certificate) (*x509.Certificate, error) {
+	for _, c := range certs {
+		if c != cert && cert.CheckSignatureFrom(c) == nil {
+			return c, nil
+		}
+	}
+	issuer, err := fetchIssuer(cert)
+	if err != nil {
+		return nil, err
+	}
+	if issuer == nil {
+		return nil, fmt.Errorf("issuer of certificate %q not found", cert.Subject.String())
+	}
+	return issuer, nil
+}
+
+// checkOCSP checks the revocation status with the OCSP responders of the certificate.
+// The first valid response from the responders is used.
+func checkOCSP(ctx context.Context, cert, issuer *x509.Certificate) error {
+	key := string(issuer.RawSubjectPublicKeyInfo) + cert.SerialNumber.String()
+	var resp *ocsp.Response
+	if cached, ok := ocspResponses.get(key); ok {
+		resp = cached.(*ocsp.Response)
+	} else {
+		req, err := ocsp.CreateRequest(cert, issuer, nil)
+		if err != nil {
+			return err
+		}
+		var errs []string
+		for _, server := range cert.OCSPServer {
+			body, err := revocationRequest(ctx, http.MethodPost, server, req)
+			if err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+			if resp, err = ocsp.ParseResponseForCert(body, cert, issuer); err != nil {
+				resp = nil
+				errs = append(errs, fmt.Sprintf("invalid OCSP response from %s: %v", server, err))
+				continue
+			}
+			if isStale(resp.NextUpdate) {
+				errs = append(errs, fmt.Sprintf("stale OCSP response from %s: next update was due at %s", server, resp.NextUpdate.Format(time.RFC3339)))
+				resp = nil
+				continue
+			}
+			break
+		}
+		if resp == nil {
+			return fmt.Errorf("failed to get OCSP response, errors: %s", strings.Join(errs, "; "))
+		}
+		ocspResponses.add(key, resp, resp.NextUpdate)
+	}
+
+	switch resp.Status {
+	case ocsp.Good:
+		return nil
+	case ocsp.Revoked:
+		return &errRevoked{serialNumber: cert.SerialNumber.String(), revokedAt: resp.RevokedAt, source: "OCSP"}
+	default:
+		return fmt.Errorf("OCSP responder returned unknown status for certificate with serial number %s", cert.SerialNumber.String())
+	}
+}
+
+// checkCRL checks the revocation status with the CRL distribution points of the certificate.
+// The first CRL that's fetched and signed by the issuer is used.
+func checkCRL(ctx context.Context, cert, issuer *x509.Certificate) error {
+	var errs []string
+	for _, crlURL := range cert.CRLDistributionPoints {
+		var crl *x509.RevocationList
+		if cached, ok := crls.get(crlURL); ok {
+			crl = cached.(*x509.RevocationList)
+		} else {
+			body, err := revocationRequest(ctx, http.MethodGet, crlURL, nil)
+			if err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+			if crl, err = x509.ParseRevocationList(body); err != nil {
+				errs = append(errs, fmt.Sprintf("invalid CRL from %s: %v", crlURL, err))
+				continue
+			}
+			if err = crl.CheckSignatureFrom(issuer); err != nil {
+				errs = append(errs, fmt.Sprintf("CRL from %s not signed by issuer: %v", crlURL, err))
+				continue
+			}
+			if isStale(crl.NextUpdate) {
+				errs = append(errs, fmt.Sprintf("stale CRL from %s: next update was due at %s", crlURL, crl.NextUpdate.Format(time.RFC3339)))
+				continue
+			}
+			crls.add(crlURL, crl, crl.NextUpdate)
+		}
+		for _, entry := range crl.RevokedCertificates {
+			if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return &errRevoked{serialNumber: cert.SerialNumber.String(), revokedAt: entry.RevocationTime, source: "CRL"}
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("failed to get CRL, errors: %s", strings.Join(errs, "; "))
+}
+
+// isStale returns true if the next update of the OCSP response or CRL is in the past, so
+// newer revocation information should have been published
+func isStale(nextUpdate time.Time) bool {
+	return !nextUpdate.IsZero() && time.Now().After(nextUpdate)
+}
+
+// revocationRequest sends the request to the OCSP responder or CRL distribution point
+// and returns the response body
+func revocationRequest(ctx context.Context, method, url string, body []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, *RevocationCheckTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if method == http.MethodPost {
+		req.Header.Set("Content-Type", "application/ocsp-request")
+	}
+	client := &http.Client{Timeout: *RevocationCheckTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("request to %s failed with status code: %d", url, resp.StatusCode)
+	}
+	// read one byte more than the limit to detect responses that are too large
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, *RevocationMaxResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(respBody)) > *RevocationMaxResponseSize {
+		return nil, fmt.Errorf("response from %s exceeds the maximum size of %d bytes", url, *RevocationMaxResponseSize)
+	}
+	return respBody, nil
+}
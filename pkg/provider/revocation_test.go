@@ -0,0 +1,351 @@
+package provider
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+	"golang.org/x/net/context"
+)
+
+// testRevocationServer is a local OCSP responder and CRL distribution point
+type testRevocationServer struct {
+	*httptest.Server
+	issuer       *testCert
+	mu           sync.Mutex
+	revoked      map[string]bool
+	ocspRequests int32
+	crlRequests  int32
+}
+
+func newTestRevocationServer(t *testing.T, issuer *testCert) *testRevocationServer {
+	s := &testRevocationServer{issuer: issuer, revoked: make(map[string]bool)}
+	mux := http.NewServeMux()
+	ocspHandler := func(nextUpdate time.Duration) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&s.ocspRequests, 1)
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			req, err := ocsp.ParseRequest(body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			template := ocsp.Response{
+				Status:       ocsp.Good,
+				SerialNumber: req.SerialNumber,
+				ThisUpdate:   time.Now().Add(-time.Minute),
+				NextUpdate:   time.Now().Add(nextUpdate),
+			}
+			if s.isRevoked(req.SerialNumber) {
+				template.Status = ocsp.Revoked
+				template.RevokedAt = time.Now().Add(-time.Minute)
+			}
+			resp, err := ocsp.CreateResponse(issuer.cert, issuer.cert, template, issuer.key)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			_, _ = w.Write(resp)
+		}
+	}
+	mux.HandleFunc("/ocsp", ocspHandler(time.Hour))
+	mux.HandleFunc("/ocsp-stale", ocspHandler(-30*time.Second))
+	mux.HandleFunc("/ocsp-unavailable", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+	crlHandler := func(nextUpdate time.Duration) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&s.crlRequests, 1)
+			s.mu.Lock()
+			var entries []pkix.RevokedCertificate
+			for serial := range s.revoked {
+				n, _ := new(big.Int).SetString(serial, 10)
+				entries = append(entries, pkix.RevokedCertificate{SerialNumber: n, RevocationTime: time.Now().Add(-time.Minute)})
+			}
+			s.mu.Unlock()
+			crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+				Number:              big.NewInt(1),
+				ThisUpdate:          time.Now().Add(-time.Minute),
+				NextUpdate:          time.Now().Add(nextUpdate),
+				RevokedCertificates: entries,
+			}, issuer.cert, issuer.key)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			_, _ = w.Write(crl)
+		}
+	}
+	mux.HandleFunc("/crl", crlHandler(time.Hour))
+	mux.HandleFunc("/crl-stale", crlHandler(-30*time.Second))
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+func (s *testRevocationServer) revoke(cert *testCert) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revoked[cert.cert.SerialNumber.String()] = true
+}
+
+func (s *testRevocationServer) isRevoked(serial *big.Int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.revoked[serial.String()]
+}
+
+func (s *testRevocationServer) newLeaf(t *testing.T, name string, ocspPath, crlPath string) *testCert {
+	template := &x509.Certificate{Subject: pkix.Name{CommonName: name}}
+	if ocspPath != "" {
+		template.OCSPServer = []string{s.URL + ocspPath}
+	}
+	if crlPath != "" {
+		template.CRLDistributionPoints = []string{s.URL + crlPath}
+	}
+	return newTestCert(t, template, s.issuer)
+}
+
+func TestValidateRevocationCheck(t *testing.T) {
+	cases := []struct {
+		desc            string
+		revocationCheck string
+		objectType      string
+		expectedErr     error
+	}{
+		{
+			desc:            "revocation check not configured",
+			revocationCheck: "",
+			objectType:      "key",
+			expectedErr:     nil,
+		},
+		{
+			desc:            "invalid action",
+			revocationCheck: "ignore",
+			objectType:      "cert",
+			expectedErr:     fmt.Errorf("invalid revocationCheck: ignore, should be fail, log or metric"),
+		},
+		{
+			desc:            "object type key",
+			revocationCheck: "fail",
+			objectType:      "key",
+			expectedErr:     fmt.Errorf("revocationCheck only supported for objectType: cert, secret"),
+		},
+		{
+			desc:            "valid action case insensitive check",
+			revocationCheck: "Metric",
+			objectType:      "secret",
+			expectedErr:     nil,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := validateRevocationCheck(tc.revocationCheck, tc.objectType)
+			if tc.expectedErr != nil && (err == nil || err.Error() != tc.expectedErr.Error()) || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestCheckCertificateRevocation(t *testing.T) {
+	issuer := newTestCA(t, "Test Issuing CA", nil)
+	server := newTestRevocationServer(t, issuer)
+	defer server.Close()
+
+	good := server.newLeaf(t, "good.example.com", "/ocsp", "/crl")
+	revoked := server.newLeaf(t, "revoked.example.com", "/ocsp", "/crl")
+	crlGood := server.newLeaf(t, "crl-good.example.com", "/ocsp-unavailable", "/crl")
+	crlRevoked := server.newLeaf(t, "crl-revoked.example.com", "/ocsp-unavailable", "/crl")
+	unavailable := server.newLeaf(t, "unavailable.example.com", "/ocsp-unavailable", "")
+	noEndpoints := server.newLeaf(t, "none.example.com", "", "")
+	staleOCSP := server.newLeaf(t, "stale-ocsp.example.com", "/ocsp-stale", "")
+	staleCRL := server.newLeaf(t, "stale-crl.example.com", "/ocsp-unavailable", "/crl-stale")
+	server.revoke(revoked)
+	server.revoke(crlRevoked)
+
+	cases := []struct {
+		desc            string
+		data            string
+		expectedErr     bool
+		expectedRevoked bool
+	}{
+		{
+			desc:        "good status from OCSP",
+			data:        good.pem() + issuer.pem(),
+			expectedErr: false,
+		},
+		{
+			desc:            "revoked status from OCSP",
+			data:            revoked.pem() + issuer.pem(),
+			expectedErr:     true,
+			expectedRevoked: true,
+		},
+		{
+			desc:        "OCSP unavailable, not revoked in CRL",
+			data:        crlGood.pem() + issuer.pem(),
+			expectedErr: false,
+		},
+		{
+			desc:            "OCSP unavailable, revoked in CRL",
+			data:            crlRevoked.pem() + issuer.pem(),
+			expectedErr:     true,
+			expectedRevoked: true,
+		},
+		{
+			desc:        "OCSP unavailable and no CRL",
+			data:        unavailable.pem() + issuer.pem(),
+			expectedErr: true,
+		},
+		{
+			desc:        "stale OCSP response",
+			data:        staleOCSP.pem() + issuer.pem(),
+			expectedErr: true,
+		},
+		{
+			desc:        "OCSP unavailable and stale CRL",
+			data:        staleCRL.pem() + issuer.pem(),
+			expectedErr: true,
+		},
+		{
+			desc:        "no OCSP responder or CRL distribution point",
+			data:        noEndpoints.pem() + issuer.pem(),
+			expectedErr: true,
+		},
+		{
+			desc:        "issuer not found",
+			data:        good.pem(),
+			expectedErr: true,
+		},
+		{
+			desc:        "self-signed certificate",
+			data:        issuer.pem(),
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := checkCertificateRevocation(context.TODO(), []byte(tc.data))
+			if tc.expectedErr && err == nil || !tc.expectedErr && err != nil {
+				t.Fatalf("expected error: %v, got error: %v", tc.expectedErr, err)
+			}
+			_, isRevoked := err.(*errRevoked)
+			if tc.expectedRevoked != isRevoked {
+				t.Fatalf("expected revoked: %v, got error: %v", tc.expectedRevoked, err)
+			}
+		})
+	}
+
+	// the responses are cached until the next update
+	ocspRequests, crlRequests := atomic.LoadInt32(&server.ocspRequests), atomic.LoadInt32(&server.crlRequests)
+	if err := checkCertificateRevocation(context.TODO(), []byte(good.pem()+issuer.pem())); err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	if err := checkCertificateRevocation(context.TODO(), []byte(crlGood.pem()+issuer.pem())); err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	if atomic.LoadInt32(&server.ocspRequests) != ocspRequests || atomic.LoadInt32(&server.crlRequests) != crlRequests {
+		t.Fatalf("expected cached OCSP response and CRL to be used")
+	}
+}
+
+func TestCheckRevocation(t *testing.T) {
+	issuer := newTestCA(t, "Test Issuing CA", nil)
+	server := newTestRevocationServer(t, issuer)
+	defer server.Close()
+
+	revoked := server.newLeaf(t, "revoked.example.com", "/ocsp", "")
+	server.revoke(revoked)
+
+	cases := []struct {
+		desc        string
+		object      KeyVaultObject
+		expectedErr bool
+	}{
+		{
+			desc:        "revocation check not configured",
+			object:      KeyVaultObject{ObjectName: "cert1"},
+			expectedErr: false,
+		},
+		{
+			desc:        "revoked certificate with fail action",
+			object:      KeyVaultObject{ObjectName: "cert1", RevocationCheck: "fail"},
+			expectedErr: true,
+		},
+		{
+			desc:        "revoked certificate with log action",
+			object:      KeyVaultObject{ObjectName: "cert1", RevocationCheck: "log"},
+			expectedErr: false,
+		},
+		{
+			desc:        "revoked certificate with metric action",
+			object:      KeyVaultObject{ObjectName: "cert1", RevocationCheck: "metric"},
+			expectedErr: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			p, err := NewProvider()
+			if err != nil {
+				t.Fatalf("expected nil err, got: %v", err)
+			}
+			err = p.checkRevocation(context.TODO(), tc.object, revoked.pem()+issuer.pem())
+			if tc.expectedErr && err == nil || !tc.expectedErr && err != nil {
+				t.Fatalf("expected error: %v, got error: %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestRevocationCache(t *testing.T) {
+	resp := &ocsp.Response{Status: ocsp.Good}
+	cache := newRevocationCache(2, time.Hour)
+	cache.add("first", resp, time.Now().Add(time.Minute))
+	cache.add("second", resp, time.Now().Add(2*time.Hour))
+	cache.add("no next update", resp, time.Time{})
+
+	// the entries expire at their next update or the TTL if it's sooner
+	if expiresAt := cache.entries["second"].expiresAt; expiresAt.After(time.Now().Add(time.Hour)) {
+		t.Fatalf("expected entry to expire within the TTL, got: %s", expiresAt)
+	}
+	if _, ok := cache.get("no next update"); ok {
+		t.Fatalf("expected entry without next update not to be cached")
+	}
+
+	// the entry that expires first is removed when the cache is full
+	cache.add("third", resp, time.Now().Add(time.Hour))
+	if len(cache.entries) != 2 {
+		t.Fatalf("expected 2 cached entries, got: %d", len(cache.entries))
+	}
+	if _, ok := cache.get("first"); ok {
+		t.Fatalf("expected first entry to be removed")
+	}
+	if _, ok := cache.get("third"); !ok {
+		t.Fatalf("expected third entry to be cached")
+	}
+
+	// the expired entries are removed
+	cache.entries["second"].expiresAt = time.Now().Add(-time.Minute)
+	if _, ok := cache.get("second"); ok {
+		t.Fatalf("expected expired entry not to be returned")
+	}
+	if len(cache.entries) != 1 {
+		t.Fatalf("expected 1 cached entry, got: %d", len(cache.entries))
+	}
+}
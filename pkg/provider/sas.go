@@ -0,0 +1,171 @@
+package provider
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	kv "github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+	"golang.org/x/net/context"
+	"k8s.io/klog/v2"
+)
+
+// sasFile is the SAS token written for objectFormat json
+type sasFile struct {
+	SASToken  string     `json:"sasToken"`
+	URL       string     `json:"url,omitempty"`
+	ExpiresOn *time.Time `json:"expiresOn,omitempty"`
+}
+
+// validateSAS checks if the SAS definition options are valid for the given object
+func validateSAS(kvObject KeyVaultObject) error {
+	if kvObject.ObjectType != VaultObjectTypeSAS {
+		if len(kvObject.StorageAccount) > 0 || len(kvObject.Container) > 0 || len(kvObject.Blob) > 0 {
+			return fmt.Errorf("storageAccount, container and blob only supported for objectType: sas")
+		}
+		return nil
+	}
+	if len(kvObject.StorageAccount) == 0 {
+		return fmt.Errorf("objectType: sas requires storageAccount to be set")
+	}
+	if len(kvObject.Blob) > 0 && len(kvObject.Container) == 0 {
+		return fmt.Errorf("blob requires container to be set")
+	}
+	if strings.Contains(kvObject.Container, "/") {
+		return fmt.Errorf("invalid container: %v, should be the name of the container without the blob", kvObject.Container)
+	}
+	// a new SAS token is minted from the SAS definition on every read
+	if len(kvObject.ObjectVersion) > 0 {
+		return fmt.Errorf("objectVersion not supported for objectType: sas")
+	}
+	return nil
+}
+
+// getSASContent retrieves a new SAS token from the SAS definition objectName of the managed
+// storage account. The token is composed into the URL of the container or blob if set. The
+// version is the expiry of the token, so the token is refreshed on each rotation poll.
+func (p *Provider) getSASContent(ctx context.Context, kvClient *kv.BaseClient, vaultURL string, kvObject KeyVaultObject) (string, string, error) {
+	sasDefinition, err := kvClient.GetSasDefinition(ctx, vaultURL, kvObject.StorageAccount, kvObject.ObjectName)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get SAS definition %s of storage account %s, error: %w", kvObject.ObjectName, kvObject.StorageAccount, err)
+	}
+	if sasDefinition.Attributes != nil && sasDefinition.Attributes.Enabled != nil && !*sasDefinition.Attributes.Enabled {
+		return "", "", fmt.Errorf("SAS definition %s of storage account %s is disabled", kvObject.ObjectName, kvObject.StorageAccount)
+	}
+	if sasDefinition.SecretID == nil {
+		return "", "", fmt.Errorf("SAS definition secret id is nil")
+	}
+	// the SAS token is minted when the secret of the SAS definition is read
+	secretName, err := getSecretNameFromID(*sasDefinition.SecretID)
+	if err != nil {
+		return "", "", err
+	}
+	secret, err := kvClient.GetSecret(ctx, vaultURL, secretName, "")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get SAS token of SAS definition %s, error: %w", kvObject.ObjectName, err)
+	}
+	if secret.Value == nil {
+		return "", "", fmt.Errorf("secret value is nil")
+	}
+	if secret.ID == nil {
+		return "", "", fmt.Errorf("secret id is nil")
+	}
+	sasToken := strings.TrimPrefix(strings.TrimSpace(*secret.Value), "?")
+
+	var storageURL string
+	if len(kvObject.Container) > 0 {
+		// the name of the storage account in Azure can differ from the name in Key Vault
+		storage, err := kvClient.GetStorageAccount(ctx, vaultURL, kvObject.StorageAccount)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to get storage account %s, error: %w", kvObject.StorageAccount, err)
+		}
+		accountName := kvObject.StorageAccount
+		if storage.ResourceID != nil {
+			// the resource id ends with /providers/Microsoft.Storage/storageAccounts/{name}
+			accountName = (*storage.ResourceID)[strings.LastIndex(*storage.ResourceID, "/")+1:]
+		}
+		storageURL = getBlobURL(accountName, p.AzureCloudEnvironment.StorageEndpointSuffix, kvObject.Container, kvObject.Blob, sasToken)
+	}
+
+	expiresOn, err := getSASExpiry(sasToken)
+	if err != nil {
+		return "", "", err
+	}
+	version := getObjectVersion(*secret.ID)
+	if expiresOn != nil {
+		version = strconv.FormatInt(expiresOn.Unix(), 10)
+	}
+	klog.InfoS("retrieved SAS token", "objectName", kvObject.ObjectName, "storageAccount", kvObject.StorageAccount, "expiry", expiresOn, "pod", klog.ObjectRef{Namespace: p.PodNamespace, Name: p.PodName})
+	content, err := encodeSAS(sasToken, storageURL, expiresOn, kvObject.ObjectFormat)
+	if err != nil {
+		return "", "", err
+	}
+	return content, version, nil
+}
+
+// getSecretNameFromID returns the name of the secret in the secret id
+// https://{vault}/secrets/{name}[/{version}]
+func getSecretNameFromID(id string) (string, error) {
+	u, err := url.Parse(id)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse secret id %s, error: %w", id, err)
+	}
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(segments) < 2 || segments[0] != "secrets" || len(segments[1]) == 0 {
+		return "", fmt.Errorf("invalid secret id: %s", id)
+	}
+	return segments[1], nil
+}
+
+// getSASExpiry returns the expiry of the SAS token from its signed expiry parameter, or nil
+// if the token doesn't have one
+func getSASExpiry(sasToken string) (*time.Time, error) {
+	query, err := url.ParseQuery(sasToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SAS token, error: %w", err)
+	}
+	signedExpiry := query.Get("se")
+	if len(signedExpiry) == 0 {
+		return nil, nil
+	}
+	// the signed expiry is in ISO 8601 UTC, with or without the seconds
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04Z", "2006-01-02"} {
+		if expiresOn, err := time.Parse(layout, signedExpiry); err == nil {
+			expiresOn = expiresOn.UTC()
+			return &expiresOn, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid signed expiry of SAS token: %s", signedExpiry)
+}
+
+// getBlobURL returns the URL of the container, or the blob in the container, with the SAS token
+func getBlobURL(accountName, storageEndpointSuffix, container, blob, sasToken string) string {
+	path := "/" + url.PathEscape(container)
+	if len(blob) > 0 {
+		segments := strings.Split(strings.TrimPrefix(blob, "/"), "/")
+		for i := range segments {
+			segments[i] = url.PathEscape(segments[i])
+		}
+		path += "/" + strings.Join(segments, "/")
+	}
+	return "https://" + accountName + ".blob." + storageEndpointSuffix + path + "?" + sasToken
+}
+
+// encodeSAS returns the URL if set or the SAS token, or both as JSON with the expiry for
+// objectFormat json
+func encodeSAS(sasToken, storageURL string, expiresOn *time.Time, objectFormat string) (string, error) {
+	if !strings.EqualFold(objectFormat, objectFormatJSON) {
+		if len(storageURL) > 0 {
+			return storageURL, nil
+		}
+		return sasToken, nil
+	}
+	// the & of the query parameters isn't escaped
+	content, err := marshalJSON(sasFile{SASToken: sasToken, URL: storageURL, ExpiresOn: expiresOn})
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
@@ -0,0 +1,233 @@
+package provider
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestValidateSAS(t *testing.T) {
+	cases := []struct {
+		desc        string
+		object      KeyVaultObject
+		expectedErr error
+	}{
+		{
+			desc:        "storage account for object type secret",
+			object:      KeyVaultObject{ObjectName: "readonly", ObjectType: "secret", StorageAccount: "mystorage"},
+			expectedErr: fmt.Errorf("storageAccount, container and blob only supported for objectType: sas"),
+		},
+		{
+			desc:        "storage account not set",
+			object:      KeyVaultObject{ObjectName: "readonly", ObjectType: "sas"},
+			expectedErr: fmt.Errorf("objectType: sas requires storageAccount to be set"),
+		},
+		{
+			desc:        "blob without container",
+			object:      KeyVaultObject{ObjectName: "readonly", ObjectType: "sas", StorageAccount: "mystorage", Blob: "exports/orders.csv"},
+			expectedErr: fmt.Errorf("blob requires container to be set"),
+		},
+		{
+			desc:        "container with blob",
+			object:      KeyVaultObject{ObjectName: "readonly", ObjectType: "sas", StorageAccount: "mystorage", Container: "reports/exports"},
+			expectedErr: fmt.Errorf("invalid container: reports/exports, should be the name of the container without the blob"),
+		},
+		{
+			desc:        "object version set",
+			object:      KeyVaultObject{ObjectName: "readonly", ObjectType: "sas", StorageAccount: "mystorage", ObjectVersion: "v1"},
+			expectedErr: fmt.Errorf("objectVersion not supported for objectType: sas"),
+		},
+		{
+			desc:        "SAS token",
+			object:      KeyVaultObject{ObjectName: "readonly", ObjectType: "sas", StorageAccount: "mystorage"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "blob URL",
+			object:      KeyVaultObject{ObjectName: "readonly", ObjectType: "sas", StorageAccount: "mystorage", Container: "reports", Blob: "exports/orders.csv"},
+			expectedErr: nil,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := validateSAS(tc.object)
+			if tc.expectedErr != nil && (err == nil || err.Error() != tc.expectedErr.Error()) || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestGetSecretNameFromID(t *testing.T) {
+	cases := []struct {
+		desc         string
+		id           string
+		expectedName string
+		expectedErr  error
+	}{
+		{
+			desc:         "secret id",
+			id:           "https://myvault.vault.azure.net/secrets/mystorage-readonly",
+			expectedName: "mystorage-readonly",
+		},
+		{
+			desc:         "secret id with version",
+			id:           "https://myvault.vault.azure.net/secrets/mystorage-readonly/4387e9f3d6e14c459867679a90fd0f79",
+			expectedName: "mystorage-readonly",
+		},
+		{
+			desc:        "key id",
+			id:          "https://myvault.vault.azure.net/keys/mystorage-readonly",
+			expectedErr: fmt.Errorf("invalid secret id: https://myvault.vault.azure.net/keys/mystorage-readonly"),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			name, err := getSecretNameFromID(tc.id)
+			if tc.expectedErr != nil && (err == nil || err.Error() != tc.expectedErr.Error()) || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+			if name != tc.expectedName {
+				t.Fatalf("expected name: %s, got: %s", tc.expectedName, name)
+			}
+		})
+	}
+}
+
+func TestGetSASExpiry(t *testing.T) {
+	cases := []struct {
+		desc           string
+		sasToken       string
+		expectedExpiry *time.Time
+		expectedErr    error
+	}{
+		{
+			desc:           "expiry with seconds",
+			sasToken:       "sv=2018-03-28&ss=b&srt=co&sp=rl&se=2023-11-14T23%3A13%3A20Z&sig=c2ln",
+			expectedExpiry: newTestTime(time.Date(2023, 11, 14, 23, 13, 20, 0, time.UTC)),
+		},
+		{
+			desc:           "expiry without seconds",
+			sasToken:       "sv=2018-03-28&sr=c&sp=r&se=2023-11-14T23:13Z&sig=c2ln",
+			expectedExpiry: newTestTime(time.Date(2023, 11, 14, 23, 13, 0, 0, time.UTC)),
+		},
+		{
+			desc:           "expiry date",
+			sasToken:       "sv=2018-03-28&sr=c&sp=r&se=2023-11-14&sig=c2ln",
+			expectedExpiry: newTestTime(time.Date(2023, 11, 14, 0, 0, 0, 0, time.UTC)),
+		},
+		{
+			desc:     "no expiry",
+			sasToken: "sv=2018-03-28&sr=c&sp=r&si=readonly-policy&sig=c2ln",
+		},
+		{
+			desc:        "invalid expiry",
+			sasToken:    "sv=2018-03-28&se=tomorrow&sig=c2ln",
+			expectedErr: fmt.Errorf("invalid signed expiry of SAS token: tomorrow"),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			expiresOn, err := getSASExpiry(tc.sasToken)
+			if tc.expectedErr != nil && (err == nil || err.Error() != tc.expectedErr.Error()) || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+			if (expiresOn == nil) != (tc.expectedExpiry == nil) || expiresOn != nil && !expiresOn.Equal(*tc.expectedExpiry) {
+				t.Fatalf("expected expiry: %v, got: %v", tc.expectedExpiry, expiresOn)
+			}
+		})
+	}
+}
+
+func newTestTime(t time.Time) *time.Time {
+	return &t
+}
+
+func TestGetBlobURL(t *testing.T) {
+	cases := []struct {
+		desc        string
+		container   string
+		blob        string
+		expectedURL string
+	}{
+		{
+			desc:        "container",
+			container:   "reports",
+			expectedURL: "https://storageprod.blob.core.windows.net/reports?sv=2018-03-28&sr=c&sig=c2ln",
+		},
+		{
+			desc:        "blob",
+			container:   "reports",
+			blob:        "exports/2023 orders.csv",
+			expectedURL: "https://storageprod.blob.core.windows.net/reports/exports/2023%20orders.csv?sv=2018-03-28&sr=c&sig=c2ln",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if actual := getBlobURL("storageprod", "core.windows.net", tc.container, tc.blob, "sv=2018-03-28&sr=c&sig=c2ln"); actual != tc.expectedURL {
+				t.Fatalf("expected URL: %s, got: %s", tc.expectedURL, actual)
+			}
+		})
+	}
+}
+
+func TestEncodeSAS(t *testing.T) {
+	sasToken := "sv=2018-03-28&sr=c&se=2023-11-14T23%3A13%3A20Z&sig=c2ln"
+	storageURL := "https://storageprod.blob.core.windows.net/reports?" + sasToken
+	expiresOn := newTestTime(time.Date(2023, 11, 14, 23, 13, 20, 0, time.UTC))
+	cases := []struct {
+		desc            string
+		storageURL      string
+		expiresOn       *time.Time
+		objectFormat    string
+		expectedContent string
+	}{
+		{
+			desc:            "SAS token",
+			expiresOn:       expiresOn,
+			expectedContent: sasToken,
+		},
+		{
+			desc:            "URL",
+			storageURL:      storageURL,
+			expiresOn:       expiresOn,
+			expectedContent: storageURL,
+		},
+		{
+			desc:         "json with URL",
+			storageURL:   storageURL,
+			expiresOn:    expiresOn,
+			objectFormat: "json",
+			expectedContent: `{
+  "sasToken": "sv=2018-03-28&sr=c&se=2023-11-14T23%3A13%3A20Z&sig=c2ln",
+  "url": "https://storageprod.blob.core.windows.net/reports?sv=2018-03-28&sr=c&se=2023-11-14T23%3A13%3A20Z&sig=c2ln",
+  "expiresOn": "2023-11-14T23:13:20Z"
+}
+`,
+		},
+		{
+			desc:         "json without expiry",
+			objectFormat: "JSON",
+			expectedContent: `{
+  "sasToken": "sv=2018-03-28&sr=c&se=2023-11-14T23%3A13%3A20Z&sig=c2ln"
+}
+`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			content, err := encodeSAS(sasToken, tc.storageURL, tc.expiresOn, tc.objectFormat)
+			if err != nil {
+				t.Fatalf("expected nil err, got: %v", err)
+			}
+			if content != tc.expectedContent {
+				t.Fatalf("expected content: %s, got: %s", tc.expectedContent, content)
+			}
+		})
+	}
+}
@@ -0,0 +1,292 @@
+package provider
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"golang.org/x/net/context"
+	"k8s.io/klog/v2"
+)
+
+const (
+	signatureRS256 = "RS256"
+	signatureES256 = "ES256"
+	signaturePS256 = "PS256"
+)
+
+// validateVerifySignature checks if the signature verification options are valid
+// for the given object
+func validateVerifySignature(kvObject KeyVaultObject) error {
+	if len(kvObject.VerifySignature) == 0 {
+		if len(kvObject.SignatureSecretName) > 0 || len(kvObject.SignatureTag) > 0 || len(kvObject.SignatureKeyName) > 0 || len(kvObject.SignaturePublicKeyPEM) > 0 {
+			return fmt.Errorf("signatureSecretName, signatureTag, signatureKeyName and signaturePublicKeyPEM require verifySignature to be set")
+		}
+		return nil
+	}
+	if !isSignatureAlgorithm(kvObject.VerifySignature) {
+		return fmt.Errorf("invalid verifySignature: %v, should be RS256, ES256 or PS256", kvObject.VerifySignature)
+	}
+	if kvObject.ObjectType != VaultObjectTypeSecret {
+		return fmt.Errorf("verifySignature only supported for objectType: secret")
+	}
+	if len(kvObject.SignatureSecretName) == 0 && len(kvObject.SignatureTag) == 0 {
+		return fmt.Errorf("either signatureSecretName or signatureTag should be set")
+	}
+	if len(kvObject.SignatureSecretName) > 0 && len(kvObject.SignatureTag) > 0 {
+		return fmt.Errorf("only one of signatureSecretName or signatureTag can be set")
+	}
+	if len(kvObject.SignatureKeyName) == 0 && len(kvObject.SignaturePublicKeyPEM) == 0 {
+		return fmt.Errorf("either signatureKeyName or signaturePublicKeyPEM should be set")
+	}
+	if len(kvObject.SignatureKeyName) > 0 && len(kvObject.SignaturePublicKeyPEM) > 0 {
+		return fmt.Errorf("only one of signatureKeyName or signaturePublicKeyPEM can be set")
+	}
+	if len(kvObject.SignaturePublicKeyPEM) > 0 {
+		if _, err := parsePublicKeyPEM(kvObject.SignaturePublicKeyPEM); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isSignatureAlgorithm returns true if the algorithm is one of the supported signature algorithms
+func isSignatureAlgorithm(alg string) bool {
+	return strings.EqualFold(alg, signatureRS256) || strings.EqualFold(alg, signatureES256) || strings.EqualFold(alg, signaturePS256)
+}
+
+// verifyContentSignature verifies the detached signature of the content with the Key Vault key
+// or the pinned public key. The signature is read from the secret signatureSecretName or the tag
+// signatureTag of the object version. The versions of the signature secret and key are returned
+// by object UID to be reported in addition to the version of the object.
+func (p *Provider) verifyContentSignature(ctx context.Context, kvObject KeyVaultObject, content, version string) (map[string]string, error) {
+	if len(kvObject.VerifySignature) == 0 {
+		return nil, nil
+	}
+	versions := make(map[string]string)
+
+	var signature string
+	if len(kvObject.SignatureSecretName) > 0 {
+		value, signatureVersion, err := p.getSecretValue(ctx, kvObject.SignatureSecretName, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get signature from secret %s, error: %w", kvObject.SignatureSecretName, err)
+		}
+		signature = value
+		versions[getObjectUID(kvObject.SignatureSecretName, VaultObjectTypeSecret)] = signatureVersion
+	} else {
+		tags, err := p.getSecretTags(ctx, kvObject.ObjectName, version)
+		if err != nil {
+			return nil, err
+		}
+		value, ok := tags[kvObject.SignatureTag]
+		if !ok || value == nil {
+			return nil, fmt.Errorf("signature tag %s not found on secret %s", kvObject.SignatureTag, kvObject.ObjectName)
+		}
+		signature = *value
+	}
+
+	var publicKey crypto.PublicKey
+	if len(kvObject.SignatureKeyName) > 0 {
+		key, keyVersion, err := p.getKeyVaultPublicKey(ctx, kvObject.SignatureKeyName)
+		if err != nil {
+			return nil, err
+		}
+		publicKey = key
+		versions[getObjectUID(kvObject.SignatureKeyName, VaultObjectTypeKey)] = keyVersion
+	} else {
+		var err error
+		if publicKey, err = parsePublicKeyPEM(kvObject.SignaturePublicKeyPEM); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := verifySignature([]byte(content), signature, kvObject.VerifySignature, publicKey); err != nil {
+		return nil, err
+	}
+	klog.InfoS("successfully verified content signature", "objectName", kvObject.ObjectName, "algorithm", strings.ToUpper(kvObject.VerifySignature), "pod", klog.ObjectRef{Namespace: p.PodNamespace, Name: p.PodName})
+	return versions, nil
+}
+
+// getSecretTags returns the tags of the version of the Key Vault secret
+func (p *Provider) getSecretTags(ctx context.Context, secretName, secretVersion string) (map[string]*string, error) {
+	vaultURL, err := p.getVaultURL(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get vault, error: %w", err)
+	}
+	kvClient, err := p.initializeKvClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get keyvault client, error: %w", err)
+	}
+	secret, err := kvClient.GetSecret(ctx, *vaultURL, secretName, secretVersion)
+	if err != nil {
+		return nil, wrapObjectTypeError(err, VaultObjectTypeSecret, secretName, secretVersion)
+	}
+	return secret.Tags, nil
+}
+
+// getKeyVaultPublicKey returns the public key of the latest version of the Key Vault key
+// with the version of the key
+func (p *Provider) getKeyVaultPublicKey(ctx context.Context, keyName string) (crypto.PublicKey, string, error) {
+	vaultURL, err := p.getVaultURL(ctx)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get vault, error: %w", err)
+	}
+	kvClient, err := p.initializeKvClient()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get keyvault client, error: %w", err)
+	}
+	keybundle, err := kvClient.GetKey(ctx, *vaultURL, keyName, "")
+	if err != nil {
+		return nil, "", wrapObjectTypeError(err, VaultObjectTypeKey, keyName, "")
+	}
+	if keybundle.Key == nil || keybundle.Key.Kid == nil {
+		return nil, "", fmt.Errorf("key %s value is nil", keyName)
+	}
+	publicKey, err := getPublicKey(*keybundle.Key)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get public key of key %s, error: %w", keyName, err)
+	}
+	return publicKey, getObjectVersion(*keybundle.Key.Kid), nil
+}
+
+// parsePublicKeyPEM parses the PEM encoded PKIX public key
+func parsePublicKeyPEM(data string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("failed to parse signaturePublicKeyPEM, should be a PEM encoded PUBLIC KEY")
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signaturePublicKeyPEM, error: %w", err)
+	}
+	return publicKey, nil
+}
+
+// verifySignature verifies the base64 or base64url encoded signature of the SHA-256 digest
+// of the content. The ES256 signatures are accepted both in the JWS encoding returned by the
+// Key Vault sign operation, the 32 byte r and s concatenated, and in ASN.1 DER.
+func verifySignature(content []byte, signature, alg string, publicKey crypto.PublicKey) error {
+	sig, err := decodeSignature(signature)
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(content)
+	switch strings.ToUpper(alg) {
+	case signatureRS256, signaturePS256:
+		rsaKey, ok := publicKey.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%s signature requires an RSA key", strings.ToUpper(alg))
+		}
+		if strings.EqualFold(alg, signatureRS256) {
+			err = rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], sig)
+		} else {
+			err = rsa.VerifyPSS(rsaKey, crypto.SHA256, digest[:], sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto})
+		}
+	case signatureES256:
+		ecKey, ok := publicKey.(*ecdsa.PublicKey)
+		if !ok || ecKey.Curve != elliptic.P256() {
+			return fmt.Errorf("ES256 signature requires a P-256 EC key")
+		}
+		if len(sig) == 64 {
+			if !ecdsa.Verify(ecKey, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+				err = fmt.Errorf("verification error")
+			}
+		} else if !ecdsa.VerifyASN1(ecKey, digest[:], sig) {
+			err = fmt.Errorf("verification error")
+		}
+	default:
+		return fmt.Errorf("invalid verifySignature: %v, should be RS256, ES256 or PS256", alg)
+	}
+	if err != nil {
+		return fmt.Errorf("signature doesn't match the content, error: %w", err)
+	}
+	return nil
+}
+
+// decodeSignature decodes the base64 or base64url encoded signature, with or without padding
+func decodeSignature(signature string) ([]byte, error) {
+	signature = strings.TrimRight(strings.TrimSpace(signature), "=")
+	if sig, err := base64.RawStdEncoding.DecodeString(signature); err == nil {
+		return sig, nil
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode signature, should be base64 encoded")
+	}
+	return sig, nil
+}
+
+// validateCertificateThumbprints checks if the pinned certificate thumbprints are valid
+// for the given object
+func validateCertificateThumbprints(kvObject KeyVaultObject) error {
+	if len(kvObject.CertificateThumbprints) == 0 {
+		return nil
+	}
+	if kvObject.ObjectType != VaultObjectTypeCertificate && kvObject.ObjectType != VaultObjectTypeSecret {
+		return fmt.Errorf("certificateThumbprints only supported for objectType: cert, secret")
+	}
+	_, err := getCertificateThumbprints(kvObject.CertificateThumbprints)
+	return err
+}
+
+// getCertificateThumbprints parses the comma separated list of hex encoded thumbprints. The
+// colons between the bytes, as printed by openssl, are ignored.
+func getCertificateThumbprints(list string) ([]string, error) {
+	var thumbprints []string
+	for _, item := range splitList(list) {
+		thumbprint, err := hex.DecodeString(strings.ReplaceAll(item, ":", ""))
+		if err != nil || len(thumbprint) != sha1.Size && len(thumbprint) != sha256.Size {
+			return nil, fmt.Errorf("invalid certificate thumbprint: %v, should be a hex encoded SHA-1 thumbprint or SHA-256 fingerprint", item)
+		}
+		thumbprints = append(thumbprints, hex.EncodeToString(thumbprint))
+	}
+	return thumbprints, nil
+}
+
+// verifyCertificateThumbprint checks the leaf certificate in the PEM content against the pinned
+// thumbprints, the mount fails if the certificate doesn't match any of them
+func (p *Provider) verifyCertificateThumbprint(kvObject KeyVaultObject, content string) error {
+	if len(kvObject.CertificateThumbprints) == 0 {
+		return nil
+	}
+	if err := matchCertificateThumbprint([]byte(content), kvObject.CertificateThumbprints); err != nil {
+		return err
+	}
+	klog.InfoS("certificate matches pinned thumbprint", "objectName", kvObject.ObjectName, "pod", klog.ObjectRef{Namespace: p.PodNamespace, Name: p.PodName})
+	return nil
+}
+
+// matchCertificateThumbprint returns an error if the SHA-1 thumbprint and SHA-256 fingerprint
+// of the leaf certificate in the PEM data don't match any of the pinned thumbprints
+func matchCertificateThumbprint(data []byte, pinned string) error {
+	thumbprints, err := getCertificateThumbprints(pinned)
+	if err != nil {
+		return err
+	}
+	certs, err := parseCertificates(data)
+	if err != nil {
+		return err
+	}
+	leaf, err := getLeafCertificate(certs)
+	if err != nil {
+		return err
+	}
+	sha1Sum := sha1.Sum(leaf.Raw)
+	sha256Sum := sha256.Sum256(leaf.Raw)
+	for _, thumbprint := range thumbprints {
+		if thumbprint == hex.EncodeToString(sha1Sum[:]) || thumbprint == hex.EncodeToString(sha256Sum[:]) {
+			return nil
+		}
+	}
+	return fmt.Errorf("certificate %q with SHA-256 fingerprint %s doesn't match the pinned thumbprints", leaf.Subject.String(), strings.ToUpper(hex.EncodeToString(sha256Sum[:])))
+}
@@ -0,0 +1,375 @@
+package provider
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func newTestPublicKeyPEM(t *testing.T, pub crypto.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func TestValidateVerifySignature(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	publicKeyPEM := newTestPublicKeyPEM(t, ecKey.Public())
+
+	cases := []struct {
+		desc        string
+		object      KeyVaultObject
+		expectedErr error
+	}{
+		{
+			desc:        "verification not configured",
+			object:      KeyVaultObject{ObjectType: VaultObjectTypeSecret},
+			expectedErr: nil,
+		},
+		{
+			desc:        "signature options without algorithm",
+			object:      KeyVaultObject{ObjectType: VaultObjectTypeSecret, SignatureTag: "signature"},
+			expectedErr: fmt.Errorf("signatureSecretName, signatureTag, signatureKeyName and signaturePublicKeyPEM require verifySignature to be set"),
+		},
+		{
+			desc:        "invalid algorithm",
+			object:      KeyVaultObject{ObjectType: VaultObjectTypeSecret, VerifySignature: "HS256"},
+			expectedErr: fmt.Errorf("invalid verifySignature: HS256, should be RS256, ES256 or PS256"),
+		},
+		{
+			desc:        "object type cert",
+			object:      KeyVaultObject{ObjectType: VaultObjectTypeCertificate, VerifySignature: "ES256"},
+			expectedErr: fmt.Errorf("verifySignature only supported for objectType: secret"),
+		},
+		{
+			desc:        "no signature source",
+			object:      KeyVaultObject{ObjectType: VaultObjectTypeSecret, VerifySignature: "ES256", SignatureKeyName: "release-key"},
+			expectedErr: fmt.Errorf("either signatureSecretName or signatureTag should be set"),
+		},
+		{
+			desc:        "both signature sources",
+			object:      KeyVaultObject{ObjectType: VaultObjectTypeSecret, VerifySignature: "ES256", SignatureSecretName: "flags-sig", SignatureTag: "signature", SignatureKeyName: "release-key"},
+			expectedErr: fmt.Errorf("only one of signatureSecretName or signatureTag can be set"),
+		},
+		{
+			desc:        "no key",
+			object:      KeyVaultObject{ObjectType: VaultObjectTypeSecret, VerifySignature: "ES256", SignatureTag: "signature"},
+			expectedErr: fmt.Errorf("either signatureKeyName or signaturePublicKeyPEM should be set"),
+		},
+		{
+			desc:        "both keys",
+			object:      KeyVaultObject{ObjectType: VaultObjectTypeSecret, VerifySignature: "ES256", SignatureTag: "signature", SignatureKeyName: "release-key", SignaturePublicKeyPEM: publicKeyPEM},
+			expectedErr: fmt.Errorf("only one of signatureKeyName or signaturePublicKeyPEM can be set"),
+		},
+		{
+			desc:        "invalid public key",
+			object:      KeyVaultObject{ObjectType: VaultObjectTypeSecret, VerifySignature: "ES256", SignatureTag: "signature", SignaturePublicKeyPEM: strings.Replace(publicKeyPEM, "PUBLIC KEY", "CERTIFICATE", 2)},
+			expectedErr: fmt.Errorf("failed to parse signaturePublicKeyPEM, should be a PEM encoded PUBLIC KEY"),
+		},
+		{
+			desc:        "valid pinned public key",
+			object:      KeyVaultObject{ObjectType: VaultObjectTypeSecret, VerifySignature: "es256", SignatureSecretName: "flags-sig", SignaturePublicKeyPEM: publicKeyPEM},
+			expectedErr: nil,
+		},
+		{
+			desc:        "valid key vault key",
+			object:      KeyVaultObject{ObjectType: VaultObjectTypeSecret, VerifySignature: "PS256", SignatureTag: "signature", SignatureKeyName: "release-key"},
+			expectedErr: nil,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := validateVerifySignature(tc.object)
+			if tc.expectedErr != nil && (err == nil || err.Error() != tc.expectedErr.Error()) || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+
+	content := []byte(`{"new-checkout": true}`)
+	digest := sha256.Sum256(content)
+	rs256, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	// Key Vault signs PS256 with the salt length equal to the hash length
+	ps256, err := rsa.SignPSS(rand.Reader, rsaKey, crypto.SHA256, digest[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	es256ASN1, err := ecdsa.SignASN1(rand.Reader, ecKey, digest[:])
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	r, s, err := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	es256JWS := make([]byte, 64)
+	r.FillBytes(es256JWS[:32])
+	s.FillBytes(es256JWS[32:])
+
+	cases := []struct {
+		desc        string
+		content     []byte
+		signature   string
+		alg         string
+		publicKey   crypto.PublicKey
+		expectedErr error
+	}{
+		{
+			desc:      "RS256 signature",
+			content:   content,
+			signature: base64.StdEncoding.EncodeToString(rs256) + "\n",
+			alg:       "RS256",
+			publicKey: rsaKey.Public(),
+		},
+		{
+			desc:      "PS256 signature encoded with base64url",
+			content:   content,
+			signature: base64.RawURLEncoding.EncodeToString(ps256),
+			alg:       "ps256",
+			publicKey: rsaKey.Public(),
+		},
+		{
+			desc:      "ES256 signature returned by Key Vault",
+			content:   content,
+			signature: base64.RawURLEncoding.EncodeToString(es256JWS),
+			alg:       "ES256",
+			publicKey: ecKey.Public(),
+		},
+		{
+			desc:      "ES256 signature in ASN.1",
+			content:   content,
+			signature: base64.StdEncoding.EncodeToString(es256ASN1),
+			alg:       "ES256",
+			publicKey: ecKey.Public(),
+		},
+		{
+			desc:        "modified content",
+			content:     []byte(`{"new-checkout": false}`),
+			signature:   base64.StdEncoding.EncodeToString(rs256),
+			alg:         "RS256",
+			publicKey:   rsaKey.Public(),
+			expectedErr: fmt.Errorf("signature doesn't match the content, error: crypto/rsa: verification error"),
+		},
+		{
+			desc:        "PS256 signature verified as RS256",
+			content:     content,
+			signature:   base64.StdEncoding.EncodeToString(ps256),
+			alg:         "RS256",
+			publicKey:   rsaKey.Public(),
+			expectedErr: fmt.Errorf("signature doesn't match the content, error: crypto/rsa: verification error"),
+		},
+		{
+			desc:        "modified ES256 content",
+			content:     []byte("other"),
+			signature:   base64.StdEncoding.EncodeToString(es256JWS),
+			alg:         "ES256",
+			publicKey:   ecKey.Public(),
+			expectedErr: fmt.Errorf("signature doesn't match the content, error: verification error"),
+		},
+		{
+			desc:        "RS256 with EC key",
+			content:     content,
+			signature:   base64.StdEncoding.EncodeToString(rs256),
+			alg:         "RS256",
+			publicKey:   ecKey.Public(),
+			expectedErr: fmt.Errorf("RS256 signature requires an RSA key"),
+		},
+		{
+			desc:        "ES256 with P-384 key",
+			content:     content,
+			signature:   base64.StdEncoding.EncodeToString(es256JWS),
+			alg:         "ES256",
+			publicKey:   p384Key.Public(),
+			expectedErr: fmt.Errorf("ES256 signature requires a P-256 EC key"),
+		},
+		{
+			desc:        "invalid signature encoding",
+			content:     content,
+			signature:   "not a signature!",
+			alg:         "ES256",
+			publicKey:   ecKey.Public(),
+			expectedErr: fmt.Errorf("failed to decode signature, should be base64 encoded"),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := verifySignature(tc.content, tc.signature, tc.alg, tc.publicKey)
+			if tc.expectedErr != nil && (err == nil || err.Error() != tc.expectedErr.Error()) || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestVerifySignatureBeforeContentType(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	// the JSON secret is signed as it's stored in Key Vault, the pretty printed content differs
+	content := `{"new-checkout":true,"limit":10}`
+	digest := sha256.Sum256([]byte(content))
+	rs256, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	signature := base64.StdEncoding.EncodeToString(rs256)
+	kvObject := KeyVaultObject{ObjectName: "secret1", ObjectType: "secret", VerifySignature: "RS256", SignatureTag: "signature"}
+
+	if err := verifySignature([]byte(content), signature, kvObject.VerifySignature, rsaKey.Public()); err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	p := &Provider{}
+	converted := p.getContentTypeContent(kvObject, to.StringPtr("application/json"), content)
+	if expected := "{\n  \"new-checkout\": true,\n  \"limit\": 10\n}\n"; converted != expected {
+		t.Fatalf("expected content: %q, got: %q", expected, converted)
+	}
+	if err := verifySignature([]byte(converted), signature, kvObject.VerifySignature, rsaKey.Public()); err == nil {
+		t.Fatalf("expected the signature not to match the converted content")
+	}
+}
+
+func TestGetPublicKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	for _, pub := range []crypto.PublicKey{rsaKey.Public(), ecKey.Public()} {
+		actual, err := getPublicKey(newTestJSONWebKey(t, pub, "https://myvault.vault.azure.net/keys/release-key/v1"))
+		if err != nil {
+			t.Fatalf("expected nil err, got: %v", err)
+		}
+		if !pub.(interface{ Equal(crypto.PublicKey) bool }).Equal(actual) {
+			t.Fatalf("expected public key %v, got: %v", pub, actual)
+		}
+	}
+}
+
+func TestValidateCertificateThumbprints(t *testing.T) {
+	cases := []struct {
+		desc        string
+		object      KeyVaultObject
+		expectedErr error
+	}{
+		{
+			desc:        "thumbprints not set",
+			object:      KeyVaultObject{ObjectType: VaultObjectTypeKey},
+			expectedErr: nil,
+		},
+		{
+			desc:        "object type key",
+			object:      KeyVaultObject{ObjectType: VaultObjectTypeKey, CertificateThumbprints: strings.Repeat("ab", 20)},
+			expectedErr: fmt.Errorf("certificateThumbprints only supported for objectType: cert, secret"),
+		},
+		{
+			desc:        "invalid length",
+			object:      KeyVaultObject{ObjectType: VaultObjectTypeCertificate, CertificateThumbprints: strings.Repeat("ab", 16)},
+			expectedErr: fmt.Errorf("invalid certificate thumbprint: %s, should be a hex encoded SHA-1 thumbprint or SHA-256 fingerprint", strings.Repeat("ab", 16)),
+		},
+		{
+			desc:        "not hex encoded",
+			object:      KeyVaultObject{ObjectType: VaultObjectTypeCertificate, CertificateThumbprints: strings.Repeat("zz", 20)},
+			expectedErr: fmt.Errorf("invalid certificate thumbprint: %s, should be a hex encoded SHA-1 thumbprint or SHA-256 fingerprint", strings.Repeat("zz", 20)),
+		},
+		{
+			desc:        "thumbprint and fingerprint with colons",
+			object:      KeyVaultObject{ObjectType: VaultObjectTypeSecret, CertificateThumbprints: strings.Repeat("AB", 20) + ", " + strings.TrimSuffix(strings.Repeat("ab:", 32), ":")},
+			expectedErr: nil,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := validateCertificateThumbprints(tc.object)
+			if tc.expectedErr != nil && (err == nil || err.Error() != tc.expectedErr.Error()) || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestMatchCertificateThumbprint(t *testing.T) {
+	ca := newTestCA(t, "Test Root CA", nil)
+	leaf := newTestLeaf(t, "contoso.com", ca)
+	other := newTestLeaf(t, "fabrikam.com", ca)
+	sha1Sum := sha1.Sum(leaf.cert.Raw)
+	sha256Sum := sha256.Sum256(leaf.cert.Raw)
+	otherSum := sha256.Sum256(other.cert.Raw)
+	chain := leaf.pem() + ca.pem()
+
+	cases := []struct {
+		desc        string
+		pinned      string
+		expectedErr error
+	}{
+		{
+			desc:   "SHA-1 thumbprint",
+			pinned: strings.ToUpper(hex.EncodeToString(sha1Sum[:])),
+		},
+		{
+			desc:   "SHA-256 fingerprint among pinned fingerprints",
+			pinned: hex.EncodeToString(otherSum[:]) + "," + hex.EncodeToString(sha256Sum[:]),
+		},
+		{
+			desc:        "fingerprint of another certificate",
+			pinned:      hex.EncodeToString(otherSum[:]),
+			expectedErr: fmt.Errorf("certificate %q with SHA-256 fingerprint %s doesn't match the pinned thumbprints", "CN=contoso.com", strings.ToUpper(hex.EncodeToString(sha256Sum[:]))),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := matchCertificateThumbprint([]byte(chain), tc.pinned)
+			if tc.expectedErr != nil && (err == nil || err.Error() != tc.expectedErr.Error()) || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+		})
+	}
+	// the pinned thumbprint of the issuer doesn't match the leaf
+	caSum := sha256.Sum256(ca.cert.Raw)
+	if err := matchCertificateThumbprint([]byte(chain), hex.EncodeToString(caSum[:])); err == nil {
+		t.Fatalf("expected mismatch for the thumbprint of the issuer, got nil err")
+	}
+}
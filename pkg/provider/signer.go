@@ -0,0 +1,168 @@
+package provider
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+
+	kv "github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+	"github.com/Azure/go-autorest/autorest/to"
+	"golang.org/x/net/context"
+)
+
+// signFunc signs the digest with the signature algorithm and returns the signature in the
+// JWS encoding returned by Key Vault. The ECDSA signatures are the r and s concatenated.
+type signFunc func(ctx context.Context, alg string, digest []byte) ([]byte, error)
+
+// signingHashes are the hashes of the digests signed with the supported signature algorithms
+var signingHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"PS256": crypto.SHA256,
+	"PS384": crypto.SHA384,
+	"PS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+}
+
+// validateSigningAlgorithm checks if the signature algorithm is supported
+func validateSigningAlgorithm(alg string) error {
+	if _, ok := signingHashes[strings.ToUpper(alg)]; !ok {
+		return fmt.Errorf("invalid signingAlgorithm: %v, should be RS256, RS384, RS512, PS256, PS384, PS512, ES256, ES384 or ES512", alg)
+	}
+	return nil
+}
+
+// getSigningAlgorithm returns the configured signature algorithm, or the default algorithm
+// of the key type and curve of the Key Vault key if not set
+func getSigningAlgorithm(alg string, key kv.JSONWebKey) (string, error) {
+	if len(alg) > 0 {
+		return strings.ToUpper(alg), nil
+	}
+	switch key.Kty {
+	case kv.RSA, kv.RSAHSM:
+		return "RS256", nil
+	case kv.EC, kv.ECHSM:
+		switch key.Crv {
+		case kv.P256:
+			return "ES256", nil
+		case kv.P384:
+			return "ES384", nil
+		case kv.P521:
+			return "ES512", nil
+		}
+		return "", fmt.Errorf("curve %s is not supported for signing", key.Crv)
+	default:
+		return "", fmt.Errorf("key type '%s' is not supported for signing", key.Kty)
+	}
+}
+
+// getSigningDigest returns the digest of the data for the signature algorithm
+func getSigningDigest(alg string, data []byte) ([]byte, error) {
+	hash, ok := signingHashes[strings.ToUpper(alg)]
+	if !ok {
+		return nil, validateSigningAlgorithm(alg)
+	}
+	h := hash.New()
+	h.Write(data)
+	return h.Sum(nil), nil
+}
+
+// getKeyVaultSigner returns the JSON Web Key of the version of the Key Vault key, the latest
+// version if not set, and the function signing digests with that version of the key
+func (p *Provider) getKeyVaultSigner(ctx context.Context, kvClient *kv.BaseClient, vaultURL, keyName, keyVersion string) (*kv.JSONWebKey, signFunc, error) {
+	keybundle, err := kvClient.GetKey(ctx, vaultURL, keyName, keyVersion)
+	if err != nil {
+		return nil, nil, wrapObjectTypeError(err, VaultObjectTypeKey, keyName, keyVersion)
+	}
+	if keybundle.Key == nil || keybundle.Key.Kid == nil {
+		return nil, nil, fmt.Errorf("key %s value is nil", keyName)
+	}
+	// the digests are signed with the version of the key returned, so the signature
+	// matches the public key even if the key is rotated in between
+	version := getObjectVersion(*keybundle.Key.Kid)
+	sign := func(ctx context.Context, alg string, digest []byte) ([]byte, error) {
+		result, err := kvClient.Sign(ctx, vaultURL, keyName, version, kv.KeySignParameters{
+			Algorithm: kv.JSONWebKeySignatureAlgorithm(alg),
+			Value:     to.StringPtr(base64.RawURLEncoding.EncodeToString(digest)),
+		})
+		if err != nil {
+			return nil, wrapObjectTypeError(err, VaultObjectTypeKey, keyName, version)
+		}
+		if result.Result == nil {
+			return nil, fmt.Errorf("signature is nil")
+		}
+		signature, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(*result.Result, "="))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode signature, error: %w", err)
+		}
+		return signature, nil
+	}
+	return keybundle.Key, sign, nil
+}
+
+// cryptoSigner implements crypto.Signer with the sign function of a Key Vault key, so the
+// Key Vault keys can sign the certificates built with the standard library
+type cryptoSigner struct {
+	ctx    context.Context
+	public crypto.PublicKey
+	sign   signFunc
+}
+
+// newCryptoSigner returns the crypto.Signer signing with the sign function of the key
+func newCryptoSigner(ctx context.Context, key kv.JSONWebKey, sign signFunc) (crypto.Signer, error) {
+	public, err := getPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &cryptoSigner{ctx: ctx, public: public, sign: sign}, nil
+}
+
+// Public returns the public key of the Key Vault key
+func (s *cryptoSigner) Public() crypto.PublicKey {
+	return s.public
+}
+
+// Sign signs the digest with the signature algorithm of the key type and hash, the
+// ECDSA signatures are returned ASN.1 encoded as expected by the standard library
+func (s *cryptoSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var size string
+	switch opts.HashFunc() {
+	case crypto.SHA256:
+		size = "256"
+	case crypto.SHA384:
+		size = "384"
+	case crypto.SHA512:
+		size = "512"
+	default:
+		return nil, fmt.Errorf("hash %v is not supported for signing", opts.HashFunc())
+	}
+	switch s.public.(type) {
+	case *rsa.PublicKey:
+		alg := "RS" + size
+		if _, ok := opts.(*rsa.PSSOptions); ok {
+			alg = "PS" + size
+		}
+		return s.sign(s.ctx, alg, digest)
+	case *ecdsa.PublicKey:
+		signature, err := s.sign(s.ctx, "ES"+size, digest)
+		if err != nil {
+			return nil, err
+		}
+		half := len(signature) / 2
+		return asn1.Marshal(struct{ R, S *big.Int }{
+			R: new(big.Int).SetBytes(signature[:half]),
+			S: new(big.Int).SetBytes(signature[half:]),
+		})
+	default:
+		return nil, fmt.Errorf("key type %T is not supported for signing", s.public)
+	}
+}
@@ -0,0 +1,173 @@
+package provider
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"strings"
+	"testing"
+
+	kv "github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+	"golang.org/x/net/context"
+)
+
+// newTestSignFunc returns a sign function signing with the local key like the Key Vault
+// sign operation, the ECDSA signatures are returned in the JWS encoding
+func newTestSignFunc(key crypto.Signer) signFunc {
+	return func(ctx context.Context, alg string, digest []byte) ([]byte, error) {
+		hash := signingHashes[alg]
+		switch key := key.(type) {
+		case *rsa.PrivateKey:
+			if strings.HasPrefix(alg, "PS") {
+				return rsa.SignPSS(rand.Reader, key, hash, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+			}
+			return rsa.SignPKCS1v15(rand.Reader, key, hash, digest)
+		case *ecdsa.PrivateKey:
+			r, s, err := ecdsa.Sign(rand.Reader, key, digest)
+			if err != nil {
+				return nil, err
+			}
+			size := (key.Curve.Params().BitSize + 7) / 8
+			signature := make([]byte, 2*size)
+			r.FillBytes(signature[:size])
+			s.FillBytes(signature[size:])
+			return signature, nil
+		default:
+			return nil, fmt.Errorf("unexpected key type %T", key)
+		}
+	}
+}
+
+func TestValidateSigningAlgorithm(t *testing.T) {
+	cases := []struct {
+		desc        string
+		alg         string
+		expectedErr error
+	}{
+		{desc: "RS256", alg: "RS256"},
+		{desc: "lower case PS384", alg: "ps384"},
+		{desc: "ES512", alg: "ES512"},
+		{
+			desc:        "HS256",
+			alg:         "HS256",
+			expectedErr: fmt.Errorf("invalid signingAlgorithm: HS256, should be RS256, RS384, RS512, PS256, PS384, PS512, ES256, ES384 or ES512"),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := validateSigningAlgorithm(tc.alg)
+			if tc.expectedErr != nil && (err == nil || err.Error() != tc.expectedErr.Error()) || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestGetSigningAlgorithm(t *testing.T) {
+	cases := []struct {
+		desc        string
+		alg         string
+		key         kv.JSONWebKey
+		expected    string
+		expectedErr error
+	}{
+		{desc: "configured algorithm", alg: "ps256", key: kv.JSONWebKey{Kty: kv.RSA}, expected: "PS256"},
+		{desc: "RSA HSM key", key: kv.JSONWebKey{Kty: kv.RSAHSM}, expected: "RS256"},
+		{desc: "P-256 key", key: kv.JSONWebKey{Kty: kv.EC, Crv: kv.P256}, expected: "ES256"},
+		{desc: "P-384 key", key: kv.JSONWebKey{Kty: kv.ECHSM, Crv: kv.P384}, expected: "ES384"},
+		{desc: "P-521 key", key: kv.JSONWebKey{Kty: kv.EC, Crv: kv.P521}, expected: "ES512"},
+		{
+			desc:        "secp256k1 key",
+			key:         kv.JSONWebKey{Kty: kv.EC, Crv: kv.SECP256K1},
+			expectedErr: fmt.Errorf("curve SECP256K1 is not supported for signing"),
+		},
+		{
+			desc:        "symmetric key",
+			key:         kv.JSONWebKey{Kty: kv.Oct},
+			expectedErr: fmt.Errorf("key type 'oct' is not supported for signing"),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			actual, err := getSigningAlgorithm(tc.alg, tc.key)
+			if tc.expectedErr != nil && (err == nil || err.Error() != tc.expectedErr.Error()) || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+			if actual != tc.expected {
+				t.Fatalf("expected: %s, got: %s", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestCryptoSigner(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	digest256 := sha256.Sum256([]byte("data"))
+	digest384 := sha512.Sum384([]byte("data"))
+
+	cases := []struct {
+		desc   string
+		key    crypto.Signer
+		digest []byte
+		opts   crypto.SignerOpts
+		verify func(signature []byte) bool
+	}{
+		{
+			desc:   "RSA PKCS#1 v1.5",
+			key:    rsaKey,
+			digest: digest256[:],
+			opts:   crypto.SHA256,
+			verify: func(signature []byte) bool {
+				return rsa.VerifyPKCS1v15(&rsaKey.PublicKey, crypto.SHA256, digest256[:], signature) == nil
+			},
+		},
+		{
+			desc:   "RSA PSS",
+			key:    rsaKey,
+			digest: digest256[:],
+			opts:   &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256},
+			verify: func(signature []byte) bool {
+				return rsa.VerifyPSS(&rsaKey.PublicKey, crypto.SHA256, digest256[:], signature, nil) == nil
+			},
+		},
+		{
+			desc:   "ECDSA P-384",
+			key:    ecKey,
+			digest: digest384[:],
+			opts:   crypto.SHA384,
+			verify: func(signature []byte) bool {
+				return ecdsa.VerifyASN1(&ecKey.PublicKey, digest384[:], signature)
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			signer, err := newCryptoSigner(context.TODO(), newTestJSONWebKey(t, tc.key.Public(), "https://myvault.vault.azure.net/keys/ca/v1"), newTestSignFunc(tc.key))
+			if err != nil {
+				t.Fatalf("expected nil err, got: %v", err)
+			}
+			signature, err := signer.Sign(rand.Reader, tc.digest, tc.opts)
+			if err != nil {
+				t.Fatalf("expected nil err, got: %v", err)
+			}
+			if !tc.verify(signature) {
+				t.Fatalf("expected valid signature")
+			}
+		})
+	}
+}
@@ -0,0 +1,627 @@
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	kv "github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+	"github.com/Azure/go-autorest/autorest/to"
+	"golang.org/x/net/context"
+	"gopkg.in/yaml.v2"
+	"k8s.io/klog/v2"
+)
+
+const (
+	objectFormatYAML = "yaml"
+
+	// sopsMetadataKey is the key of the SOPS metadata in JSON and YAML documents
+	sopsMetadataKey = "sops"
+	// sopsDotenvPrefix is the prefix of the flattened SOPS metadata keys in dotenv documents
+	sopsDotenvPrefix = "sops_"
+)
+
+// sopsMACOnlyEncryptedInitialization is written to the MAC hash before the values when
+// only the encrypted values are authenticated, so the MAC is different from the MAC of
+// all the values
+var sopsMACOnlyEncryptedInitialization = []byte{0x8a, 0x3f, 0xd2, 0xad, 0x54, 0xce, 0x66, 0x52, 0x7b, 0x10, 0x34, 0xf3, 0xd1, 0x47, 0xbe, 0x0b, 0x0b, 0x97, 0x5b, 0x3b, 0xf4, 0x4f, 0x72, 0xc6, 0xfd, 0xad, 0xec, 0x81, 0x76, 0xf2, 0x7d, 0x69}
+
+var (
+	// sopsEncryptedValue matches a value encrypted by SOPS
+	sopsEncryptedValue = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.*),tag:(.*),type:(.*)\]$`)
+	// sopsDotenvMAC matches the MAC line of a SOPS dotenv document
+	sopsDotenvMAC = regexp.MustCompile(`(?m)^` + sopsDotenvPrefix + `mac=`)
+)
+
+// sopsMetadata is the part of the SOPS metadata needed to decrypt the document
+type sopsMetadata struct {
+	AzureKV          []sopsAzureKVKey `json:"azure_kv"`
+	KeyGroups        []interface{}    `json:"key_groups"`
+	MAC              string           `json:"mac"`
+	LastModified     string           `json:"lastmodified"`
+	MACOnlyEncrypted interface{}      `json:"mac_only_encrypted"`
+}
+
+// sopsAzureKVKey is an Azure Key Vault key the SOPS data key is wrapped with
+type sopsAzureKVKey struct {
+	VaultURL string `json:"vault_url"`
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	// the base64url encoded wrapped data key
+	Enc string `json:"enc"`
+}
+
+// sopsUnwrapFunc unwraps the SOPS data key with the Azure Key Vault key
+type sopsUnwrapFunc func(ctx context.Context, key sopsAzureKVKey) ([]byte, error)
+
+// isSopsFormat returns true if the format is one of the formats of SOPS documents
+func isSopsFormat(objectFormat string) bool {
+	return strings.EqualFold(objectFormat, objectFormatJSON) || strings.EqualFold(objectFormat, objectFormatYAML) || strings.EqualFold(objectFormat, objectFormatDotenv)
+}
+
+// validateSops checks if the SOPS options are valid for the given object
+func validateSops(kvObject KeyVaultObject) error {
+	if kvObject.ObjectType != VaultObjectTypeSops {
+		if len(kvObject.SopsDocument) > 0 {
+			return fmt.Errorf("sopsDocument only supported for objectType: sops")
+		}
+		return nil
+	}
+	if len(kvObject.SopsDocument) > 0 && len(kvObject.ObjectVersion) > 0 {
+		return fmt.Errorf("objectVersion not supported with sopsDocument")
+	}
+	return nil
+}
+
+// getSopsContent decrypts the SOPS document read from the secret, or set inline with
+// sopsDocument, and returns it with the version of the secret. The version of an inline
+// document is derived from its last modified time and MAC.
+func (p *Provider) getSopsContent(ctx context.Context, kvClient *kv.BaseClient, kvObject KeyVaultObject) (string, string, error) {
+	document, version := kvObject.SopsDocument, ""
+	if len(document) == 0 {
+		var err error
+		if document, version, err = p.getSecretValue(ctx, kvObject.ObjectName, kvObject.ObjectVersion); err != nil {
+			return "", "", err
+		}
+	}
+	unwrap := func(ctx context.Context, key sopsAzureKVKey) ([]byte, error) {
+		vaultURL, err := p.getSopsVaultURL(key.VaultURL)
+		if err != nil {
+			return nil, err
+		}
+		result, err := kvClient.UnwrapKey(ctx, vaultURL, key.Name, key.Version, kv.KeyOperationsParameters{
+			Algorithm: kv.RSAOAEP256,
+			Value:     to.StringPtr(key.Enc),
+		})
+		if err != nil {
+			return nil, wrapObjectTypeError(err, VaultObjectTypeKey, key.Name, key.Version)
+		}
+		if result.Result == nil {
+			return nil, fmt.Errorf("unwrapped key is nil")
+		}
+		return base64.RawURLEncoding.DecodeString(strings.TrimRight(*result.Result, "="))
+	}
+	content, metadata, err := decryptSopsDocument(ctx, document, kvObject.ObjectFormat, unwrap)
+	if err != nil {
+		return "", "", err
+	}
+	klog.InfoS("decrypted SOPS document", "objectName", kvObject.ObjectName, "pod", klog.ObjectRef{Namespace: p.PodNamespace, Name: p.PodName})
+	if len(version) == 0 {
+		version = getCompositeVersion([]string{metadata.LastModified, metadata.MAC})
+	}
+	return string(content), version, nil
+}
+
+// getSopsVaultURL returns the vault URL of the SOPS key. Only vaults in the Azure cloud of
+// the provider are called, so the access token isn't sent to any other host.
+func (p *Provider) getSopsVaultURL(vaultURL string) (string, error) {
+	u, err := url.Parse(vaultURL)
+	if err != nil || u.Scheme != "https" || !strings.HasSuffix(strings.ToLower(u.Hostname()), "."+p.AzureCloudEnvironment.KeyVaultDNSSuffix) {
+		return "", fmt.Errorf("vault_url %s of the SOPS key is not a Key Vault in the %s cloud", vaultURL, p.AzureCloudEnvironment.Name)
+	}
+	return "https://" + u.Host + "/", nil
+}
+
+// decryptSopsDocument unwraps the data key of the SOPS document with the first Azure Key
+// Vault key that succeeds, decrypts the values, verifies the MAC and returns the decrypted
+// document without the SOPS metadata in its format
+func decryptSopsDocument(ctx context.Context, document, format string, unwrap sopsUnwrapFunc) ([]byte, *sopsMetadata, error) {
+	if len(format) == 0 {
+		format = detectSopsFormat(document)
+	}
+	format = strings.ToLower(format)
+	tree, metadata, err := parseSopsDocument(document, format)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(metadata.KeyGroups) > 0 {
+		return nil, nil, fmt.Errorf("SOPS documents with key_groups are not supported")
+	}
+	if len(metadata.AzureKV) == 0 {
+		return nil, nil, fmt.Errorf("SOPS document has no azure_kv key")
+	}
+
+	var dataKey []byte
+	var unwrapErrs []string
+	for _, key := range metadata.AzureKV {
+		if dataKey, err = unwrap(ctx, key); err == nil {
+			break
+		}
+		unwrapErrs = append(unwrapErrs, fmt.Sprintf("%s/keys/%s/%s: %v", strings.TrimSuffix(key.VaultURL, "/"), key.Name, key.Version, err))
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to unwrap SOPS data key with the azure_kv keys, error: %s", strings.Join(unwrapErrs, "; "))
+	}
+
+	hash := sha512.New()
+	macOnlyEncrypted := isTrue(metadata.MACOnlyEncrypted)
+	if macOnlyEncrypted {
+		hash.Write(sopsMACOnlyEncryptedInitialization)
+	}
+	decrypted, err := decryptSopsTree(tree, nil, dataKey, hash, macOnlyEncrypted)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := verifySopsMAC(metadata, dataKey, fmt.Sprintf("%X", hash.Sum(nil))); err != nil {
+		return nil, nil, err
+	}
+	content, err := encodeSopsTree(decrypted.(yaml.MapSlice), format)
+	if err != nil {
+		return nil, nil, err
+	}
+	return content, metadata, nil
+}
+
+// detectSopsFormat returns the format of the SOPS document
+func detectSopsFormat(document string) string {
+	trimmed := strings.TrimSpace(document)
+	switch {
+	case strings.HasPrefix(trimmed, "{"):
+		return objectFormatJSON
+	case sopsDotenvMAC.MatchString(trimmed):
+		return objectFormatDotenv
+	default:
+		return objectFormatYAML
+	}
+}
+
+// parseSopsDocument parses the document keeping the order of the keys, as the MAC is
+// computed over the values in the order of the document, and returns the tree without
+// the SOPS metadata
+func parseSopsDocument(document, format string) (yaml.MapSlice, *sopsMetadata, error) {
+	var tree yaml.MapSlice
+	var rawMetadata interface{}
+	switch format {
+	case objectFormatJSON:
+		decoder := json.NewDecoder(strings.NewReader(document))
+		decoder.UseNumber()
+		value, err := decodeOrderedJSON(decoder)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse SOPS document as json, error: %w", err)
+		}
+		var ok bool
+		if tree, ok = value.(yaml.MapSlice); !ok {
+			return nil, nil, fmt.Errorf("SOPS document should contain an object")
+		}
+	case objectFormatYAML:
+		if err := yaml.Unmarshal([]byte(document), &tree); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse SOPS document as yaml, error: %w", err)
+		}
+	case objectFormatDotenv:
+		var flattened yaml.MapSlice
+		var err error
+		if tree, flattened, err = parseSopsDotenv(document); err != nil {
+			return nil, nil, err
+		}
+		rawMetadata = unflattenSopsMetadata(flattened)
+	default:
+		return nil, nil, fmt.Errorf("invalid SOPS document format: %s, should be json, yaml or dotenv", format)
+	}
+
+	if format != objectFormatDotenv {
+		for i, item := range tree {
+			if key, ok := item.Key.(string); ok && key == sopsMetadataKey {
+				rawMetadata = toJSONValue(item.Value)
+				tree = append(tree[:i:i], tree[i+1:]...)
+				break
+			}
+		}
+	}
+	if rawMetadata == nil {
+		return nil, nil, fmt.Errorf("SOPS metadata not found in document")
+	}
+	data, err := json.Marshal(rawMetadata)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse SOPS metadata, error: %w", err)
+	}
+	metadata := &sopsMetadata{}
+	if err := json.Unmarshal(data, metadata); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse SOPS metadata, error: %w", err)
+	}
+	return tree, metadata, nil
+}
+
+// decodeOrderedJSON decodes the next JSON value with the objects decoded as yaml.MapSlice
+// to keep the order of their keys
+func decodeOrderedJSON(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token := token.(type) {
+	case json.Delim:
+		switch token {
+		case '{':
+			object := yaml.MapSlice{}
+			for decoder.More() {
+				keyToken, err := decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+				value, err := decodeOrderedJSON(decoder)
+				if err != nil {
+					return nil, err
+				}
+				object = append(object, yaml.MapItem{Key: keyToken.(string), Value: value})
+			}
+			_, err := decoder.Token()
+			return object, err
+		case '[':
+			array := []interface{}{}
+			for decoder.More() {
+				value, err := decodeOrderedJSON(decoder)
+				if err != nil {
+					return nil, err
+				}
+				array = append(array, value)
+			}
+			_, err := decoder.Token()
+			return array, err
+		}
+		return nil, fmt.Errorf("unexpected delimiter %s", token)
+	case json.Number:
+		if i, err := strconv.Atoi(token.String()); err == nil {
+			return i, nil
+		}
+		return token.Float64()
+	default:
+		return token, nil
+	}
+}
+
+// parseSopsDotenv parses the KEY=value lines of the dotenv document and returns the values
+// and the flattened SOPS metadata separately. Comments and empty lines are skipped.
+func parseSopsDotenv(document string) (yaml.MapSlice, yaml.MapSlice, error) {
+	var tree, metadata yaml.MapSlice
+	scanner := bufio.NewScanner(strings.NewReader(document))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(strings.TrimSpace(line)) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pos := strings.Index(line, "=")
+		if pos < 1 {
+			return nil, nil, fmt.Errorf("invalid line in SOPS dotenv document: %q", line)
+		}
+		key, value := line[:pos], strings.ReplaceAll(line[pos+1:], `\n`, "\n")
+		if strings.HasPrefix(key, sopsDotenvPrefix) {
+			metadata = append(metadata, yaml.MapItem{Key: strings.TrimPrefix(key, sopsDotenvPrefix), Value: value})
+			continue
+		}
+		tree = append(tree, yaml.MapItem{Key: key, Value: value})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read SOPS dotenv document, error: %w", err)
+	}
+	return tree, metadata, nil
+}
+
+// unflattenSopsMetadata rebuilds the SOPS metadata from the flattened keys of a dotenv
+// document, for example azure_kv__list_0__map_vault_url
+func unflattenSopsMetadata(flattened yaml.MapSlice) map[string]interface{} {
+	metadata := make(map[string]interface{})
+	for _, item := range flattened {
+		segments := strings.Split(item.Key.(string), "__")
+		var parent interface{} = metadata
+		for i, segment := range segments {
+			last := i == len(segments)-1
+			object, ok := parent.(map[string]interface{})
+			if !ok {
+				break
+			}
+			name := strings.TrimPrefix(segment, "map_")
+			if last {
+				object[name] = item.Value
+				break
+			}
+			// lists are represented as objects indexed by the position in the list
+			// and converted after all the keys are set
+			if _, ok := object[name]; !ok {
+				object[name] = make(map[string]interface{})
+			}
+			parent = object[name]
+		}
+	}
+	return convertFlattenedLists(metadata).(map[string]interface{})
+}
+
+// convertFlattenedLists converts the objects with list_N keys to lists
+func convertFlattenedLists(value interface{}) interface{} {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return value
+	}
+	var list []interface{}
+	for key, item := range object {
+		index, err := strconv.Atoi(strings.TrimPrefix(key, "list_"))
+		if !strings.HasPrefix(key, "list_") || err != nil || index < 0 || index >= len(object) {
+			list = nil
+			break
+		}
+		if list == nil {
+			list = make([]interface{}, len(object))
+		}
+		list[index] = convertFlattenedLists(item)
+	}
+	if list != nil {
+		return list
+	}
+	for key, item := range object {
+		object[key] = convertFlattenedLists(item)
+	}
+	return object
+}
+
+// toJSONValue converts the yaml.MapSlice objects to maps that can be encoded as JSON
+func toJSONValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case yaml.MapSlice:
+		object := make(map[string]interface{}, len(value))
+		for _, item := range value {
+			object[fmt.Sprint(item.Key)] = toJSONValue(item.Value)
+		}
+		return object
+	case []interface{}:
+		array := make([]interface{}, len(value))
+		for i, item := range value {
+			array[i] = toJSONValue(item)
+		}
+		return array
+	default:
+		return value
+	}
+}
+
+// isTrue returns true if the metadata value is the boolean or string true
+func isTrue(value interface{}) bool {
+	switch value := value.(type) {
+	case bool:
+		return value
+	case string:
+		b, _ := strconv.ParseBool(value)
+		return b
+	default:
+		return false
+	}
+}
+
+// decryptSopsTree decrypts the encrypted values of the tree and adds the values to the
+// MAC hash. The path of the value, with the keys joined by colons, is the additional
+// authenticated data of its encryption.
+func decryptSopsTree(value interface{}, path []string, dataKey []byte, hash io.Writer, macOnlyEncrypted bool) (interface{}, error) {
+	switch value := value.(type) {
+	case yaml.MapSlice:
+		object := make(yaml.MapSlice, len(value))
+		for i, item := range value {
+			key := fmt.Sprint(item.Key)
+			decrypted, err := decryptSopsTree(item.Value, append(path[:len(path):len(path)], key), dataKey, hash, macOnlyEncrypted)
+			if err != nil {
+				return nil, err
+			}
+			object[i] = yaml.MapItem{Key: item.Key, Value: decrypted}
+		}
+		return object, nil
+	case []interface{}:
+		// the items of a list have the path of the list
+		array := make([]interface{}, len(value))
+		for i, item := range value {
+			decrypted, err := decryptSopsTree(item, path, dataKey, hash, macOnlyEncrypted)
+			if err != nil {
+				return nil, err
+			}
+			array[i] = decrypted
+		}
+		return array, nil
+	}
+
+	if s, ok := value.(string); ok && sopsEncryptedValue.MatchString(s) {
+		decrypted, err := decryptSopsValue(s, dataKey, strings.Join(path, ":")+":")
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt value of %s, error: %w", strings.Join(path, ":"), err)
+		}
+		value = decrypted
+	} else if macOnlyEncrypted {
+		return value, nil
+	}
+	data, err := sopsValueBytes(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value of %s, error: %w", strings.Join(path, ":"), err)
+	}
+	if _, err := hash.Write(data); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// decryptSopsValue decrypts the ENC[AES256_GCM,...] value and converts it to its type
+func decryptSopsValue(value string, dataKey []byte, additionalData string) (interface{}, error) {
+	match := sopsEncryptedValue.FindStringSubmatch(value)
+	if match == nil {
+		return nil, fmt.Errorf("value is not encrypted by SOPS")
+	}
+	var parts [3][]byte
+	for i := range parts {
+		var err error
+		if parts[i], err = base64.StdEncoding.DecodeString(match[i+1]); err != nil {
+			return nil, fmt.Errorf("failed to decode encrypted value, error: %w", err)
+		}
+	}
+	data, iv, tag := parts[0], parts[1], parts[2]
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	// SOPS uses 32 byte nonces
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, iv, append(data, tag...), []byte(additionalData))
+	if err != nil {
+		return nil, err
+	}
+	switch valueType := match[4]; valueType {
+	case "str", "bytes", "comment":
+		return string(plaintext), nil
+	case "int":
+		return strconv.Atoi(string(plaintext))
+	case "float":
+		return strconv.ParseFloat(string(plaintext), 64)
+	case "bool":
+		return strconv.ParseBool(string(plaintext))
+	default:
+		return nil, fmt.Errorf("unknown type %s of encrypted value", valueType)
+	}
+}
+
+// sopsValueBytes returns the bytes of the value added to the MAC hash, matching SOPS
+func sopsValueBytes(value interface{}) ([]byte, error) {
+	switch value := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []byte(value), nil
+	case int:
+		return []byte(strconv.Itoa(value)), nil
+	case int64:
+		return []byte(strconv.FormatInt(value, 10)), nil
+	case uint64:
+		return []byte(strconv.FormatUint(value, 10)), nil
+	case float64:
+		return []byte(strconv.FormatFloat(value, 'f', -1, 64)), nil
+	case bool:
+		// SOPS hashes the capitalized boolean
+		if value {
+			return []byte("True"), nil
+		}
+		return []byte("False"), nil
+	default:
+		return nil, fmt.Errorf("unsupported value type %T", value)
+	}
+}
+
+// verifySopsMAC decrypts the MAC of the document, which is encrypted with the last
+// modified time as additional authenticated data, and compares it to the computed MAC
+func verifySopsMAC(metadata *sopsMetadata, dataKey []byte, computedMAC string) error {
+	if len(metadata.MAC) == 0 {
+		return fmt.Errorf("SOPS document has no mac")
+	}
+	lastModified, err := time.Parse(time.RFC3339, metadata.LastModified)
+	if err != nil {
+		return fmt.Errorf("failed to parse lastmodified of SOPS document, error: %w", err)
+	}
+	mac, err := decryptSopsValue(metadata.MAC, dataKey, lastModified.Format(time.RFC3339))
+	if err != nil {
+		return fmt.Errorf("failed to decrypt mac of SOPS document, error: %w", err)
+	}
+	if s, ok := mac.(string); !ok || !strings.EqualFold(s, computedMAC) {
+		return fmt.Errorf("mac of SOPS document doesn't match the content, the document was modified")
+	}
+	return nil
+}
+
+// encodeSopsTree encodes the decrypted document in its format
+func encodeSopsTree(tree yaml.MapSlice, format string) ([]byte, error) {
+	switch format {
+	case objectFormatYAML:
+		return yaml.Marshal(tree)
+	case objectFormatDotenv:
+		var buf bytes.Buffer
+		for _, item := range tree {
+			fmt.Fprintf(&buf, "%v=%s\n", item.Key, strings.ReplaceAll(fmt.Sprint(item.Value), "\n", `\n`))
+		}
+		return buf.Bytes(), nil
+	default:
+		var buf bytes.Buffer
+		if err := writeOrderedJSON(&buf, tree, ""); err != nil {
+			return nil, err
+		}
+		buf.WriteString("\n")
+		return buf.Bytes(), nil
+	}
+}
+
+// writeOrderedJSON writes the value as indented JSON keeping the order of the keys
+func writeOrderedJSON(buf *bytes.Buffer, value interface{}, indent string) error {
+	switch value := value.(type) {
+	case yaml.MapSlice:
+		if len(value) == 0 {
+			buf.WriteString("{}")
+			return nil
+		}
+		buf.WriteString("{\n")
+		for i, item := range value {
+			key, err := marshalJSON(fmt.Sprint(item.Key))
+			if err != nil {
+				return err
+			}
+			buf.WriteString(indent + "  ")
+			buf.Write(bytes.TrimSuffix(key, []byte("\n")))
+			buf.WriteString(": ")
+			if err := writeOrderedJSON(buf, item.Value, indent+"  "); err != nil {
+				return err
+			}
+			if i < len(value)-1 {
+				buf.WriteString(",")
+			}
+			buf.WriteString("\n")
+		}
+		buf.WriteString(indent + "}")
+	case []interface{}:
+		if len(value) == 0 {
+			buf.WriteString("[]")
+			return nil
+		}
+		buf.WriteString("[\n")
+		for i, item := range value {
+			buf.WriteString(indent + "  ")
+			if err := writeOrderedJSON(buf, item, indent+"  "); err != nil {
+				return err
+			}
+			if i < len(value)-1 {
+				buf.WriteString(",")
+			}
+			buf.WriteString("\n")
+		}
+		buf.WriteString(indent + "]")
+	default:
+		data, err := marshalJSON(value)
+		if err != nil {
+			return err
+		}
+		buf.Write(bytes.TrimSuffix(data, []byte("\n")))
+	}
+	return nil
+}
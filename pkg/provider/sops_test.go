@@ -0,0 +1,311 @@
+package provider
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+	"golang.org/x/net/context"
+	"gopkg.in/yaml.v2"
+)
+
+const testSopsLastModified = "2026-01-02T03:04:05Z"
+
+// testSopsEncrypt encrypts the value like SOPS with the path as additional authenticated data
+func testSopsEncrypt(t *testing.T, dataKey []byte, value interface{}, additionalData string) string {
+	var plaintext, valueType string
+	switch value := value.(type) {
+	case string:
+		plaintext, valueType = value, "str"
+	case int:
+		plaintext, valueType = strconv.Itoa(value), "int"
+	case float64:
+		plaintext, valueType = strconv.FormatFloat(value, 'f', -1, 64), "float"
+	case bool:
+		plaintext, valueType = strconv.FormatBool(value), "bool"
+	default:
+		t.Fatalf("unexpected value type %T", value)
+	}
+	iv := make([]byte, 32)
+	if _, err := rand.Read(iv); err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	sealed := gcm.Seal(nil, iv, []byte(plaintext), []byte(additionalData))
+	data, tag := sealed[:len(sealed)-16], sealed[len(sealed)-16:]
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:%s]", base64.StdEncoding.EncodeToString(data),
+		base64.StdEncoding.EncodeToString(iv), base64.StdEncoding.EncodeToString(tag), valueType)
+}
+
+// newTestSopsYAML encrypts the values of the yaml document like SOPS and returns the
+// encrypted document with the SOPS metadata of an azure_kv key
+func newTestSopsYAML(t *testing.T, dataKey []byte, document string) string {
+	var tree yaml.MapSlice
+	if err := yaml.Unmarshal([]byte(document), &tree); err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	hash := sha512.New()
+	var encrypt func(value interface{}, path []string) interface{}
+	encrypt = func(value interface{}, path []string) interface{} {
+		switch value := value.(type) {
+		case yaml.MapSlice:
+			for i, item := range value {
+				value[i].Value = encrypt(item.Value, append(path[:len(path):len(path)], item.Key.(string)))
+			}
+			return value
+		case []interface{}:
+			for i, item := range value {
+				value[i] = encrypt(item, path)
+			}
+			return value
+		}
+		data, err := sopsValueBytes(value)
+		if err != nil {
+			t.Fatalf("expected nil err, got: %v", err)
+		}
+		hash.Write(data)
+		return testSopsEncrypt(t, dataKey, value, strings.Join(path, ":")+":")
+	}
+	encrypt(tree, nil)
+	mac := testSopsEncrypt(t, dataKey, fmt.Sprintf("%X", hash.Sum(nil)), testSopsLastModified)
+	tree = append(tree, yaml.MapItem{Key: "sops", Value: yaml.MapSlice{
+		{Key: "azure_kv", Value: []interface{}{
+			yaml.MapSlice{{Key: "vault_url", Value: "https://myvault.vault.azure.net"}, {Key: "name", Value: "sops-key"}, {Key: "version", Value: "v1"}, {Key: "enc", Value: "d3JhcHBlZA"}},
+		}},
+		{Key: "lastmodified", Value: testSopsLastModified},
+		{Key: "mac", Value: mac},
+		{Key: "version", Value: "3.9.0"},
+	}})
+	encrypted, err := yaml.Marshal(tree)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	return string(encrypted)
+}
+
+func TestValidateSops(t *testing.T) {
+	cases := []struct {
+		desc        string
+		object      KeyVaultObject
+		expectedErr error
+	}{
+		{
+			desc:        "sops document from secret",
+			object:      KeyVaultObject{ObjectName: "secrets", ObjectType: "sops", ObjectVersion: "v1"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "inline sops document",
+			object:      KeyVaultObject{ObjectName: "secrets", ObjectType: "sops", SopsDocument: "a: b"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "sops document for object type secret",
+			object:      KeyVaultObject{ObjectName: "secrets", ObjectType: "secret", SopsDocument: "a: b"},
+			expectedErr: fmt.Errorf("sopsDocument only supported for objectType: sops"),
+		},
+		{
+			desc:        "inline sops document with object version",
+			object:      KeyVaultObject{ObjectName: "secrets", ObjectType: "sops", ObjectVersion: "v1", SopsDocument: "a: b"},
+			expectedErr: fmt.Errorf("objectVersion not supported with sopsDocument"),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := validateSops(tc.object)
+			if tc.expectedErr != nil && (err == nil || err.Error() != tc.expectedErr.Error()) || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestGetSopsVaultURL(t *testing.T) {
+	p := &Provider{AzureCloudEnvironment: &azure.PublicCloud}
+	cases := []struct {
+		desc        string
+		vaultURL    string
+		expected    string
+		expectedErr error
+	}{
+		{
+			desc:     "vault in the cloud of the provider",
+			vaultURL: "https://MyVault.vault.azure.net",
+			expected: "https://MyVault.vault.azure.net/",
+		},
+		{
+			desc:        "vault in another cloud",
+			vaultURL:    "https://myvault.vault.azure.cn/",
+			expectedErr: fmt.Errorf("vault_url https://myvault.vault.azure.cn/ of the SOPS key is not a Key Vault in the AzurePublicCloud cloud"),
+		},
+		{
+			desc:        "http vault url",
+			vaultURL:    "http://myvault.vault.azure.net",
+			expectedErr: fmt.Errorf("vault_url http://myvault.vault.azure.net of the SOPS key is not a Key Vault in the AzurePublicCloud cloud"),
+		},
+		{
+			desc:        "host ending with the vault domain",
+			vaultURL:    "https://attacker.example.com/vault.azure.net",
+			expectedErr: fmt.Errorf("vault_url https://attacker.example.com/vault.azure.net of the SOPS key is not a Key Vault in the AzurePublicCloud cloud"),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			actual, err := p.getSopsVaultURL(tc.vaultURL)
+			if tc.expectedErr != nil && (err == nil || err.Error() != tc.expectedErr.Error()) || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+			if actual != tc.expected {
+				t.Fatalf("expected: %s, got: %s", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestDecryptSopsDocument(t *testing.T) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	unwrap := func(ctx context.Context, key sopsAzureKVKey) ([]byte, error) {
+		if key.Name != "sops-key" || key.Version != "v1" || key.Enc != "d3JhcHBlZA" {
+			return nil, fmt.Errorf("key %s not found", key.Name)
+		}
+		return dataKey, nil
+	}
+
+	document := "db:\n  user: admin\n  password: p@ss\n  port: 5432\n  ratio: 0.5\n  tls: true\nhosts:\n- db1\n- db2\n"
+	encrypted := newTestSopsYAML(t, dataKey, document)
+	tampered := strings.Replace(encrypted, "  user: ", "  username: ", 1)
+	mac := func(value string) string {
+		return testSopsEncrypt(t, dataKey, value, testSopsLastModified)
+	}
+	jsonEncrypted := fmt.Sprintf(`{
+	"b": %q,
+	"a": {"n": %q, "l": [%q, "plain"]},
+	"sops": {"azure_kv": [
+		{"vault_url": "https://othervault.vault.azure.net", "name": "other-key", "version": "v1", "enc": "AAAA"},
+		{"vault_url": "https://myvault.vault.azure.net", "name": "sops-key", "version": "v1", "enc": "d3JhcHBlZA"}
+	], "lastmodified": %q, "mac": %q, "mac_only_encrypted": true}
+}`, testSopsEncrypt(t, dataKey, "x", "b:"), testSopsEncrypt(t, dataKey, 1, "a:n:"), testSopsEncrypt(t, dataKey, 1.25, "a:l:"), testSopsLastModified,
+		mac(fmt.Sprintf("%X", sha512.Sum512(append(sopsMACOnlyEncryptedInitialization, "x11.25"...)))))
+	dotenvEncrypted := fmt.Sprintf("USER=%s\nPASSWORD=%s\nsops_azure_kv__list_0__map_vault_url=https://myvault.vault.azure.net\nsops_azure_kv__list_0__map_name=sops-key\nsops_azure_kv__list_0__map_version=v1\nsops_azure_kv__list_0__map_enc=d3JhcHBlZA\nsops_lastmodified=%s\nsops_mac=%s\n",
+		testSopsEncrypt(t, dataKey, "admin", "USER:"), testSopsEncrypt(t, dataKey, "multi\nline", "PASSWORD:"), testSopsLastModified,
+		mac(fmt.Sprintf("%X", sha512.Sum512([]byte("adminmulti\nline")))))
+
+	cases := []struct {
+		desc            string
+		document        string
+		format          string
+		expectedContent string
+		expectedErr     error
+	}{
+		{
+			desc:            "yaml document",
+			document:        encrypted,
+			expectedContent: document,
+		},
+		{
+			desc:            "json document with the second azure_kv key and unencrypted values",
+			document:        jsonEncrypted,
+			format:          "JSON",
+			expectedContent: "{\n  \"b\": \"x\",\n  \"a\": {\n    \"n\": 1,\n    \"l\": [\n      1.25,\n      \"plain\"\n    ]\n  }\n}\n",
+		},
+		{
+			desc:            "dotenv document",
+			document:        dotenvEncrypted,
+			expectedContent: "USER=admin\nPASSWORD=multi\\nline\n",
+		},
+		{
+			desc:        "tampered document",
+			document:    tampered,
+			expectedErr: fmt.Errorf("failed to decrypt value of db:username, error: cipher: message authentication failed"),
+		},
+		{
+			desc:        "value removed from document",
+			document:    regexp.MustCompile(`(?m)^  port: .*\n`).ReplaceAllString(encrypted, ""),
+			expectedErr: fmt.Errorf("mac of SOPS document doesn't match the content, the document was modified"),
+		},
+		{
+			desc:        "no sops metadata",
+			document:    document,
+			expectedErr: fmt.Errorf("SOPS metadata not found in document"),
+		},
+		{
+			desc:        "no azure_kv key",
+			document:    "a: b\nsops:\n  mac: ENC[]\n",
+			expectedErr: fmt.Errorf("SOPS document has no azure_kv key"),
+		},
+		{
+			desc:        "key groups",
+			document:    "a: b\nsops:\n  key_groups:\n  - azure_kv: []\n",
+			expectedErr: fmt.Errorf("SOPS documents with key_groups are not supported"),
+		},
+		{
+			desc:        "no azure_kv key can be unwrapped",
+			document:    strings.Replace(encrypted, "name: sops-key", "name: other-key", 1),
+			expectedErr: fmt.Errorf("failed to unwrap SOPS data key with the azure_kv keys, error: https://myvault.vault.azure.net/keys/other-key/v1: key other-key not found"),
+		},
+		{
+			desc:        "invalid format",
+			document:    encrypted,
+			format:      "ini",
+			expectedErr: fmt.Errorf("invalid SOPS document format: ini, should be json, yaml or dotenv"),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			content, metadata, err := decryptSopsDocument(context.TODO(), tc.document, tc.format, unwrap)
+			if tc.expectedErr != nil && (err == nil || err.Error() != tc.expectedErr.Error()) || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+			if tc.expectedErr != nil {
+				return
+			}
+			if string(content) != tc.expectedContent {
+				t.Fatalf("expected content: %q, got: %q", tc.expectedContent, content)
+			}
+			if metadata.LastModified != testSopsLastModified {
+				t.Fatalf("expected lastmodified: %s, got: %s", testSopsLastModified, metadata.LastModified)
+			}
+		})
+	}
+}
+
+func TestVerifySopsMACLastModified(t *testing.T) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	// the mac is encrypted with the last modified time formatted in RFC 3339
+	lastModified := time.Date(2026, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600))
+	metadata := &sopsMetadata{
+		LastModified: lastModified.Format(time.RFC3339Nano),
+		MAC:          testSopsEncrypt(t, dataKey, "ABCD", lastModified.Format(time.RFC3339)),
+	}
+	if err := verifySopsMAC(metadata, dataKey, "abcd"); err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	if err := verifySopsMAC(metadata, dataKey, "ABCE"); err == nil {
+		t.Fatalf("expected mac mismatch, got nil err")
+	}
+}
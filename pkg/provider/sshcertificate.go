@@ -0,0 +1,254 @@
+package provider
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	kv "github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/context"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// defaultSSHCertificateLifetime is the lifetime of the SSH certificates if lifetime isn't set
+	defaultSSHCertificateLifetime = time.Hour
+	// maxSSHCertificateLifetime is the maximum lifetime of the SSH certificates
+	maxSSHCertificateLifetime = 24 * time.Hour
+	// defaultSSHCertificateKeyID is the key id of the SSH certificates if subject isn't set
+	defaultSSHCertificateKeyID = "{{ .PodNamespace }}/{{ .PodName }}"
+
+	sshCertificateTypeUser = "user"
+	sshCertificateTypeHost = "host"
+
+	// sshCertificateSuffix and sshCAPublicKeySuffix are appended to the file name of the
+	// private key for the files of the certificate and the CA public key. ssh loads the
+	// certificate of the identity file from <identity file>-cert.pub.
+	sshCertificateSuffix = "-cert.pub"
+	sshCAPublicKeySuffix = "-ca.pub"
+)
+
+// defaultSSHCertificateExtensions are the extensions of the user certificates if extensions
+// isn't set, the same as the defaults of ssh-keygen
+var defaultSSHCertificateExtensions = []string{"permit-X11-forwarding", "permit-agent-forwarding", "permit-port-forwarding", "permit-pty", "permit-user-rc"}
+
+// validateSSHCertificate checks if the SSH certificate options are valid for the given object
+func validateSSHCertificate(kvObject KeyVaultObject) error {
+	if kvObject.ObjectType != VaultObjectTypeSSHCertificate {
+		if len(kvObject.Principals) > 0 || len(kvObject.SSHCertificateType) > 0 || len(kvObject.Extensions) > 0 {
+			return fmt.Errorf("principals, sshCertificateType and extensions only supported for objectType: sshCertificate")
+		}
+		return nil
+	}
+	if len(kvObject.Principals) == 0 {
+		return fmt.Errorf("objectType: sshCertificate requires principals to be set")
+	}
+	if len(kvObject.SSHCertificateType) > 0 && !strings.EqualFold(kvObject.SSHCertificateType, sshCertificateTypeUser) && !strings.EqualFold(kvObject.SSHCertificateType, sshCertificateTypeHost) {
+		return fmt.Errorf("invalid sshCertificateType: %v, should be user or host", kvObject.SSHCertificateType)
+	}
+	if len(kvObject.Extensions) > 0 && strings.EqualFold(kvObject.SSHCertificateType, sshCertificateTypeHost) {
+		return fmt.Errorf("extensions only supported with sshCertificateType: user")
+	}
+	if err := validateKeyType(kvObject.KeyType); err != nil {
+		return err
+	}
+	// the ephemeral keys are ed25519 or ECDSA keys
+	if strings.EqualFold(kvObject.KeyType, keyTypeRSA) {
+		return fmt.Errorf("keyType: rsa not supported for objectType: sshCertificate")
+	}
+	// the templates are rendered with empty pod metadata to fail early
+	if _, err := getSSHCertificateTemplate(kvObject, podTemplateData{}, time.Now()); err != nil {
+		return err
+	}
+	return nil
+}
+
+// getSSHCertificateContent generates an ephemeral key pair and signs an OpenSSH certificate of
+// the public key with the Key Vault key objectName. The private key, certificate and CA public
+// key are returned in one content, split into separate files by getSSHCertificateFiles. The
+// version changes with every certificate, so the certificate is refreshed on each rotation poll.
+func (p *Provider) getSSHCertificateContent(ctx context.Context, kvClient *kv.BaseClient, vaultURL string, kvObject KeyVaultObject) (string, string, error) {
+	caKey, sign, err := p.getKeyVaultSigner(ctx, kvClient, vaultURL, kvObject.ObjectName, kvObject.ObjectVersion)
+	if err != nil {
+		return "", "", err
+	}
+	caSigner, err := newCryptoSigner(ctx, *caKey, sign)
+	if err != nil {
+		return "", "", err
+	}
+	cert, err := getSSHCertificateTemplate(kvObject, p.getPodTemplateData(), time.Now())
+	if err != nil {
+		return "", "", err
+	}
+	keyType := kvObject.KeyType
+	if len(keyType) == 0 {
+		keyType = keyTypeEd25519
+	}
+	key, err := generatePrivateKey(keyType)
+	if err != nil {
+		return "", "", err
+	}
+	content, err := signSSHCertificate(cert, key, caSigner)
+	if err != nil {
+		return "", "", err
+	}
+	klog.InfoS("signed SSH certificate", "objectName", kvObject.ObjectName, "keyId", cert.KeyId, "principals", cert.ValidPrincipals, "expiry", time.Unix(int64(cert.ValidBefore), 0).UTC(), "pod", klog.ObjectRef{Namespace: p.PodNamespace, Name: p.PodName})
+	return content, getCompositeVersion([]string{getObjectVersion(*caKey.Kid), strconv.FormatUint(cert.ValidBefore, 10)}), nil
+}
+
+// getSSHCertificateTemplate returns the certificate valid from now without the public key and
+// signature. The key id and principals are templated with the pod metadata.
+func getSSHCertificateTemplate(kvObject KeyVaultObject, pod podTemplateData, now time.Time) (*ssh.Certificate, error) {
+	lifetime, err := getLifetime(kvObject.Lifetime, defaultSSHCertificateLifetime, maxSSHCertificateLifetime)
+	if err != nil {
+		return nil, err
+	}
+	keyID := kvObject.Subject
+	if len(keyID) == 0 {
+		keyID = defaultSSHCertificateKeyID
+	}
+	if keyID, err = renderPodTemplate("certificate", "subject", keyID, pod); err != nil {
+		return nil, err
+	}
+	var principals []string
+	for _, principal := range splitList(kvObject.Principals) {
+		rendered, err := renderPodTemplate("certificate", "principals", principal, pod)
+		if err != nil {
+			return nil, err
+		}
+		principals = append(principals, rendered)
+	}
+
+	serial := make([]byte, 8)
+	if _, err := rand.Read(serial); err != nil {
+		return nil, err
+	}
+	cert := &ssh.Certificate{
+		Serial:          binary.BigEndian.Uint64(serial),
+		CertType:        ssh.UserCert,
+		KeyId:           keyID,
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(now.Unix()),
+		ValidBefore:     uint64(now.Add(lifetime).Unix()),
+	}
+	if strings.EqualFold(kvObject.SSHCertificateType, sshCertificateTypeHost) {
+		cert.CertType = ssh.HostCert
+		return cert, nil
+	}
+	extensions := splitList(kvObject.Extensions)
+	if len(extensions) == 0 {
+		extensions = defaultSSHCertificateExtensions
+	}
+	cert.Permissions.Extensions = make(map[string]string)
+	for _, extension := range extensions {
+		cert.Permissions.Extensions[extension] = ""
+	}
+	return cert, nil
+}
+
+// signSSHCertificate signs the certificate of the public key of the private key with the CA,
+// and returns the OpenSSH private key followed by the certificate and the CA public key in
+// the authorized_keys format
+func signSSHCertificate(cert *ssh.Certificate, key, caSigner crypto.Signer) (string, error) {
+	publicKey, err := ssh.NewPublicKey(key.Public())
+	if err != nil {
+		return "", err
+	}
+	cert.Key = publicKey
+	authority, err := ssh.NewSignerFromSigner(caSigner)
+	if err != nil {
+		return "", err
+	}
+	if err := cert.SignCert(rand.Reader, authority); err != nil {
+		return "", fmt.Errorf("failed to sign certificate, error: %w", err)
+	}
+	privateKey, err := marshalOpenSSHPrivateKey(key, cert.KeyId)
+	if err != nil {
+		return "", err
+	}
+	var content []byte
+	content = append(content, privateKey...)
+	content = append(content, ssh.MarshalAuthorizedKey(cert)...)
+	content = append(content, ssh.MarshalAuthorizedKey(authority.PublicKey())...)
+	return string(content), nil
+}
+
+// marshalOpenSSHPrivateKey encodes the ed25519 or ECDSA private key in the unencrypted
+// openssh-key-v1 format written by ssh-keygen
+func marshalOpenSSHPrivateKey(key crypto.Signer, comment string) ([]byte, error) {
+	publicKey, err := ssh.NewPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	var keyFields []byte
+	switch key := key.(type) {
+	case ed25519.PrivateKey:
+		keyFields = ssh.Marshal(struct {
+			Pub  []byte
+			Priv []byte
+		}{key.Public().(ed25519.PublicKey), key})
+	case *ecdsa.PrivateKey:
+		keyFields = ssh.Marshal(struct {
+			Curve string
+			Pub   []byte
+			D     *big.Int
+		}{"nistp" + strconv.Itoa(key.Curve.Params().BitSize), elliptic.Marshal(key.Curve, key.X, key.Y), key.D})
+	default:
+		return nil, fmt.Errorf("key type %T is not supported for SSH certificates", key)
+	}
+
+	check := make([]byte, 4)
+	if _, err := rand.Read(check); err != nil {
+		return nil, err
+	}
+	privateBlock := ssh.Marshal(struct {
+		Check1  uint32
+		Check2  uint32
+		Keytype string
+		Rest    []byte `ssh:"rest"`
+	}{binary.BigEndian.Uint32(check), binary.BigEndian.Uint32(check), publicKey.Type(), keyFields})
+	privateBlock = append(privateBlock, ssh.Marshal(struct{ Comment string }{comment})...)
+	// the private block is padded to the block size of the cipher, 8 for none
+	for i := byte(1); len(privateBlock)%8 != 0; i++ {
+		privateBlock = append(privateBlock, i)
+	}
+
+	data := ssh.Marshal(struct {
+		CipherName   string
+		KdfName      string
+		KdfOpts      string
+		NumKeys      uint32
+		PubKey       []byte
+		PrivKeyBlock []byte
+	}{"none", "none", "", 1, publicKey.Marshal(), privateBlock})
+	return pem.EncodeToMemory(&pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: append([]byte("openssh-key-v1\x00"), data...)}), nil
+}
+
+// getSSHCertificateFiles splits the content of the SSH certificate into the files of the
+// private key, the certificate and the CA public key
+func getSSHCertificateFiles(fileName string, content []byte) (map[string][]byte, error) {
+	block, rest := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("no private key found")
+	}
+	lines := bytes.Split(bytes.TrimSpace(rest), []byte("\n"))
+	if len(lines) != 2 {
+		return nil, fmt.Errorf("expected the certificate and CA public key after the private key, got %d lines", len(lines))
+	}
+	return map[string][]byte{
+		fileName:                        pem.EncodeToMemory(block),
+		fileName + sshCertificateSuffix: []byte(string(lines[0]) + "\n"),
+		fileName + sshCAPublicKeySuffix: []byte(string(lines[1]) + "\n"),
+	}, nil
+}
@@ -0,0 +1,143 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"golang.org/x/net/context"
+)
+
+// templateFuncs are the helper functions available in the templates in addition to
+// the object function returning the content of a referenced object
+var templateFuncs = template.FuncMap{
+	"base64": func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	},
+	"base64Decode": func(s string) (string, error) {
+		data, err := base64.StdEncoding.DecodeString(s)
+		return string(data), err
+	},
+	"jsonEscape": jsonEscape,
+	"pemSplit":   pemSplit,
+	"trim":       strings.TrimSpace,
+}
+
+// validateTemplate checks if the template options are valid for the given object
+func validateTemplate(kvObject KeyVaultObject, objects []KeyVaultObject) error {
+	if kvObject.ObjectType != VaultObjectTypeTemplate {
+		if len(kvObject.Template) > 0 || len(kvObject.TemplateSecretName) > 0 {
+			return fmt.Errorf("template and templateSecretName only supported for objectType: template")
+		}
+		return nil
+	}
+	if len(kvObject.Template) == 0 && len(kvObject.TemplateSecretName) == 0 {
+		return fmt.Errorf("either template or templateSecretName should be set")
+	}
+	if len(kvObject.Template) > 0 && len(kvObject.TemplateSecretName) > 0 {
+		return fmt.Errorf("only one of template or templateSecretName can be set")
+	}
+	if len(kvObject.ObjectVersion) > 0 {
+		return fmt.Errorf("objectVersion not supported for objectType: template")
+	}
+	if err := validateObjectRefs(kvObject, objects, VaultObjectTypeSecret, VaultObjectTypeCertificate, VaultObjectTypeKey, VaultObjectTypeBundle); err != nil {
+		return err
+	}
+	// inline templates are parsed before any object is fetched to fail early
+	if len(kvObject.Template) > 0 {
+		if _, err := newTemplate(kvObject.ObjectName, nil).Parse(kvObject.Template); err != nil {
+			return fmt.Errorf("failed to parse template, error: %w", err)
+		}
+	}
+	return nil
+}
+
+// getTemplateContent renders the template of the object with the content of the referenced
+// objects. The version is derived from the versions of the referenced objects and the
+// template secret, so it changes when any of the inputs change.
+func (p *Provider) getTemplateContent(ctx context.Context, kvObject KeyVaultObject, fetchedObjects map[string]fetchedObject) ([]byte, string, error) {
+	objects := make(map[string]string)
+	var versions []string
+	for _, ref := range splitList(kvObject.ObjectRefs) {
+		fetched, ok := fetchedObjects[ref]
+		if !ok {
+			return nil, "", fmt.Errorf("object %s referenced in objectRefs not found", ref)
+		}
+		objects[ref] = string(fetched.content)
+		versions = append(versions, fetched.version)
+	}
+
+	text := kvObject.Template
+	if len(kvObject.TemplateSecretName) > 0 {
+		var version string
+		var err error
+		if text, version, err = p.getSecretValue(ctx, kvObject.TemplateSecretName, ""); err != nil {
+			return nil, "", fmt.Errorf("failed to get template from secret %s, error: %w", kvObject.TemplateSecretName, err)
+		}
+		versions = append(versions, version)
+	}
+	content, err := renderTemplate(kvObject.ObjectName, text, objects)
+	if err != nil {
+		return nil, "", err
+	}
+	return content, getCompositeVersion(versions), nil
+}
+
+// newTemplate returns a template with the helper functions and the object function
+// returning the content of the objects
+func newTemplate(name string, objects map[string]string) *template.Template {
+	return template.New(name).Funcs(templateFuncs).Funcs(template.FuncMap{
+		"object": func(ref string) (string, error) {
+			content, ok := objects[ref]
+			if !ok {
+				return "", fmt.Errorf("object %s is not referenced in objectRefs", ref)
+			}
+			return content, nil
+		},
+	})
+}
+
+// renderTemplate renders the template text with the content of the objects
+func renderTemplate(name, text string, objects map[string]string) ([]byte, error) {
+	tmpl, err := newTemplate(name, objects).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template, error: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		return nil, fmt.Errorf("failed to render template, error: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// jsonEscape escapes the string to be used inside a quoted JSON string
+func jsonEscape(s string) (string, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(s); err != nil {
+		return "", err
+	}
+	// drop the quotes and the newline added by the encoder
+	escaped := strings.TrimSuffix(buf.String(), "\n")
+	return escaped[1 : len(escaped)-1], nil
+}
+
+// pemSplit splits the PEM data into the encoded PEM blocks
+func pemSplit(s string) []string {
+	var blocks []string
+	data := []byte(s)
+	for {
+		block, rest := pem.Decode(data)
+		if block == nil {
+			break
+		}
+		data = rest
+		blocks = append(blocks, string(pem.EncodeToMemory(block)))
+	}
+	return blocks
+}
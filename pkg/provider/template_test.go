@@ -0,0 +1,169 @@
+package provider
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestValidateTemplate(t *testing.T) {
+	objects := []KeyVaultObject{
+		{ObjectName: "username", ObjectType: "secret"},
+		{ObjectName: "password", ObjectType: "secret"},
+		{ObjectName: "ca-bundle", ObjectType: "bundle", ObjectRefs: "username"},
+		{ObjectName: "config", ObjectType: "template", ObjectRefs: "username", Template: "{{ object \"username\" }}"},
+	}
+
+	cases := []struct {
+		desc        string
+		object      KeyVaultObject
+		expectedErr error
+	}{
+		{
+			desc:        "not a template",
+			object:      KeyVaultObject{ObjectName: "username", ObjectType: "secret"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "template without objectType template",
+			object:      KeyVaultObject{ObjectName: "username", ObjectType: "secret", Template: "{{ . }}"},
+			expectedErr: fmt.Errorf("template and templateSecretName only supported for objectType: template"),
+		},
+		{
+			desc:        "template not set",
+			object:      KeyVaultObject{ObjectName: "config", ObjectType: "template", ObjectRefs: "username"},
+			expectedErr: fmt.Errorf("either template or templateSecretName should be set"),
+		},
+		{
+			desc:        "template and template secret set",
+			object:      KeyVaultObject{ObjectName: "config", ObjectType: "template", ObjectRefs: "username", Template: "x", TemplateSecretName: "tmpl"},
+			expectedErr: fmt.Errorf("only one of template or templateSecretName can be set"),
+		},
+		{
+			desc:        "template referencing a template",
+			object:      KeyVaultObject{ObjectName: "config2", ObjectType: "template", ObjectRefs: "config", Template: "x"},
+			expectedErr: fmt.Errorf("object config referenced in objectRefs has unsupported objectType: template"),
+		},
+		{
+			desc:        "invalid template",
+			object:      KeyVaultObject{ObjectName: "config", ObjectType: "template", ObjectRefs: "username", Template: "{{ object \"username\" "},
+			expectedErr: fmt.Errorf("failed to parse template, error: template: config:1: unclosed action"),
+		},
+		{
+			desc:        "valid inline template",
+			object:      KeyVaultObject{ObjectName: "config", ObjectType: "template", ObjectRefs: "username,password,ca-bundle", Template: "{{ object \"username\" | trim }}"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "valid template secret",
+			object:      KeyVaultObject{ObjectName: "config", ObjectType: "template", ObjectRefs: "username", TemplateSecretName: "tmpl"},
+			expectedErr: nil,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := validateTemplate(tc.object, objects)
+			if tc.expectedErr != nil && (err == nil || err.Error() != tc.expectedErr.Error()) || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	ca1 := newTestCA(t, "Test CA 1", nil)
+	ca2 := newTestCA(t, "Test CA 2", nil)
+	objects := map[string]string{
+		"username": "admin\n",
+		"password": "p\"a\\ss<word>",
+		"chain":    ca1.pem() + ca2.pem(),
+	}
+
+	cases := []struct {
+		desc            string
+		template        string
+		expectedContent string
+		expectedErr     error
+	}{
+		{
+			desc:            "pgpass",
+			template:        `db.example.com:5432:*:{{ object "username" | trim }}:{{ object "password" }}`,
+			expectedContent: "db.example.com:5432:*:admin:p\"a\\ss<word>",
+		},
+		{
+			desc:            "json escape",
+			template:        `{"password": "{{ object "password" | jsonEscape }}"}`,
+			expectedContent: `{"password": "p\"a\\ss<word>"}`,
+		},
+		{
+			desc:            "base64",
+			template:        `{{ object "username" | trim | base64 }} {{ "YWRtaW4=" | base64Decode }}`,
+			expectedContent: "YWRtaW4= admin",
+		},
+		{
+			desc:            "pem split",
+			template:        `{{ $certs := pemSplit (object "chain") }}{{ len $certs }} {{ index $certs 1 }}`,
+			expectedContent: "2 " + ca2.pem(),
+		},
+		{
+			desc:        "object not referenced",
+			template:    `{{ object "username" }}:{{ object "token" }}`,
+			expectedErr: fmt.Errorf(`failed to render template, error: template: config:1:27: executing "config" at <object "token">: error calling object: object token is not referenced in objectRefs`),
+		},
+		{
+			desc:        "unknown function",
+			template:    `{{ object "username" | exec }}`,
+			expectedErr: fmt.Errorf(`failed to parse template, error: template: config:1: function "exec" not defined`),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			content, err := renderTemplate("config", tc.template, objects)
+			if tc.expectedErr != nil && (err == nil || err.Error() != tc.expectedErr.Error()) || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+			if tc.expectedErr == nil && string(content) != tc.expectedContent {
+				t.Fatalf("expected content: %q, got: %q", tc.expectedContent, string(content))
+			}
+		})
+	}
+}
+
+func TestGetTemplateContent(t *testing.T) {
+	p, err := NewProvider()
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	object := KeyVaultObject{ObjectName: "config", ObjectType: "template", ObjectRefs: "username,password", Template: `{{ object "username" }}:{{ object "password" }}`}
+	fetchedObjects := map[string]fetchedObject{
+		"username": {content: []byte("admin"), version: "v1"},
+		"password": {content: []byte("secret"), version: "v1"},
+	}
+
+	content, version, err := p.getTemplateContent(context.TODO(), object, fetchedObjects)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	if string(content) != "admin:secret" {
+		t.Fatalf("expected content: admin:secret, got: %s", content)
+	}
+
+	// rotating any of the referenced objects changes the version of the template
+	fetchedObjects["password"] = fetchedObject{content: []byte("rotated"), version: "v2"}
+	_, rotatedVersion, err := p.getTemplateContent(context.TODO(), object, fetchedObjects)
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	if version == rotatedVersion {
+		t.Fatalf("expected version to change after rotation, got: %s", version)
+	}
+
+	delete(fetchedObjects, "username")
+	if _, _, err := p.getTemplateContent(context.TODO(), object, fetchedObjects); err == nil || !strings.Contains(err.Error(), "username") {
+		t.Fatalf("expected error naming the missing object, got: %v", err)
+	}
+}
@@ -0,0 +1,161 @@
+package provider
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// tokenRefreshWindow is the remaining lifetime of the access token under which it's refreshed
+	tokenRefreshWindow = 10 * time.Minute
+	// defaultScopeSuffix is the suffix of the scopes of the static permissions of a resource
+	defaultScopeSuffix = "/.default"
+)
+
+// tokenFile is the access token written for objectFormat json
+type tokenFile struct {
+	AccessToken string    `json:"accessToken"`
+	TokenType   string    `json:"tokenType"`
+	Resource    string    `json:"resource"`
+	ExpiresOn   time.Time `json:"expiresOn"`
+}
+
+// validateToken checks if the access token options are valid for the given object
+func validateToken(kvObject KeyVaultObject) error {
+	if kvObject.ObjectType != VaultObjectTypeToken {
+		if len(kvObject.Resource) > 0 || len(kvObject.Scope) > 0 {
+			return fmt.Errorf("resource and scope only supported for objectType: token")
+		}
+		return nil
+	}
+	if len(kvObject.Resource) == 0 && len(kvObject.Scope) == 0 {
+		return fmt.Errorf("objectType: token requires resource or scope to be set")
+	}
+	if len(kvObject.Resource) > 0 && len(kvObject.Scope) > 0 {
+		return fmt.Errorf("only one of resource and scope can be set")
+	}
+	// the token is acquired with the identity of the mount, not read from Key Vault
+	if len(kvObject.ObjectVersion) > 0 {
+		return fmt.Errorf("objectVersion not supported for objectType: token")
+	}
+	if _, err := getTokenResource(kvObject); err != nil {
+		return err
+	}
+	return nil
+}
+
+// getTokenResource returns the resource the access token is requested for. The tokens are
+// requested from the v1 endpoint, so the scope is the resource followed by /.default.
+func getTokenResource(kvObject KeyVaultObject) (string, error) {
+	if len(kvObject.Resource) > 0 {
+		return kvObject.Resource, nil
+	}
+	resource := strings.TrimSuffix(kvObject.Scope, defaultScopeSuffix)
+	if resource == kvObject.Scope || len(resource) == 0 {
+		return "", fmt.Errorf("invalid scope: %v, should be the resource followed by %s", kvObject.Scope, defaultScopeSuffix)
+	}
+	return resource, nil
+}
+
+// getTokenContent acquires an access token for the resource with the identity of the mount.
+// The token written by the previous mount is kept until it's close to expiry, so the token
+// is refreshed on the first rotation poll within tokenRefreshWindow of its expiry. The
+// version is the expiry of the token.
+func (p *Provider) getTokenContent(ctx context.Context, kvObject KeyVaultObject) (string, string, error) {
+	resource, err := getTokenResource(kvObject)
+	if err != nil {
+		return "", "", err
+	}
+	now := time.Now()
+	if previous, err := os.ReadFile(filepath.Join(p.TargetPath, getObjectFileName(kvObject))); err == nil {
+		if expiresOn, ok := getReusableTokenExpiry(previous, kvObject.ObjectFormat, resource, now); ok {
+			klog.V(2).InfoS("access token not due for refresh", "objectName", kvObject.ObjectName, "resource", resource, "expiry", expiresOn, "pod", klog.ObjectRef{Namespace: p.PodNamespace, Name: p.PodName})
+			return string(previous), strconv.FormatInt(expiresOn.Unix(), 10), nil
+		}
+	}
+
+	spt, err := p.GetServicePrincipalToken(resource)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get service principal token for resource %s, error: %w", resource, err)
+	}
+	if err := spt.EnsureFreshWithContext(ctx); err != nil {
+		return "", "", fmt.Errorf("failed to acquire access token for resource %s, error: %w", resource, err)
+	}
+	token := spt.Token()
+	expiresOn := token.Expires().UTC()
+	klog.InfoS("acquired access token", "objectName", kvObject.ObjectName, "resource", resource, "expiry", expiresOn, "pod", klog.ObjectRef{Namespace: p.PodNamespace, Name: p.PodName})
+	content, err := encodeToken(token.AccessToken, token.Type, resource, expiresOn, kvObject.ObjectFormat)
+	if err != nil {
+		return "", "", err
+	}
+	return content, strconv.FormatInt(expiresOn.Unix(), 10), nil
+}
+
+// encodeToken returns the access token as is, or as JSON with the expiry for objectFormat json
+func encodeToken(accessToken, tokenType, resource string, expiresOn time.Time, objectFormat string) (string, error) {
+	if !strings.EqualFold(objectFormat, objectFormatJSON) {
+		return accessToken, nil
+	}
+	if len(tokenType) == 0 {
+		tokenType = "Bearer"
+	}
+	content, err := json.Marshal(tokenFile{AccessToken: accessToken, TokenType: tokenType, Resource: resource, ExpiresOn: expiresOn})
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// getReusableTokenExpiry returns the expiry of the access token written by the previous mount
+// if it's for the resource and doesn't expire within tokenRefreshWindow. The expiry of the
+// plain tokens is read from the exp claim, and the audience has to match the resource, so the
+// opaque tokens and the tokens with an application id audience are refreshed on every mount.
+func getReusableTokenExpiry(content []byte, objectFormat, resource string, now time.Time) (time.Time, bool) {
+	var expiresOn time.Time
+	if strings.EqualFold(objectFormat, objectFormatJSON) {
+		var previous tokenFile
+		if err := json.Unmarshal(content, &previous); err != nil || len(previous.AccessToken) == 0 || previous.Resource != resource {
+			return time.Time{}, false
+		}
+		expiresOn = previous.ExpiresOn
+	} else {
+		var claims struct {
+			Audience string `json:"aud"`
+			Expiry   int64  `json:"exp"`
+		}
+		if err := getUnverifiedJWTClaims(string(content), &claims); err != nil || claims.Expiry == 0 {
+			return time.Time{}, false
+		}
+		if strings.TrimSuffix(claims.Audience, "/") != strings.TrimSuffix(resource, "/") {
+			return time.Time{}, false
+		}
+		expiresOn = time.Unix(claims.Expiry, 0).UTC()
+	}
+	if !now.Add(tokenRefreshWindow).Before(expiresOn) {
+		return time.Time{}, false
+	}
+	return expiresOn, true
+}
+
+// getUnverifiedJWTClaims decodes the claims of the JWT without verifying its signature. It's
+// only used to read the expiry of the tokens the provider received from the token issuers.
+func getUnverifiedJWTClaims(token string, claims interface{}) error {
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("token is not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return fmt.Errorf("failed to decode claims of the token, error: %w", err)
+	}
+	return json.Unmarshal(payload, claims)
+}
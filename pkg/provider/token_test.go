@@ -0,0 +1,231 @@
+package provider
+
+import (
+	"encoding/base64"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestValidateToken(t *testing.T) {
+	cases := []struct {
+		desc        string
+		object      KeyVaultObject
+		expectedErr error
+	}{
+		{
+			desc:        "resource for object type secret",
+			object:      KeyVaultObject{ObjectName: "sql-token", ObjectType: "secret", Resource: "https://database.windows.net/"},
+			expectedErr: fmt.Errorf("resource and scope only supported for objectType: token"),
+		},
+		{
+			desc:        "resource and scope not set",
+			object:      KeyVaultObject{ObjectName: "sql-token", ObjectType: "token"},
+			expectedErr: fmt.Errorf("objectType: token requires resource or scope to be set"),
+		},
+		{
+			desc:        "resource and scope set",
+			object:      KeyVaultObject{ObjectName: "sql-token", ObjectType: "token", Resource: "https://database.windows.net/", Scope: "https://database.windows.net//.default"},
+			expectedErr: fmt.Errorf("only one of resource and scope can be set"),
+		},
+		{
+			desc:        "object version set",
+			object:      KeyVaultObject{ObjectName: "sql-token", ObjectType: "token", Resource: "https://database.windows.net/", ObjectVersion: "v1"},
+			expectedErr: fmt.Errorf("objectVersion not supported for objectType: token"),
+		},
+		{
+			desc:        "scope without /.default",
+			object:      KeyVaultObject{ObjectName: "graph-token", ObjectType: "token", Scope: "https://graph.microsoft.com/User.Read"},
+			expectedErr: fmt.Errorf("invalid scope: https://graph.microsoft.com/User.Read, should be the resource followed by /.default"),
+		},
+		{
+			desc:        "resource",
+			object:      KeyVaultObject{ObjectName: "sql-token", ObjectType: "token", Resource: "https://database.windows.net/"},
+			expectedErr: nil,
+		},
+		{
+			desc:        "scope",
+			object:      KeyVaultObject{ObjectName: "storage-token", ObjectType: "token", Scope: "https://storage.azure.com/.default"},
+			expectedErr: nil,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := validateToken(tc.object)
+			if tc.expectedErr != nil && (err == nil || err.Error() != tc.expectedErr.Error()) || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestGetTokenResource(t *testing.T) {
+	cases := []struct {
+		desc             string
+		object           KeyVaultObject
+		expectedResource string
+	}{
+		{
+			desc:             "resource",
+			object:           KeyVaultObject{Resource: "https://database.windows.net/"},
+			expectedResource: "https://database.windows.net/",
+		},
+		{
+			desc:             "scope",
+			object:           KeyVaultObject{Scope: "https://storage.azure.com/.default"},
+			expectedResource: "https://storage.azure.com",
+		},
+		{
+			desc:             "scope of application id URI",
+			object:           KeyVaultObject{Scope: "api://orders-api/.default"},
+			expectedResource: "api://orders-api",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			resource, err := getTokenResource(tc.object)
+			if err != nil {
+				t.Fatalf("expected nil err, got: %v", err)
+			}
+			if resource != tc.expectedResource {
+				t.Fatalf("expected resource: %s, got: %s", tc.expectedResource, resource)
+			}
+		})
+	}
+}
+
+func TestEncodeToken(t *testing.T) {
+	expiresOn := time.Unix(1700003600, 0).UTC()
+	cases := []struct {
+		desc            string
+		tokenType       string
+		objectFormat    string
+		expectedContent string
+	}{
+		{
+			desc:            "plain token",
+			tokenType:       "Bearer",
+			objectFormat:    "",
+			expectedContent: "eyJ0eXAi.eyJhdWQi.c2ln",
+		},
+		{
+			desc:            "json",
+			tokenType:       "Bearer",
+			objectFormat:    "JSON",
+			expectedContent: `{"accessToken":"eyJ0eXAi.eyJhdWQi.c2ln","tokenType":"Bearer","resource":"https://database.windows.net/","expiresOn":"2023-11-14T23:13:20Z"}`,
+		},
+		{
+			desc:            "json without token type",
+			tokenType:       "",
+			objectFormat:    "json",
+			expectedContent: `{"accessToken":"eyJ0eXAi.eyJhdWQi.c2ln","tokenType":"Bearer","resource":"https://database.windows.net/","expiresOn":"2023-11-14T23:13:20Z"}`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			content, err := encodeToken("eyJ0eXAi.eyJhdWQi.c2ln", tc.tokenType, "https://database.windows.net/", expiresOn, tc.objectFormat)
+			if err != nil {
+				t.Fatalf("expected nil err, got: %v", err)
+			}
+			if content != tc.expectedContent {
+				t.Fatalf("expected content: %s, got: %s", tc.expectedContent, content)
+			}
+		})
+	}
+}
+
+func TestGetReusableTokenExpiry(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	newToken := func(claims string) string {
+		return "eyJ0eXAiOiJKV1QifQ." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".c2ln"
+	}
+	resource := "https://database.windows.net/"
+	expiresOn := now.Add(time.Hour).UTC()
+	jsonToken, err := encodeToken(newToken("{}"), "Bearer", resource, expiresOn, "json")
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+	expiringJSONToken, err := encodeToken(newToken("{}"), "Bearer", resource, now.Add(5*time.Minute), "json")
+	if err != nil {
+		t.Fatalf("expected nil err, got: %v", err)
+	}
+
+	cases := []struct {
+		desc           string
+		content        string
+		objectFormat   string
+		resource       string
+		expectedExpiry time.Time
+		expectedReuse  bool
+	}{
+		{
+			desc:           "plain token",
+			content:        newToken(fmt.Sprintf(`{"aud":"https://database.windows.net","exp":%d}`, expiresOn.Unix())),
+			resource:       resource,
+			expectedExpiry: expiresOn,
+			expectedReuse:  true,
+		},
+		{
+			desc:          "plain token close to expiry",
+			content:       newToken(fmt.Sprintf(`{"aud":"https://database.windows.net/","exp":%d}`, now.Add(5*time.Minute).Unix())),
+			resource:      resource,
+			expectedReuse: false,
+		},
+		{
+			desc:          "plain token for another audience",
+			content:       newToken(fmt.Sprintf(`{"aud":"00000003-0000-0000-c000-000000000000","exp":%d}`, expiresOn.Unix())),
+			resource:      resource,
+			expectedReuse: false,
+		},
+		{
+			desc:          "opaque token",
+			content:       "opaque-token",
+			resource:      resource,
+			expectedReuse: false,
+		},
+		{
+			desc:           "json",
+			content:        jsonToken,
+			objectFormat:   "json",
+			resource:       resource,
+			expectedExpiry: expiresOn,
+			expectedReuse:  true,
+		},
+		{
+			desc:          "json close to expiry",
+			content:       expiringJSONToken,
+			objectFormat:  "json",
+			resource:      resource,
+			expectedReuse: false,
+		},
+		{
+			desc:          "json for another resource",
+			content:       jsonToken,
+			objectFormat:  "json",
+			resource:      "https://storage.azure.com",
+			expectedReuse: false,
+		},
+		{
+			desc:          "plain token written before objectFormat json was set",
+			content:       newToken(fmt.Sprintf(`{"aud":"https://database.windows.net/","exp":%d}`, expiresOn.Unix())),
+			objectFormat:  "json",
+			resource:      resource,
+			expectedReuse: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			expiry, ok := getReusableTokenExpiry([]byte(tc.content), tc.objectFormat, tc.resource, now)
+			if ok != tc.expectedReuse {
+				t.Fatalf("expected reuse: %v, got: %v", tc.expectedReuse, ok)
+			}
+			if !expiry.Equal(tc.expectedExpiry) {
+				t.Fatalf("expected expiry: %v, got: %v", tc.expectedExpiry, expiry)
+			}
+		})
+	}
+}
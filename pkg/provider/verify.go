@@ -0,0 +1,240 @@
+package provider
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/context"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// verifyChainFail fails the mount when the certificate chain can't be verified
+	verifyChainFail = "fail"
+	// verifyChainWarn logs the verification failure and continues with the mount
+	verifyChainWarn = "warn"
+)
+
+// extKeyUsages maps the extended key usage names that can be configured in
+// requiredEKUs to the corresponding x509 extended key usage
+var extKeyUsages = map[string]x509.ExtKeyUsage{
+	"any":             x509.ExtKeyUsageAny,
+	"serverauth":      x509.ExtKeyUsageServerAuth,
+	"clientauth":      x509.ExtKeyUsageClientAuth,
+	"codesigning":     x509.ExtKeyUsageCodeSigning,
+	"emailprotection": x509.ExtKeyUsageEmailProtection,
+	"timestamping":    x509.ExtKeyUsageTimeStamping,
+	"ocspsigning":     x509.ExtKeyUsageOCSPSigning,
+}
+
+// validateVerifyChain checks if the certificate verification options are valid
+// for the given object
+func validateVerifyChain(kvObject KeyVaultObject) error {
+	if len(kvObject.VerifyChain) == 0 {
+		if len(kvObject.TrustRootsSecretName) > 0 || len(kvObject.TrustRootsPEM) > 0 || len(kvObject.RequiredEKUs) > 0 || len(kvObject.RequiredDNSNames) > 0 {
+			return fmt.Errorf("trustRootsSecretName, trustRootsPEM, requiredEKUs and requiredDNSNames require verifyChain to be set")
+		}
+		return nil
+	}
+	if !strings.EqualFold(kvObject.VerifyChain, verifyChainFail) && !strings.EqualFold(kvObject.VerifyChain, verifyChainWarn) {
+		return fmt.Errorf("invalid verifyChain: %v, should be fail or warn", kvObject.VerifyChain)
+	}
+	if kvObject.ObjectType != VaultObjectTypeCertificate && kvObject.ObjectType != VaultObjectTypeSecret {
+		return fmt.Errorf("verifyChain only supported for objectType: cert, secret")
+	}
+	if len(kvObject.TrustRootsSecretName) > 0 && len(kvObject.TrustRootsPEM) > 0 {
+		return fmt.Errorf("only one of trustRootsSecretName or trustRootsPEM can be set")
+	}
+	if _, err := getExtKeyUsages(kvObject.RequiredEKUs); err != nil {
+		return err
+	}
+	return nil
+}
+
+// getExtKeyUsages parses the comma separated list of extended key usages
+func getExtKeyUsages(ekus string) ([]x509.ExtKeyUsage, error) {
+	var usages []x509.ExtKeyUsage
+	for _, name := range splitList(ekus) {
+		usage, ok := extKeyUsages[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("invalid extended key usage: %s", name)
+		}
+		usages = append(usages, usage)
+	}
+	return usages, nil
+}
+
+// splitList splits a comma separated list and drops empty elements
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// verifyCertificateChain verifies the leaf certificate in the PEM content against the trust
+// roots configured for the object. Depending on the verifyChain policy, a verification failure
+// either fails the mount or is only logged.
+func (p *Provider) verifyCertificateChain(ctx context.Context, kvObject KeyVaultObject, content string) error {
+	if len(kvObject.VerifyChain) == 0 {
+		return nil
+	}
+
+	roots, err := p.getTrustRoots(ctx, kvObject)
+	if err != nil {
+		return err
+	}
+	err = verifyChain([]byte(content), roots, kvObject.RequiredEKUs, kvObject.RequiredDNSNames)
+	if err == nil {
+		klog.InfoS("successfully verified certificate chain", "objectName", kvObject.ObjectName, "pod", klog.ObjectRef{Namespace: p.PodNamespace, Name: p.PodName})
+		return nil
+	}
+	if strings.EqualFold(kvObject.VerifyChain, verifyChainWarn) {
+		klog.ErrorS(err, "certificate chain verification failed, continuing as verifyChain is set to warn", "objectName", kvObject.ObjectName, "pod", klog.ObjectRef{Namespace: p.PodNamespace, Name: p.PodName})
+		return nil
+	}
+	return err
+}
+
+// hasCertificateChecks returns true if the certificates of the object are verified before
+// they're mounted
+func hasCertificateChecks(kvObject KeyVaultObject) bool {
+	return len(kvObject.CertificateThumbprints) > 0 || len(kvObject.VerifyChain) > 0 || len(kvObject.RevocationCheck) > 0
+}
+
+// getCertificatePEM returns the content of the object in PEM format. The pfx content
+// is returned as is by Key Vault or built with the output password, so it's decoded to
+// access the certificates in it. Certificates with the PEM content type are returned as
+// PEM for objectFormat pfx unless an output password is set.
+func (p *Provider) getCertificatePEM(ctx context.Context, kvObject KeyVaultObject, content string) (string, error) {
+	if !strings.EqualFold(kvObject.ObjectFormat, objectFormatPFX) {
+		return content, nil
+	}
+	if block, _ := pem.Decode([]byte(content)); block != nil {
+		return content, nil
+	}
+	getPassword := p.getPfxPassword
+	if len(kvObject.PfxOutputPasswordSecretName) > 0 {
+		getPassword = p.getPfxOutputPassword
+	}
+	password, err := getPassword(ctx, kvObject)
+	if err != nil {
+		return "", err
+	}
+	return decodePKCS12(content, password)
+}
+
+// getTrustRoots returns the trust roots to verify the certificate chain against.
+// The roots are read from the Key Vault secret or the inline PEM if configured,
+// otherwise the system roots are used.
+func (p *Provider) getTrustRoots(ctx context.Context, kvObject KeyVaultObject) (*x509.CertPool, error) {
+	var rootsPEM string
+	switch {
+	case len(kvObject.TrustRootsPEM) > 0:
+		rootsPEM = kvObject.TrustRootsPEM
+	case len(kvObject.TrustRootsSecretName) > 0:
+		var err error
+		if rootsPEM, _, err = p.getSecretValue(ctx, kvObject.TrustRootsSecretName, ""); err != nil {
+			return nil, fmt.Errorf("failed to get trust roots from secret %s, error: %w", kvObject.TrustRootsSecretName, err)
+		}
+	default:
+		return x509.SystemCertPool()
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM([]byte(rootsPEM)) {
+		return nil, fmt.Errorf("no valid certificates found in trust roots")
+	}
+	return roots, nil
+}
+
+// verifyChain verifies the leaf certificate in the PEM data using the rest of the
+// certificates as intermediates. The leaf must be valid for all the required extended
+// key usages and DNS names.
+func verifyChain(data []byte, roots *x509.CertPool, requiredEKUs, requiredDNSNames string) error {
+	certs, err := parseCertificates(data)
+	if err != nil {
+		return err
+	}
+	leaf, err := getLeafCertificate(certs)
+	if err != nil {
+		return err
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs {
+		if cert != leaf {
+			intermediates.AddCert(cert)
+		}
+	}
+	usages, err := getExtKeyUsages(requiredEKUs)
+	if err != nil {
+		return err
+	}
+	if len(usages) == 0 {
+		usages = []x509.ExtKeyUsage{x509.ExtKeyUsageAny}
+	}
+	// x509 Verify accepts the chain if any one of the key usages is valid, so
+	// verify each required usage separately
+	for _, usage := range usages {
+		opts := x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{usage},
+		}
+		if _, err := leaf.Verify(opts); err != nil {
+			return fmt.Errorf("failed to verify certificate %q, error: %w", leaf.Subject.String(), err)
+		}
+	}
+	for _, dnsName := range splitList(requiredDNSNames) {
+		if err := leaf.VerifyHostname(dnsName); err != nil {
+			return fmt.Errorf("failed to verify certificate %q, error: %w", leaf.Subject.String(), err)
+		}
+	}
+	return nil
+}
+
+// parseCertificates parses all the certificate blocks in the PEM data
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		block, rest := pem.Decode(data)
+		if block == nil {
+			break
+		}
+		data = rest
+		if block.Type != certificateType {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates found")
+	}
+	return certs, nil
+}
+
+// getLeafCertificate returns the certificate that hasn't been used to sign
+// any of the other certificates
+func getLeafCertificate(certs []*x509.Certificate) (*x509.Certificate, error) {
+	for i := range certs {
+		isParent := false
+		for j := range certs {
+			if i != j && string(certs[j].AuthorityKeyId) == string(certs[i].SubjectKeyId) && len(certs[i].SubjectKeyId) > 0 {
+				isParent = true
+				break
+			}
+		}
+		if !isParent {
+			return certs[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no leaf found")
+}